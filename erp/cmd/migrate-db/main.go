@@ -0,0 +1,94 @@
+// Command migrate-db copies documents that earlier releases wrote to a stray
+// database into the configured application database.
+//
+// Older handlers split writes between "customer_vendor_api" and "adonai-api".
+// Run this once after upgrading:
+//
+//	go run ./cmd/migrate-db -from customer_vendor_api
+//
+// Documents already present in the target are skipped; documents whose _id
+// exists in the target with different content are left alone and reported as
+// conflicts. Pass -dry-run to only count, and -drop-source to
+// remove source collections that migrated without conflicts.
+package main
+
+import (
+	"adonai-api/config"
+	"adonai-api/db"
+	"bytes"
+	"context"
+	"flag"
+	"log"
+	"os"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func main() {
+	configPath := flag.String("config", os.Getenv("CONFIG_FILE"), "path to a YAML config file")
+	from := flag.String("from", "customer_vendor_api", "database to move documents out of")
+	dryRun := flag.Bool("dry-run", false, "report what would be copied without writing")
+	dropSource := flag.Bool("drop-source", false, "drop each source collection once all of its documents are in the target")
+	flag.Parse()
+
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	config.Current = cfg
+	if *from == cfg.Mongo.Database {
+		log.Fatalf("source and target are both %q", *from)
+	}
+
+	config.ConnectDB()
+	ctx := context.Background()
+	defer config.Client.Disconnect(ctx)
+
+	source := config.Client.Database(*from)
+	for _, name := range db.All {
+		copied, conflicts, err := migrate(ctx, source.Collection(string(name)), db.Collection(name), *dryRun)
+		if err != nil {
+			log.Fatalf("%s: %v", name, err)
+		}
+		log.Printf("%s: copied %d, conflicts %d", name, copied, conflicts)
+
+		if *dropSource && !*dryRun && conflicts == 0 {
+			if err := source.Collection(string(name)).Drop(ctx); err != nil {
+				log.Fatalf("%s: dropping source: %v", name, err)
+			}
+		}
+	}
+}
+
+func migrate(ctx context.Context, src, dst *mongo.Collection, dryRun bool) (copied, conflicts int, err error) {
+	cursor, err := src.Find(ctx, bson.M{})
+	if err != nil {
+		return 0, 0, err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var doc bson.Raw = cursor.Current
+		id := doc.Lookup("_id")
+
+		existing := dst.FindOne(ctx, bson.M{"_id": id})
+		if existing.Err() == nil {
+			if raw, _ := existing.Raw(); !bytes.Equal(raw, doc) {
+				conflicts++
+			}
+			continue
+		}
+		if existing.Err() != mongo.ErrNoDocuments {
+			return copied, conflicts, existing.Err()
+		}
+
+		if !dryRun {
+			if _, err := dst.InsertOne(ctx, doc); err != nil {
+				return copied, conflicts, err
+			}
+		}
+		copied++
+	}
+	return copied, conflicts, cursor.Err()
+}
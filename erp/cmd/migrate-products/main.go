@@ -0,0 +1,167 @@
+// Command migrate-products links orders written before the product catalog
+// existed, which only carry a free-text product name, to catalog products.
+//
+// Names are matched ignoring case and surrounding or repeated spaces. An
+// order is linked only when exactly one product has its name; orders whose
+// name matches no product, or several, are left alone and listed at the end.
+// Run after loading the catalog:
+//
+//	go run ./cmd/migrate-products -dry-run
+//	go run ./cmd/migrate-products
+//
+// Pass -create-missing to add an inactive placeholder product (SKU
+// "LEGACY-<hash of the name>", price 0) for each unmatched name, so every
+// order can be linked and the placeholders fixed up in the catalog
+// afterwards.
+package main
+
+import (
+	"adonai-api/config"
+	"adonai-api/db"
+	"adonai-api/models"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"flag"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func main() {
+	configPath := flag.String("config", os.Getenv("CONFIG_FILE"), "path to a YAML config file")
+	dryRun := flag.Bool("dry-run", false, "report what would be linked without writing")
+	createMissing := flag.Bool("create-missing", false, "create an inactive placeholder product for every unmatched name")
+	flag.Parse()
+
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	config.Current = cfg
+
+	config.ConnectDB()
+	ctx := context.Background()
+	defer config.Client.Disconnect(ctx)
+
+	byName, err := loadCatalog(ctx)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	cursor, err := db.Collection(db.Orders).Find(ctx, bson.M{"product_id": bson.M{"$exists": false}})
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer cursor.Close(ctx)
+
+	linked := 0
+	unmatched := map[string]int{}
+	ambiguous := map[string]int{}
+	for cursor.Next(ctx) {
+		var order models.Order
+		if err := cursor.Decode(&order); err != nil {
+			log.Fatal(err)
+		}
+		key := normalize(order.Product)
+		matches := byName[key]
+
+		if len(matches) == 0 && *createMissing && key != "" {
+			placeholder, err := createPlaceholder(ctx, order.Product, key, *dryRun)
+			if err != nil {
+				log.Fatal(err)
+			}
+			byName[key] = []primitive.ObjectID{placeholder}
+			matches = byName[key]
+		}
+
+		switch len(matches) {
+		case 0:
+			unmatched[order.Product]++
+			continue
+		case 1:
+		default:
+			ambiguous[order.Product]++
+			continue
+		}
+
+		if !*dryRun {
+			_, err := db.Collection(db.Orders).UpdateOne(ctx,
+				bson.M{"_id": order.ID, "product_id": bson.M{"$exists": false}},
+				bson.M{"$set": bson.M{"product_id": matches[0]}})
+			if err != nil {
+				log.Fatal(err)
+			}
+		}
+		linked++
+	}
+	if err := cursor.Err(); err != nil {
+		log.Fatal(err)
+	}
+
+	log.Printf("linked %d orders", linked)
+	report("no product named", unmatched)
+	report("several products named", ambiguous)
+}
+
+// loadCatalog maps each normalized product name to the products with it.
+func loadCatalog(ctx context.Context) (map[string][]primitive.ObjectID, error) {
+	cursor, err := db.Collection(db.Products).Find(ctx, bson.M{})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	byName := map[string][]primitive.ObjectID{}
+	for cursor.Next(ctx) {
+		var product models.Product
+		if err := cursor.Decode(&product); err != nil {
+			return nil, err
+		}
+		key := normalize(product.Name)
+		byName[key] = append(byName[key], product.ID)
+	}
+	return byName, cursor.Err()
+}
+
+// createPlaceholder adds an inactive product for a legacy name. The SKU is
+// derived from the name so reruns never collide.
+func createPlaceholder(ctx context.Context, name, key string, dryRun bool) (primitive.ObjectID, error) {
+	now := time.Now().Unix()
+	sum := sha256.Sum256([]byte(key))
+	product := models.Product{
+		ID:          primitive.NewObjectID(),
+		SKU:         "LEGACY-" + strings.ToUpper(hex.EncodeToString(sum[:4])),
+		Name:        strings.TrimSpace(name),
+		Unit:        "each",
+		TaxCategory: "standard",
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+	log.Printf("placeholder %s for %q", product.SKU, product.Name)
+	if dryRun {
+		return product.ID, nil
+	}
+	_, err := db.Collection(db.Products).InsertOne(ctx, product)
+	return product.ID, err
+}
+
+func normalize(name string) string {
+	return strings.ToLower(strings.Join(strings.Fields(name), " "))
+}
+
+func report(what string, names map[string]int) {
+	keys := make([]string, 0, len(names))
+	for name := range names {
+		keys = append(keys, name)
+	}
+	sort.Strings(keys)
+	for _, name := range keys {
+		log.Printf("%s %q: %d orders left unlinked", what, name, names[name])
+	}
+}
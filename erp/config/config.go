@@ -2,10 +2,11 @@ package config
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
-	"os"
 
+	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
@@ -13,7 +14,7 @@ import (
 var Client *mongo.Client
 
 func ConnectDB() {
-	clientOptions := options.Client().ApplyURI("mongodb://localhost:27017")
+	clientOptions := options.Client().ApplyURI(Current.Mongo.URI)
 	var err error
 	Client, err = mongo.Connect(context.TODO(), clientOptions)
 	if err != nil {
@@ -25,9 +26,37 @@ func ConnectDB() {
 		log.Fatal(err)
 	}
 
+	if err := checkTransactions(context.TODO()); err != nil {
+		log.Fatal(err)
+	}
+
 	fmt.Println("Connected to MongoDB!")
 }
 
-func InitEnv() {
-	os.Setenv("JWT_SECRET_KEY", "your_jwt_secret_key")
+// checkTransactions fails unless the server is a replica set member or a
+// mongos router. Stock is posted in multi-document transactions, which a
+// standalone server rejects on the first write, so this is caught at
+// startup instead.
+func checkTransactions(ctx context.Context) error {
+	var hello struct {
+		SetName string `bson:"setName"`
+		Msg     string `bson:"msg"`
+	}
+	if err := Client.Database("admin").RunCommand(ctx, bson.D{{Key: "hello", Value: 1}}).Decode(&hello); err != nil {
+		return fmt.Errorf("mongo: checking for a replica set: %w", err)
+	}
+	if hello.SetName == "" && hello.Msg != "isdbgrid" {
+		return errors.New("mongo: the server is standalone, but stock posting needs transactions, which need a replica set; " +
+			"start mongod with --replSet rs0, run rs.initiate() once, and connect with mongodb://host:27017/?replicaSet=rs0")
+	}
+	return nil
+}
+
+// DisconnectDB closes the MongoDB client, waiting for in-use connections
+// until ctx is done.
+func DisconnectDB(ctx context.Context) error {
+	if Client == nil {
+		return nil
+	}
+	return Client.Disconnect(ctx)
 }
@@ -0,0 +1,70 @@
+// Package db is the registry of MongoDB collections. Every collection the
+// application uses is declared here and lives in the single database named
+// by config.Current.Mongo.Database.
+package db
+
+import (
+	"adonai-api/config"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Name is a registered collection name.
+type Name string
+
+const (
+	Users           Name = "users"
+	Sessions        Name = "sessions"
+	AuthAttempts    Name = "auth_attempts"
+	Customers       Name = "customers"
+	Stores          Name = "stores"
+	Orders          Name = "orders"
+	Chats           Name = "chats"
+	Broadcasts      Name = "broadcasts"
+	Feeds           Name = "feeds"
+	Products        Name = "products"
+	StockMovements  Name = "stock_movements"
+	StockLevels     Name = "stock_levels"
+	StockAlerts     Name = "stock_alerts"
+	StockLots       Name = "stock_lots"
+	Suppliers       Name = "suppliers"
+	PurchaseOrders  Name = "purchase_orders"
+	Transfers       Name = "transfers"
+	Stocktakes      Name = "stocktakes"
+	Invoices        Name = "invoices"
+	InvoiceCounters Name = "invoice_counters"
+)
+
+// All lists every registered collection, in the order migrations visit them.
+var All = []Name{
+	Users,
+	Sessions,
+	AuthAttempts,
+	Customers,
+	Stores,
+	Orders,
+	Chats,
+	Broadcasts,
+	Feeds,
+	Products,
+	StockMovements,
+	StockLevels,
+	StockAlerts,
+	StockLots,
+	Suppliers,
+	PurchaseOrders,
+	Transfers,
+	Stocktakes,
+	Invoices,
+	InvoiceCounters,
+}
+
+// Database returns the configured application database.
+func Database() *mongo.Database {
+	return config.Client.Database(config.Current.Mongo.Database)
+}
+
+// Collection returns the named collection in the application database.
+func Collection(name Name) *mongo.Collection {
+	return Database().Collection(string(name))
+}
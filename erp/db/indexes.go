@@ -0,0 +1,113 @@
+package db
+
+import (
+	"context"
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// indexes backs the filters and sort orders list endpoints offer. Every
+// sort key ends in _id because pages are ordered by (field, _id).
+var indexes = map[Name][]mongo.IndexModel{
+	Users: {
+		// Login looks users up by either, so each must name one account.
+		{Keys: bson.D{{Key: "username", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "phone_number", Value: 1}}, Options: options.Index().SetUnique(true)},
+	},
+	Sessions: {
+		// Refreshing finds a session by its current token, and reuse
+		// detection by one rotated out of it.
+		{Keys: bson.D{{Key: "refresh_hash", Value: 1}}},
+		{Keys: bson.D{{Key: "used_hashes", Value: 1}}},
+		{Keys: bson.D{{Key: "user_id", Value: 1}}},
+		// A session is no use once its refresh token has expired, even for
+		// spotting reuse, so MongoDB deletes it then.
+		{Keys: bson.D{{Key: "purge_at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+	},
+	Orders: {
+		{Keys: bson.D{{Key: "creation_date", Value: -1}, {Key: "_id", Value: -1}}},
+		{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "creation_date", Value: -1}, {Key: "_id", Value: -1}}},
+		{Keys: bson.D{{Key: "store_id", Value: 1}, {Key: "creation_date", Value: -1}, {Key: "_id", Value: -1}}},
+		{Keys: bson.D{{Key: "order_status", Value: 1}, {Key: "creation_date", Value: -1}, {Key: "_id", Value: -1}}},
+	},
+	Customers: {
+		{Keys: bson.D{{Key: "last_name", Value: 1}, {Key: "_id", Value: 1}}},
+		{Keys: bson.D{{Key: "store_id", Value: 1}}},
+	},
+	Stores: {
+		{Keys: bson.D{{Key: "name", Value: 1}, {Key: "_id", Value: 1}}},
+	},
+	Products: {
+		{Keys: bson.D{{Key: "sku", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "name", Value: 1}, {Key: "_id", Value: 1}}},
+		{Keys: bson.D{{Key: "availability.store_id", Value: 1}}},
+		// A barcode identifies one product; products without any are exempt.
+		{Keys: bson.D{{Key: "barcodes.code", Value: 1}}, Options: options.Index().SetUnique(true).
+			SetPartialFilterExpression(bson.M{"barcodes.code": bson.M{"$exists": true}})},
+	},
+	StockMovements: {
+		{Keys: bson.D{{Key: "store_id", Value: 1}, {Key: "product_id", Value: 1}, {Key: "created_at", Value: -1}, {Key: "_id", Value: -1}}},
+		{Keys: bson.D{{Key: "source_id", Value: 1}}},
+		{Keys: bson.D{{Key: "lot_id", Value: 1}}, Options: options.Index().SetSparse(true)},
+	},
+	StockLots: {
+		{Keys: bson.D{{Key: "store_id", Value: 1}, {Key: "product_id", Value: 1}, {Key: "remaining", Value: 1}}},
+		{Keys: bson.D{{Key: "store_id", Value: 1}, {Key: "expires_at", Value: 1}, {Key: "_id", Value: 1}}},
+		{Keys: bson.D{{Key: "origin_lot_id", Value: 1}}, Options: options.Index().SetSparse(true)},
+	},
+	StockLevels: {
+		{Keys: bson.D{{Key: "store_id", Value: 1}, {Key: "product_id", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "store_id", Value: 1}, {Key: "available", Value: 1}, {Key: "_id", Value: 1}}},
+	},
+	StockAlerts: {
+		// At most one open alert per product and store.
+		{
+			Keys:    bson.D{{Key: "store_id", Value: 1}, {Key: "product_id", Value: 1}},
+			Options: options.Index().SetUnique(true).SetPartialFilterExpression(bson.M{"open": true}),
+		},
+		{Keys: bson.D{{Key: "raised_at", Value: -1}, {Key: "_id", Value: -1}}},
+	},
+	Suppliers: {
+		{Keys: bson.D{{Key: "name", Value: 1}, {Key: "_id", Value: 1}}},
+	},
+	PurchaseOrders: {
+		{Keys: bson.D{{Key: "created_at", Value: -1}, {Key: "_id", Value: -1}}},
+		{Keys: bson.D{{Key: "supplier_id", Value: 1}, {Key: "created_at", Value: -1}, {Key: "_id", Value: -1}}},
+		{Keys: bson.D{{Key: "store_id", Value: 1}, {Key: "status", Value: 1}}},
+	},
+	Transfers: {
+		{Keys: bson.D{{Key: "created_at", Value: -1}, {Key: "_id", Value: -1}}},
+		{Keys: bson.D{{Key: "from_store_id", Value: 1}, {Key: "status", Value: 1}}},
+		{Keys: bson.D{{Key: "to_store_id", Value: 1}, {Key: "status", Value: 1}}},
+	},
+	Stocktakes: {
+		{Keys: bson.D{{Key: "store_id", Value: 1}, {Key: "opened_at", Value: -1}, {Key: "_id", Value: -1}}},
+	},
+	Invoices: {
+		// One invoice per order, and no number issued twice at a store.
+		{Keys: bson.D{{Key: "order_id", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "store_id", Value: 1}, {Key: "number", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "customer.user_id", Value: 1}, {Key: "issued_at", Value: -1}, {Key: "_id", Value: -1}}},
+	},
+	Feeds: {
+		{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "created_at", Value: -1}, {Key: "_id", Value: -1}}},
+	},
+}
+
+// EnsureIndexes creates any missing indexes. Existing ones are left alone,
+// so it is safe to call on every start.
+func EnsureIndexes(ctx context.Context) error {
+	for _, name := range All {
+		models, ok := indexes[name]
+		if !ok {
+			continue
+		}
+		if _, err := Collection(name).Indexes().CreateMany(ctx, models); err != nil {
+			return fmt.Errorf("indexes on %s: %w", name, err)
+		}
+	}
+	return nil
+}
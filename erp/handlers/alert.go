@@ -0,0 +1,84 @@
+package handlers
+
+import (
+	"adonai-api/response"
+	"context"
+	"net/http"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var alertList = listSpec{
+	sorts:       []string{"raised_at"},
+	defaultSort: "-raised_at",
+	filters: map[string]listFilter{
+		"store_id":   eqObjectID("store_id"),
+		"product_id": eqObjectID("product_id"),
+		"open":       eqBool("open"),
+	},
+}
+
+// ReorderPointRequest is the body of PUT /stock/reorder-point.
+type ReorderPointRequest struct {
+	StoreID      primitive.ObjectID `json:"store_id" validate:"required"`
+	ProductID    primitive.ObjectID `json:"product_id" validate:"required"`
+	ReorderPoint int                `json:"reorder_point" validate:"min=0"` // 0 turns alerts off
+}
+
+// SetReorderPointHandler sets the level below which a product's available
+// stock at a store raises an alert, and returns the stock level.
+func SetReorderPointHandler(w http.ResponseWriter, r *http.Request) {
+	var req ReorderPointRequest
+	if !decode(w, r, &req) {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, ok := stockedItem(ctx, w, r, req.StoreID, req.ProductID); !ok {
+		return
+	}
+	level, err := Repos.Stock.SetReorderPoint(ctx, req.StoreID, req.ProductID, req.ReorderPoint)
+	if err != nil {
+		response.Internal(w, r, err)
+		return
+	}
+	response.JSON(w, http.StatusOK, level)
+}
+
+// GetAlertsHandler lists low-stock alerts, newest first.
+func GetAlertsHandler(w http.ResponseWriter, r *http.Request) {
+	q, ok := listQuery(w, r, alertList)
+	if !ok {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	page, err := Repos.Alerts.List(ctx, q)
+	if err != nil {
+		listError(w, r, err)
+		return
+	}
+	writePage(w, r, q, page)
+}
+
+// AcknowledgeAlertHandler marks an alert as seen. The alert stays open
+// until stock recovers.
+func AcknowledgeAlertHandler(w http.ResponseWriter, r *http.Request) {
+	_, callerID, ok := callerFromRequest(w, r)
+	if !ok {
+		return
+	}
+	id, ok := idParam(w, r, "id")
+	if !ok {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	alert, err := Repos.Alerts.Acknowledge(ctx, id, callerID, time.Now().Unix())
+	if err != nil {
+		repoError(w, r, err, "Alert not found")
+		return
+	}
+	response.JSON(w, http.StatusOK, alert)
+}
@@ -3,173 +3,257 @@ package handlers
 import (
 	"adonai-api/config"
 	"adonai-api/models"
+	"adonai-api/repository"
+	"adonai-api/response"
 	"context"
-	"encoding/json"
-	"fmt"
-	"math/rand"
+	"errors"
 	"net/http"
-	"os"
+	"strings"
 	"time"
 
 	"github.com/dgrijalva/jwt-go"
-	"github.com/twilio/twilio-go"
-	openapi "github.com/twilio/twilio-go/rest/api/v2010"
-	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"golang.org/x/crypto/bcrypt"
 )
 
-var JwtKey = []byte(os.Getenv("JWT_SECRET_KEY"))
+// JwtKey returns the key access tokens are signed with.
+func JwtKey() []byte {
+	return []byte(config.Current.Auth.JWTSecret)
+}
 
 type Credentials struct {
-	Username string `json:"username"`
-	Password string `json:"password"`
+	Username string `json:"username" validate:"required"`
+	Password string `json:"password" validate:"required"`
+	OTP      string `json:"otp,omitempty"` // second step for vendors with two_factor enabled
+}
+
+// SignUpRequest is the body of /signup. It has no role: everyone who signs
+// up is a customer.
+type SignUpRequest struct {
+	Username    string `json:"username" validate:"required,max=64"`
+	Password    string `json:"password" validate:"required,min=8,max=72"` // bcrypt ignores bytes past 72
+	PhoneNumber string `json:"phone_number" validate:"required,e164"`
 }
 
 type OTPRequest struct {
-	PhoneNumber string `json:"phone_number"`
+	PhoneNumber string `json:"phone_number" validate:"required,e164"`
 	OTP         string `json:"otp"`
 }
 
 type Claims struct {
-	Username string `json:"username"`
-	Role     string `json:"role"` // Add Role here
+	UserID    string `json:"uid"`
+	Username  string `json:"username"`
+	Role      string `json:"role"`
+	SessionID string `json:"sid"`
 	jwt.StandardClaims
 }
 
-func generateOTP() string {
-	rand.Seed(time.Now().UnixNano())
-	return fmt.Sprintf("%06d", rand.Intn(1000000))
+type contextKey string
+
+const claimsContextKey contextKey = "user"
+
+// WithClaims returns a copy of ctx carrying the authenticated caller's claims.
+func WithClaims(ctx context.Context, claims *Claims) context.Context {
+	return context.WithValue(ctx, claimsContextKey, claims)
 }
 
-func sendSMS(to string, body string) error {
-	client := twilio.NewRestClientWithParams(twilio.ClientParams{
-		Username: os.Getenv("TWILIO_ACCOUNT_SID"),
-		Password: os.Getenv("TWILIO_AUTH_TOKEN"),
-	})
+// ClaimsFromContext returns the claims stored by JwtAuthMiddleware, if any.
+func ClaimsFromContext(ctx context.Context) (*Claims, bool) {
+	claims, ok := ctx.Value(claimsContextKey).(*Claims)
+	return claims, ok && claims != nil
+}
 
-	params := &openapi.CreateMessageParams{}
-	params.SetTo(to)
-	params.SetFrom(os.Getenv("TWILIO_PHONE_NUMBER"))
-	params.SetBody(body)
+// HasRole reports whether the caller has the given role, ignoring case.
+func (c *Claims) HasRole(role string) bool {
+	return strings.EqualFold(c.Role, role)
+}
 
-	_, err := client.Api.CreateMessage(params)
-	return err
+// IsStaff reports whether the caller is a vendor or an admin, who may act
+// on other users' records.
+func (c *Claims) IsStaff() bool {
+	return c.HasRole(models.RoleVendor) || c.HasRole(models.RoleAdmin)
 }
 
-func SignUpHandler(w http.ResponseWriter, r *http.Request) {
-	var user models.User
-	err := json.NewDecoder(r.Body).Decode(&user)
+// callerFromRequest returns the caller's claims and user ID, writing a 401
+// when the request carries neither.
+func callerFromRequest(w http.ResponseWriter, r *http.Request) (*Claims, primitive.ObjectID, bool) {
+	claims, ok := ClaimsFromContext(r.Context())
+	if !ok {
+		response.Unauthorized(w, r, "Unauthorized")
+		return nil, primitive.NilObjectID, false
+	}
+	userID, err := primitive.ObjectIDFromHex(claims.UserID)
+	if err != nil {
+		response.Unauthorized(w, r, "Unauthorized")
+		return nil, primitive.NilObjectID, false
+	}
+	return claims, userID, true
+}
+
+// actingUserID resolves whose data a request is about. Customers always act
+// on their own records; vendors and admins may pass user_id to act on
+// someone else's.
+func actingUserID(w http.ResponseWriter, r *http.Request) (*Claims, primitive.ObjectID, bool) {
+	claims, callerID, ok := callerFromRequest(w, r)
+	if !ok {
+		return nil, primitive.NilObjectID, false
+	}
+	param := r.URL.Query().Get("user_id")
+	if param == "" || !claims.IsStaff() {
+		return claims, callerID, true
+	}
+	userID, err := primitive.ObjectIDFromHex(param)
 	if err != nil {
-		http.Error(w, "Bad request", http.StatusBadRequest)
+		response.BadRequest(w, r, "Invalid user_id")
+		return nil, primitive.NilObjectID, false
+	}
+	return claims, userID, true
+}
+
+// SignUpHandler registers a customer. Vendors and admins are made by an admin
+// through SetUserRoleHandler.
+func SignUpHandler(w http.ResponseWriter, r *http.Request) {
+	var req SignUpRequest
+	if !decode(w, r, &req) {
 		return
 	}
 
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(user.Password), bcrypt.DefaultCost)
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
 	if err != nil {
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		response.Internal(w, r, err)
 		return
 	}
-	user.Password = string(hashedPassword)
+	user := models.User{
+		Username:    req.Username,
+		Password:    string(hashedPassword),
+		Role:        models.RoleCustomer,
+		PhoneNumber: req.PhoneNumber,
+	}
 
-	collection := config.Client.Database("customer_vendor_api").Collection("users")
-	ctx, _ := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
 
-	result, err := collection.InsertOne(ctx, user)
+	err = Repos.Users.Create(ctx, &user)
+	if errors.Is(err, repository.ErrDuplicate) {
+		response.Conflict(w, r, "A user with this username or phone number already exists")
+		return
+	}
 	if err != nil {
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		response.Internal(w, r, err)
 		return
 	}
 
-	json.NewEncoder(w).Encode(result)
+	writeCreated(w, "", user.ID)
 }
 
 func RequestOTPHandler(w http.ResponseWriter, r *http.Request) {
 	var phoneRequest OTPRequest
-	err := json.NewDecoder(r.Body).Decode(&phoneRequest)
-	if err != nil {
-		http.Error(w, "Bad request", http.StatusBadRequest)
+	if !decode(w, r, &phoneRequest) {
 		return
 	}
 
-	collection := config.Client.Database("customer_vendor_api").Collection("users")
-	ctx, _ := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
 
-	var user models.User
-	err = collection.FindOne(ctx, bson.M{"phone_number": phoneRequest.PhoneNumber}).Decode(&user)
+	user, err := Repos.Users.FindByPhone(ctx, phoneRequest.PhoneNumber)
 	if err != nil {
-		http.Error(w, "User not found", http.StatusNotFound)
+		response.NotFound(w, r, "User not found")
+		return
+	}
+
+	if !sendOTPResponse(w, r, user, issueOTP(ctx, user)) {
 		return
 	}
 
-	otp := generateOTP()
-	expirationTime := time.Now().Add(5 * time.Minute).Unix() // OTP expires in 5 minutes
-	user.OTP = otp
-	user.OTPExpiresAt = expirationTime
+	response.Message(w, http.StatusOK, "OTP sent successfully")
+}
 
-	_, err = collection.UpdateOne(ctx, bson.M{"_id": user.ID}, bson.M{
-		"$set": bson.M{
-			"otp":            user.OTP,
-			"otp_expires_at": user.OTPExpiresAt,
-		},
-	})
-	if err != nil {
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+func VerifyOTPHandler(w http.ResponseWriter, r *http.Request) {
+	var otpRequest OTPRequest
+	if !decode(w, r, &otpRequest) {
 		return
 	}
 
-	err = sendSMS(phoneRequest.PhoneNumber, "Your OTP is: "+otp)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	ipKey := otpIPKey(clientIP(r))
+	if !allowAttempt(ctx, w, r, ipKey) {
+		return
+	}
+
+	user, err := Repos.Users.FindByPhone(ctx, otpRequest.PhoneNumber)
 	if err != nil {
-		http.Error(w, "Failed to send OTP", http.StatusInternalServerError)
+		recordFailure(ctx, ipKey, config.Current.Auth.OTPMaxPerIP)
+		response.NotFound(w, r, "User not found")
+		return
+	}
+
+	if !verifyOTPAttempt(ctx, w, r, user, otpRequest.OTP) {
+		return
+	}
+
+	if err := startSession(ctx, w, r, user); err != nil {
+		response.Internal(w, r, err)
 		return
 	}
 
-	fmt.Fprintf(w, "OTP sent successfully")
+	response.Message(w, http.StatusOK, "Login successful")
 }
 
-func VerifyOTPHandler(w http.ResponseWriter, r *http.Request) {
-	var otpRequest OTPRequest
-	err := json.NewDecoder(r.Body).Decode(&otpRequest)
-	if err != nil {
-		http.Error(w, "Bad request", http.StatusBadRequest)
+// LoginHandler authenticates with username and password. Vendors that have
+// two_factor enabled get an OTP on the first call and must repeat the request
+// with the otp field filled in.
+func LoginHandler(w http.ResponseWriter, r *http.Request) {
+	var creds Credentials
+	if !decode(w, r, &creds) {
 		return
 	}
 
-	collection := config.Client.Database("customer_vendor_api").Collection("users")
-	ctx, _ := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
 
-	var user models.User
-	err = collection.FindOne(ctx, bson.M{"phone_number": otpRequest.PhoneNumber}).Decode(&user)
-	if err != nil {
-		http.Error(w, "User not found", http.StatusNotFound)
+	// Wrong passwords count against the username and the client IP, as
+	// wrong OTPs do, so neither can be guessed at without limit.
+	userKey, ipKey := loginUserKey(creds.Username), loginIPKey(clientIP(r))
+	if !allowAttempt(ctx, w, r, userKey, ipKey) {
 		return
 	}
 
-	if user.OTP != otpRequest.OTP || time.Now().Unix() > user.OTPExpiresAt {
-		http.Error(w, "Invalid or expired OTP", http.StatusUnauthorized)
+	user, err := Repos.Users.FindByUsername(ctx, creds.Username)
+	if err != nil && !errors.Is(err, repository.ErrNotFound) {
+		response.Internal(w, r, err)
+		return
+	}
+	if err != nil || bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(creds.Password)) != nil {
+		recordFailure(ctx, userKey, config.Current.Auth.LoginMaxPerUser)
+		recordFailure(ctx, ipKey, config.Current.Auth.LoginMaxPerIP)
+		response.Unauthorized(w, r, "Invalid username or password")
 		return
 	}
+	resetAttempts(ctx, userKey)
 
-	// Generate JWT Token
-	expirationTime := time.Now().Add(24 * time.Hour)
-	claims := &Claims{
-		Username: user.Username,
-		StandardClaims: jwt.StandardClaims{
-			ExpiresAt: expirationTime.Unix(),
-		},
+	if requiresSecondFactor(user) {
+		if creds.OTP == "" {
+			if !sendOTPResponse(w, r, user, issueOTP(ctx, user)) {
+				return
+			}
+			response.Message(w, http.StatusAccepted, "OTP sent successfully")
+			return
+		}
+		if !verifyOTPAttempt(ctx, w, r, user, creds.OTP) {
+			return
+		}
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	tokenString, err := token.SignedString(JwtKey)
-	if err != nil {
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	if err := startSession(ctx, w, r, user); err != nil {
+		response.Internal(w, r, err)
 		return
 	}
 
-	http.SetCookie(w, &http.Cookie{
-		Name:    "token",
-		Value:   tokenString,
-		Expires: expirationTime,
-	})
+	response.Message(w, http.StatusOK, "Login successful")
+}
 
-	fmt.Fprintf(w, "Login successful")
+func requiresSecondFactor(user *models.User) bool {
+	return user.TwoFactor && strings.EqualFold(user.Role, "vendor")
 }
@@ -1,81 +1,86 @@
 package handlers
 
 import (
-	"adonai-api/config"
 	"adonai-api/models"
+	"adonai-api/response"
 	"context"
-	"encoding/json"
 	"net/http"
 	"time"
 
-	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
+// SendMessageHandler appends a message to the caller's chat. Vendors and
+// admins reply to a customer by passing that customer's user_id.
 func SendMessageHandler(w http.ResponseWriter, r *http.Request) {
+	claims, chatUserID, ok := actingUserID(w, r)
+	if !ok {
+		return
+	}
+	callerID, _ := primitive.ObjectIDFromHex(claims.UserID)
+
 	var msg models.Message
-	_ = json.NewDecoder(r.Body).Decode(&msg)
+	if !decode(w, r, &msg) {
+		return
+	}
+	msg.FromUserID = callerID
+	msg.ToAdmin = chatUserID == callerID
 	msg.Timestamp = time.Now()
 
-	// collection := config.Client.Database("adonai-api").Collection("chats")
-	ctx, _ := context.WithTimeout(context.Background(), 5*time.Second)
-
-	// Check if a chat already exists between the user and admin
-	chatCollection := config.Client.Database("adonai-api").Collection("chats")
-	var chat models.Chat
-	err := chatCollection.FindOne(ctx, bson.M{"user_id": msg.FromUserID}).Decode(&chat)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
 
-	if err != nil { // If no chat exists, create a new one
-		chat = models.Chat{
-			UserID:   msg.FromUserID,
-			Messages: []models.Message{msg},
-		}
-		_, err = chatCollection.InsertOne(ctx, chat)
-		if err != nil {
-			http.Error(w, "Internal server error", http.StatusInternalServerError)
-			return
-		}
-	} else { // If chat exists, update it with the new message
-		chat.Messages = append(chat.Messages, msg)
-		_, err = chatCollection.UpdateOne(ctx, bson.M{"_id": chat.ID}, bson.M{
-			"$set": bson.M{"messages": chat.Messages},
-		})
-		if err != nil {
-			http.Error(w, "Internal server error", http.StatusInternalServerError)
-			return
-		}
+	// The first message of a reply thread records which admin owns the chat.
+	adminID := primitive.NilObjectID
+	if !msg.ToAdmin {
+		adminID = callerID
+	}
+	err := Repos.Chats.AppendMessage(ctx, chatUserID, adminID, msg)
+	if err != nil {
+		response.Internal(w, r, err)
+		return
 	}
-	json.NewEncoder(w).Encode("Message sent")
+	response.Message(w, http.StatusOK, "Message sent")
 }
 
 func GetChatHistoryHandler(w http.ResponseWriter, r *http.Request) {
-	userID, _ := primitive.ObjectIDFromHex(r.URL.Query().Get("user_id"))
+	_, userID, ok := actingUserID(w, r)
+	if !ok {
+		return
+	}
 
-	collection := config.Client.Database("adonai-api").Collection("chats")
-	ctx, _ := context.WithTimeout(context.Background(), 30*time.Second)
-	var chat models.Chat
-	err := collection.FindOne(ctx, bson.M{"user_id": userID}).Decode(&chat)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	chat, err := Repos.Chats.FindByUser(ctx, userID)
 	if err != nil {
-		http.Error(w, "Chat not found", http.StatusNotFound)
+		response.NotFound(w, r, "Chat not found")
 		return
 	}
-	json.NewEncoder(w).Encode(chat.Messages)
+	response.JSON(w, http.StatusOK, chat.Messages)
 }
 
 func BroadcastMessageHandler(w http.ResponseWriter, r *http.Request) {
+	_, callerID, ok := callerFromRequest(w, r)
+	if !ok {
+		return
+	}
+
 	var msg models.BroadcastMessage
-	_ = json.NewDecoder(r.Body).Decode(&msg)
+	if !decode(w, r, &msg) {
+		return
+	}
+	msg.AdminID = callerID
 	msg.Timestamp = time.Now()
 
-	collection := config.Client.Database("adonai-api").Collection("broadcasts")
-	ctx, _ := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
 
-	_, err := collection.InsertOne(ctx, msg)
+	err := Repos.Chats.CreateBroadcast(ctx, &msg)
 	if err != nil {
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		response.Internal(w, r, err)
 		return
 	}
 
 	// Optionally, distribute the broadcast message to all active users in real time.
-	json.NewEncoder(w).Encode("Broadcast sent")
+	response.Message(w, http.StatusOK, "Broadcast sent")
 }
@@ -1,91 +1,118 @@
 package handlers
 
 import (
-	"adonai-api/config"
 	"adonai-api/models"
+	"adonai-api/repository"
+	"adonai-api/response"
 	"context"
-	"encoding/json"
 	"net/http"
 	"time"
 
-	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
+// CreateCustomerHandler adds a customer record. A customer can only add
+// one for themselves; vendors and admins can add one for anybody.
 func CreateCustomerHandler(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
+	claims, callerID, ok := callerFromRequest(w, r)
+	if !ok {
+		return
+	}
 	var customer models.Customer
-	_ = json.NewDecoder(r.Body).Decode(&customer)
-	collection := config.Client.Database("adonai-api").Collection("customers")
-	ctx, _ := context.WithTimeout(context.Background(), 5*time.Second)
-	result, _ := collection.InsertOne(ctx, customer)
-	json.NewEncoder(w).Encode(result)
+	if !decode(w, r, &customer) {
+		return
+	}
+	if !claims.IsStaff() {
+		customer.UserID = callerID
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	err := Repos.Customers.Create(ctx, &customer)
+	if err != nil {
+		response.Internal(w, r, err)
+		return
+	}
+	writeCreated(w, "/customer?id="+customer.ID.Hex(), customer.ID)
 }
 
+// GetCustomerHandler returns a customer record. Customers only see their
+// own; anyone else's is reported as not found.
 func GetCustomerHandler(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
-	params := r.URL.Query()
-	id, _ := primitive.ObjectIDFromHex(params.Get("id"))
-	var customer models.Customer
-	collection := config.Client.Database("adonai-api").Collection("customers")
-	ctx, _ := context.WithTimeout(context.Background(), 30*time.Second)
-	err := collection.FindOne(ctx, models.Customer{ID: id}).Decode(&customer)
+	claims, callerID, ok := callerFromRequest(w, r)
+	if !ok {
+		return
+	}
+	id, ok := idParam(w, r, "id")
+	if !ok {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	customer, err := Repos.Customers.FindByID(ctx, id)
+	if err == nil && !claims.IsStaff() && customer.UserID != callerID {
+		err = repository.ErrNotFound
+	}
 	if err != nil {
-		http.Error(w, "Customer not found", http.StatusNotFound)
+		repoError(w, r, err, "Customer not found")
 		return
 	}
-	json.NewEncoder(w).Encode(customer)
+	response.JSON(w, http.StatusOK, customer)
+}
+
+var customerList = listSpec{
+	sorts: []string{"first_name", "last_name"},
+	filters: map[string]listFilter{
+		"store_id": eqObjectID("store_id"),
+		"user_id":  eqObjectID("user_id"),
+	},
 }
 
 func GetCustomersHandler(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
-	var customers []models.Customer
-	collection := config.Client.Database("adonai-api").Collection("customers")
-	ctx, _ := context.WithTimeout(context.Background(), 30*time.Second)
-	cursor, err := collection.Find(ctx, bson.M{})
-	if err != nil {
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	q, ok := listQuery(w, r, customerList)
+	if !ok {
 		return
 	}
-	defer cursor.Close(ctx)
-	for cursor.Next(ctx) {
-		var customer models.Customer
-		cursor.Decode(&customer)
-		customers = append(customers, customer)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	page, err := Repos.Customers.List(ctx, q)
+	if err != nil {
+		listError(w, r, err)
+		return
 	}
-	json.NewEncoder(w).Encode(customers)
+	writePage(w, r, q, page)
 }
 
 func UpdateCustomerHandler(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
+	id, ok := idParam(w, r, "id")
+	if !ok {
+		return
+	}
 	var customer models.Customer
-	_ = json.NewDecoder(r.Body).Decode(&customer)
-	collection := config.Client.Database("adonai-api").Collection("customers")
-	ctx, _ := context.WithTimeout(context.Background(), 5*time.Second)
-	params := r.URL.Query()
-	id, _ := primitive.ObjectIDFromHex(params.Get("id"))
-	filter := bson.M{"_id": id}
-	update := bson.M{
-		"$set": customer,
+	if !decode(w, r, &customer) {
+		return
 	}
-	_, err := collection.UpdateOne(ctx, filter, update)
+	customer.ID = primitive.NilObjectID // the id comes from the query, never the body
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	updated, err := Repos.Customers.Update(ctx, id, &customer)
 	if err != nil {
-		http.Error(w, "Customer not found", http.StatusNotFound)
+		repoError(w, r, err, "Customer not found")
 		return
 	}
-	json.NewEncoder(w).Encode(customer)
+	response.JSON(w, http.StatusOK, updated)
 }
 
 func DeleteCustomerHandler(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
-	params := r.URL.Query()
-	id, _ := primitive.ObjectIDFromHex(params.Get("id"))
-	collection := config.Client.Database("adonai-api").Collection("customers")
-	ctx, _ := context.WithTimeout(context.Background(), 30*time.Second)
-	_, err := collection.DeleteOne(ctx, bson.M{"_id": id})
+	id, ok := idParam(w, r, "id")
+	if !ok {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	err := Repos.Customers.Delete(ctx, id)
 	if err != nil {
-		http.Error(w, "Customer not found", http.StatusNotFound)
+		repoError(w, r, err, "Customer not found")
 		return
 	}
-	json.NewEncoder(w).Encode("Customer deleted")
+	w.WriteHeader(http.StatusNoContent)
 }
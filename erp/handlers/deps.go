@@ -0,0 +1,106 @@
+package handlers
+
+import (
+	"adonai-api/notify"
+	"adonai-api/repository"
+	"adonai-api/response"
+	"adonai-api/validate"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Repos is the storage every handler works against. main wires it to
+// MongoDB; tests use repository.NewMemory.
+var Repos *repository.Repositories
+
+// SMS delivers OTP codes. main wires it to the configured provider.
+var SMS notify.Notifier
+
+// writeCreated answers a create with 201, a Location header pointing at the
+// new resource when it has one, and the {"InsertedID": ...} body create
+// endpoints have always returned.
+func writeCreated(w http.ResponseWriter, location string, id primitive.ObjectID) {
+	if location != "" {
+		w.Header().Set("Location", location)
+	}
+	response.JSON(w, http.StatusCreated, map[string]primitive.ObjectID{"InsertedID": id})
+}
+
+// idParam parses the named query parameter as an ObjectID, writing a 400
+// when it is missing or malformed.
+func idParam(w http.ResponseWriter, r *http.Request, name string) (primitive.ObjectID, bool) {
+	id, err := primitive.ObjectIDFromHex(r.URL.Query().Get(name))
+	if err != nil {
+		response.BadRequest(w, r, "Invalid "+name)
+		return primitive.NilObjectID, false
+	}
+	return id, true
+}
+
+// repoError writes a 404 with notFound for repository.ErrNotFound and a 500
+// for anything else.
+func repoError(w http.ResponseWriter, r *http.Request, err error, notFound string) {
+	if errors.Is(err, repository.ErrNotFound) {
+		response.NotFound(w, r, notFound)
+		return
+	}
+	response.Internal(w, r, err)
+}
+
+// decode reads the JSON request body into v and checks its validate tags.
+// It writes a 400 for a body that isn't JSON and a 422 listing the failing
+// fields, and reports whether the handler may go on.
+func decode(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		var syntax *json.SyntaxError
+		if errors.As(err, &syntax) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			response.BadRequest(w, r, "Request body must be a JSON object")
+		} else {
+			// The decoder's message names Go types, so it is only logged.
+			log.Printf("request %s: %s %s: decoding body: %v", response.RequestID(r.Context()), r.Method, r.URL.Path, err)
+			response.BadRequest(w, r, "Invalid request body")
+		}
+		return false
+	}
+	var invalid validate.Errors
+	if err := validate.Struct(v); errors.As(err, &invalid) {
+		response.Invalid(w, r, invalid)
+		return false
+	}
+	return true
+}
+
+// statusError is returned from a document's update function when the
+// document's status doesn't allow the change.
+type statusError struct{ status string }
+
+func (e statusError) Error() string {
+	return "status " + e.status + " does not allow this"
+}
+
+// writeUpdated writes the outcome of a repository update whose function
+// may fail with validate.Errors or a statusError, and which may post stock:
+// a 422, a 409 naming the status or the lack of stock, a 404 naming what
+// was updated, or the updated document.
+func writeUpdated(w http.ResponseWriter, r *http.Request, updated interface{}, err error, what string) {
+	var invalid validate.Errors
+	var status statusError
+	switch {
+	case errors.As(err, &invalid):
+		response.Invalid(w, r, invalid)
+	case errors.As(err, &status):
+		response.Conflict(w, r, "The "+what+" is "+status.status+", which doesn't allow this")
+	case errors.Is(err, repository.ErrInsufficientStock):
+		insufficientStock(w, r)
+	case err != nil:
+		repoError(w, r, err, strings.ToUpper(what[:1])+what[1:]+" not found")
+	default:
+		response.JSON(w, http.StatusOK, updated)
+	}
+}
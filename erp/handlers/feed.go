@@ -1,49 +1,63 @@
 package handlers
 
 import (
-	"adonai-api/config"
 	"adonai-api/models"
+	"adonai-api/repository"
+	"adonai-api/response"
 	"context"
-	"encoding/json"
 	"net/http"
 	"time"
-
-	"go.mongodb.org/mongo-driver/bson"
-	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 func CreateFeedHandler(w http.ResponseWriter, r *http.Request) {
+	_, callerID, ok := callerFromRequest(w, r)
+	if !ok {
+		return
+	}
+
 	var feed models.Feed
-	_ = json.NewDecoder(r.Body).Decode(&feed)
+	if !decode(w, r, &feed) {
+		return
+	}
+	feed.UserID = callerID
 	feed.CreatedAt = time.Now().Unix()
 
-	collection := config.Client.Database("adonai-api").Collection("feeds")
-	ctx, _ := context.WithTimeout(context.Background(), 5*time.Second)
-	result, err := collection.InsertOne(ctx, feed)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	err := Repos.Feeds.Create(ctx, &feed)
 	if err != nil {
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		response.Internal(w, r, err)
 		return
 	}
-	json.NewEncoder(w).Encode(result)
+	writeCreated(w, "", feed.ID)
+}
+
+var feedList = listSpec{
+	sorts:       []string{"created_at"},
+	defaultSort: "-created_at",
+	filters: map[string]listFilter{
+		"created_from": unixFrom("created_at"),
+		"created_to":   unixTo("created_at"),
+	},
 }
 
 func GetFeedsHandler(w http.ResponseWriter, r *http.Request) {
-	userID, _ := primitive.ObjectIDFromHex(r.URL.Query().Get("user_id"))
+	_, userID, ok := actingUserID(w, r)
+	if !ok {
+		return
+	}
 
-	collection := config.Client.Database("adonai-api").Collection("feeds")
-	ctx, _ := context.WithTimeout(context.Background(), 30*time.Second)
-	cursor, err := collection.Find(ctx, bson.M{"user_id": userID})
-	if err != nil {
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	q, ok := listQuery(w, r, feedList)
+	if !ok {
 		return
 	}
-	defer cursor.Close(ctx)
 
-	var feeds []models.Feed
-	for cursor.Next(ctx) {
-		var feed models.Feed
-		cursor.Decode(&feed)
-		feeds = append(feeds, feed)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	page, err := Repos.Feeds.List(ctx, q.Where("user_id", repository.OpEq, userID))
+	if err != nil {
+		listError(w, r, err)
+		return
 	}
-	json.NewEncoder(w).Encode(feeds)
+	writePage(w, r, q, page)
 }
@@ -0,0 +1,30 @@
+package handlers
+
+import (
+	"adonai-api/response"
+	"context"
+	"log"
+	"net/http"
+	"time"
+)
+
+// HealthzHandler is the liveness probe: it answers as long as the process
+// can serve HTTP at all.
+func HealthzHandler(w http.ResponseWriter, r *http.Request) {
+	response.JSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// ReadyzHandler is the readiness probe: it fails while MongoDB is unreachable
+// so the load balancer stops routing traffic here.
+func ReadyzHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 2*time.Second)
+	defer cancel()
+
+	if err := Repos.Ping(ctx); err != nil {
+		// The probe is unauthenticated, so why the ping failed is only logged.
+		log.Printf("request %s: readiness: %v", response.RequestID(r.Context()), err)
+		response.JSON(w, http.StatusServiceUnavailable, map[string]string{"status": "unavailable"})
+		return
+	}
+	response.JSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
@@ -0,0 +1,255 @@
+package handlers
+
+import (
+	"adonai-api/models"
+	"adonai-api/pdf"
+	"adonai-api/repository"
+	"adonai-api/response"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+var invoiceList = listSpec{
+	sorts:       []string{"number", "issued_at", "grand_total"},
+	defaultSort: "-issued_at",
+	filters: map[string]listFilter{
+		"store_id":    eqObjectID("store_id"),
+		"order_id":    eqObjectID("order_id"),
+		"user_id":     eqObjectID("customer.user_id"),
+		"issued_from": unixFrom("issued_at"),
+		"issued_to":   unixTo("issued_at"),
+	},
+}
+
+// IssueInvoiceHandler invoices a delivered order. POST /invoice?order_id=
+func IssueInvoiceHandler(w http.ResponseWriter, r *http.Request) {
+	_, callerID, ok := callerFromRequest(w, r)
+	if !ok {
+		return
+	}
+	orderID, ok := idParam(w, r, "order_id")
+	if !ok {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	order, err := Repos.Orders.FindByID(ctx, orderID)
+	if err != nil {
+		repoError(w, r, err, "Order not found")
+		return
+	}
+	switch {
+	case models.NormalizeStatus(order.OrderStatus) != models.StatusDelivered:
+		response.Conflict(w, r, "Only delivered orders can be invoiced")
+		return
+	case order.StoreID.IsZero() || len(order.Lines) == 0:
+		response.Conflict(w, r, "Only orders with a store and priced lines can be invoiced")
+		return
+	}
+
+	invoice, err := newInvoice(ctx, order)
+	if err != nil {
+		response.Internal(w, r, err)
+		return
+	}
+	invoice.IssuedBy, invoice.IssuedAt = callerID, time.Now().Unix()
+	err = Repos.Invoices.Issue(ctx, invoice)
+	if errors.Is(err, repository.ErrDuplicate) {
+		if existing, err := Repos.Invoices.FindByOrder(ctx, orderID); err == nil {
+			w.Header().Set("Location", "/invoice?id="+existing.ID.Hex())
+		}
+		response.Conflict(w, r, "This order has already been invoiced")
+		return
+	}
+	if err != nil {
+		response.Internal(w, r, err)
+		return
+	}
+	writeCreated(w, "/invoice?id="+invoice.ID.Hex(), invoice.ID)
+}
+
+// newInvoice copies an order and its customer and store as they are now.
+func newInvoice(ctx context.Context, order *models.Order) (*models.Invoice, error) {
+	store, err := Repos.Stores.FindByID(ctx, order.StoreID)
+	if err != nil {
+		return nil, err
+	}
+	invoice := &models.Invoice{
+		StoreID:       order.StoreID,
+		OrderID:       order.ID,
+		Store:         models.InvoiceStore{Name: store.Name, PhoneNumber: store.PhoneNumber},
+		Customer:      models.InvoiceCustomer{UserID: order.UserID},
+		Lines:         order.Lines,
+		Subtotal:      order.Subtotal,
+		DiscountTotal: order.DiscountTotal,
+		TaxTotal:      order.TaxTotal,
+		GrandTotal:    order.GrandTotal,
+		OrderedAt:     order.CreationDate,
+	}
+
+	user, err := Repos.Users.FindByID(ctx, order.UserID)
+	if err != nil && !errors.Is(err, repository.ErrNotFound) {
+		return nil, err
+	}
+	if user != nil {
+		invoice.Customer.Name, invoice.Customer.PhoneNumber = user.Username, user.PhoneNumber
+	}
+	customers, err := Repos.Customers.List(ctx, repository.Query{Limit: 1}.Where("user_id", repository.OpEq, order.UserID))
+	if err != nil {
+		return nil, err
+	}
+	if len(customers.Items) > 0 {
+		c := customers.Items[0]
+		invoice.Customer.CustomerID, invoice.Customer.Name = c.ID, c.FirstName+" "+c.LastName
+	}
+	return invoice, nil
+}
+
+// GetInvoiceHandler returns an invoice as JSON. Customers only see their
+// own.
+func GetInvoiceHandler(w http.ResponseWriter, r *http.Request) {
+	invoice, ok := visibleInvoice(w, r)
+	if !ok {
+		return
+	}
+	response.JSON(w, http.StatusOK, invoice)
+}
+
+// GetInvoicePDFHandler returns an invoice as a PDF download.
+func GetInvoicePDFHandler(w http.ResponseWriter, r *http.Request) {
+	invoice, ok := visibleInvoice(w, r)
+	if !ok {
+		return
+	}
+	w.Header().Set("Content-Type", "application/pdf")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="invoice-%d.pdf"`, invoice.Number))
+	w.WriteHeader(http.StatusOK)
+	invoicePDF(invoice).WriteTo(w)
+}
+
+func GetInvoicesHandler(w http.ResponseWriter, r *http.Request) {
+	q, ok := listQuery(w, r, invoiceList)
+	if !ok {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	page, err := Repos.Invoices.List(ctx, q)
+	if err != nil {
+		listError(w, r, err)
+		return
+	}
+	writePage(w, r, q, page)
+}
+
+// visibleInvoice loads the invoice named by the id parameter if the caller
+// may see it, the way visibleOrder does for orders.
+func visibleInvoice(w http.ResponseWriter, r *http.Request) (*models.Invoice, bool) {
+	claims, callerID, ok := callerFromRequest(w, r)
+	if !ok {
+		return nil, false
+	}
+	id, ok := idParam(w, r, "id")
+	if !ok {
+		return nil, false
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	invoice, err := Repos.Invoices.FindByID(ctx, id)
+	if err != nil {
+		repoError(w, r, err, "Invoice not found")
+		return nil, false
+	}
+	if invoice.Customer.UserID != callerID && !claims.IsStaff() {
+		response.NotFound(w, r, "Invoice not found")
+		return nil, false
+	}
+	return invoice, true
+}
+
+// invoicePDF lays out an invoice on as many A4 pages as its lines need.
+func invoicePDF(invoice *models.Invoice) *pdf.Document {
+	const (
+		left, right = 50.0, pdf.PageWidth - 50
+		rowHeight   = 16.0
+		bottom      = 90.0
+	)
+	date := func(unix int64) string { return time.Unix(unix, 0).UTC().Format("2 January 2006") }
+
+	var doc pdf.Document
+	page := doc.AddPage()
+	page.Text(left, 780, pdf.Bold, 22, "Invoice")
+	page.Text(left, 755, pdf.Bold, 11, invoice.Store.Name)
+	if invoice.Store.PhoneNumber != "" {
+		page.Text(left, 741, pdf.Regular, 10, invoice.Store.PhoneNumber)
+	}
+	page.TextRight(right, 780, 11, fmt.Sprintf("No. %d", invoice.Number))
+	page.TextRight(right, 764, 9, "Issued "+date(invoice.IssuedAt))
+	page.TextRight(right, 750, 9, "Ordered "+date(invoice.OrderedAt))
+	page.TextRight(right, 736, 9, "Order "+invoice.OrderID.Hex())
+
+	page.Text(left, 705, pdf.Regular, 9, "Billed to")
+	page.Text(left, 691, pdf.Bold, 11, invoice.Customer.Name)
+	if invoice.Customer.PhoneNumber != "" {
+		page.Text(left, 677, pdf.Regular, 10, invoice.Customer.PhoneNumber)
+	}
+
+	header := func(page *pdf.Page, y float64) {
+		page.Text(left, y, pdf.Bold, 9, "Item")
+		page.Text(290, y, pdf.Bold, 9, "Qty")
+		page.Text(330, y, pdf.Bold, 9, "Unit")
+		page.Text(420, y, pdf.Bold, 9, "Unit price")
+		page.Text(505, y, pdf.Bold, 9, "Amount")
+		page.Rule(left, right, y-5)
+	}
+	y := 640.0
+	header(page, y)
+	y -= rowHeight + 4
+	for _, line := range invoice.Lines {
+		if y < bottom {
+			page = doc.AddPage()
+			page.Text(left, 790, pdf.Regular, 9, fmt.Sprintf("Invoice %d, continued", invoice.Number))
+			y = 760
+			header(page, y)
+			y -= rowHeight + 4
+		}
+		page.Text(left, y, pdf.Regular, 9, truncate(line.Name, 42))
+		page.TextRight(315, y, 9, fmt.Sprint(line.Quantity))
+		page.Text(330, y, pdf.Regular, 9, truncate(line.Unit, 16))
+		page.TextRight(470, y, 9, line.UnitPrice.String())
+		page.TextRight(right, y, 9, line.Subtotal.String())
+		y -= rowHeight
+	}
+
+	if y < bottom+4*rowHeight {
+		page = doc.AddPage()
+		y = 790
+	}
+	page.Rule(330, right, y+rowHeight-5)
+	for _, total := range []struct {
+		label  string
+		amount models.Money
+		font   pdf.Font
+	}{
+		{"Subtotal", invoice.Subtotal, pdf.Regular},
+		{"Discounts", -invoice.DiscountTotal, pdf.Regular},
+		{"Tax", invoice.TaxTotal, pdf.Regular},
+		{"Total", invoice.GrandTotal, pdf.Bold},
+	} {
+		page.Text(330, y, total.font, 10, total.label)
+		page.TextRight(right, y, 10, total.amount.String())
+		y -= rowHeight
+	}
+	return &doc
+}
+
+func truncate(s string, n int) string {
+	if r := []rune(s); len(r) > n {
+		return string(r[:n-3]) + "..."
+	}
+	return s
+}
@@ -0,0 +1,156 @@
+package handlers
+
+import (
+	"adonai-api/repository"
+	"adonai-api/response"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	defaultPageSize = 50
+	maxPageSize     = 500
+)
+
+// listFilter is a query parameter a list endpoint filters on.
+type listFilter struct {
+	field string // BSON field
+	op    string
+	parse func(string) (interface{}, error)
+}
+
+// listSpec declares what a list endpoint can be sorted and filtered by.
+// Sort names are the JSON field names, which match the BSON ones.
+type listSpec struct {
+	sorts       []string
+	defaultSort string
+	filters     map[string]listFilter
+}
+
+func eqString(field string) listFilter {
+	return listFilter{field: field, op: repository.OpEq, parse: func(s string) (interface{}, error) { return s, nil }}
+}
+
+func eqBool(field string) listFilter {
+	return listFilter{field: field, op: repository.OpEq, parse: func(s string) (interface{}, error) {
+		return strconv.ParseBool(s)
+	}}
+}
+
+func eqObjectID(field string) listFilter {
+	return listFilter{field: field, op: repository.OpEq, parse: func(s string) (interface{}, error) {
+		return primitive.ObjectIDFromHex(s)
+	}}
+}
+
+// unixFrom and unixTo filter a field holding Unix seconds. They accept
+// RFC 3339 timestamps, plain dates or Unix seconds; a plain date as the
+// upper bound covers that whole day.
+func unixFrom(field string) listFilter {
+	return listFilter{field: field, op: repository.OpGte, parse: func(s string) (interface{}, error) {
+		t, _, err := parseInstant(s)
+		return t.Unix(), err
+	}}
+}
+
+func unixTo(field string) listFilter {
+	return listFilter{field: field, op: repository.OpLte, parse: func(s string) (interface{}, error) {
+		t, dateOnly, err := parseInstant(s)
+		if dateOnly {
+			t = t.Add(24*time.Hour - time.Second)
+		}
+		return t.Unix(), err
+	}}
+}
+
+func parseInstant(s string) (t time.Time, dateOnly bool, err error) {
+	if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.Unix(n, 0), false, nil
+	}
+	if t, err := time.Parse(time.DateOnly, s); err == nil {
+		return t, true, nil
+	}
+	t, err = time.Parse(time.RFC3339, s)
+	return t, false, err
+}
+
+// listQuery builds a repository.Query from limit, cursor, sort,
+// include_total and the filters spec allows, writing a 400 for any value
+// it can't use.
+func listQuery(w http.ResponseWriter, r *http.Request, spec listSpec) (repository.Query, bool) {
+	params := r.URL.Query()
+	q := repository.Query{
+		Limit:      defaultPageSize,
+		Cursor:     params.Get("cursor"),
+		Sort:       spec.defaultSort,
+		CountTotal: params.Get("include_total") == "true",
+	}
+
+	if s := params.Get("limit"); s != "" {
+		limit, err := strconv.Atoi(s)
+		if err != nil || limit < 1 || limit > maxPageSize {
+			response.BadRequest(w, r, "limit must be between 1 and "+strconv.Itoa(maxPageSize))
+			return q, false
+		}
+		q.Limit = limit
+	}
+
+	if s := params.Get("sort"); s != "" {
+		allowed := false
+		for _, name := range spec.sorts {
+			allowed = allowed || strings.TrimPrefix(s, "-") == name
+		}
+		if !allowed {
+			response.BadRequest(w, r, "sort must be one of: "+strings.Join(spec.sorts, ", ")+" (prefix - for descending)")
+			return q, false
+		}
+		q.Sort = s
+	}
+
+	for name, filter := range spec.filters {
+		s := params.Get(name)
+		if s == "" {
+			continue
+		}
+		value, err := filter.parse(s)
+		if err != nil {
+			response.BadRequest(w, r, "Invalid "+name)
+			return q, false
+		}
+		q = q.Where(filter.field, filter.op, value)
+	}
+	return q, true
+}
+
+// writePage writes the page's items as a JSON array, as list endpoints have
+// always returned, with paging details in headers: X-Next-Cursor and a Link
+// rel="next" when there is another page, and X-Total-Count when requested.
+func writePage[T any](w http.ResponseWriter, r *http.Request, q repository.Query, page *repository.Page[T]) {
+	if page.NextCursor != "" {
+		next := *r.URL
+		params := next.Query()
+		params.Set("cursor", page.NextCursor)
+		next.RawQuery = params.Encode()
+		w.Header().Set("X-Next-Cursor", page.NextCursor)
+		w.Header().Set("Link", "<"+(&url.URL{Path: next.Path, RawQuery: next.RawQuery}).String()+`>; rel="next"`)
+	}
+	if q.CountTotal {
+		w.Header().Set("X-Total-Count", strconv.FormatInt(page.Total, 10))
+	}
+	response.JSON(w, http.StatusOK, page.Items)
+}
+
+// listError maps a List error onto the response.
+func listError(w http.ResponseWriter, r *http.Request, err error) {
+	if errors.Is(err, repository.ErrInvalidCursor) {
+		response.BadRequest(w, r, "Invalid cursor")
+		return
+	}
+	response.Internal(w, r, err)
+}
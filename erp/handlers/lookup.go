@@ -0,0 +1,77 @@
+package handlers
+
+import (
+	"adonai-api/models"
+	"adonai-api/repository"
+	"adonai-api/response"
+	"adonai-api/validate"
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// LookupResult is what a scan finds: the product and, when the scan names
+// a store, the product's stock there.
+type LookupResult struct {
+	Product   *models.Product    `json:"product"`
+	MatchedBy string             `json:"matched_by"` // "barcode" or "sku"
+	Stock     *models.StockLevel `json:"stock,omitempty"`
+}
+
+// LookupProductHandler finds the product a scanned code belongs to, by
+// barcode first and then by SKU. GET /product/lookup?code=&store_id=
+func LookupProductHandler(w http.ResponseWriter, r *http.Request) {
+	code := strings.TrimSpace(r.URL.Query().Get("code"))
+	if code == "" {
+		response.Invalid(w, r, validate.Errors{{Field: "code", Message: "is required"}})
+		return
+	}
+	var storeID primitive.ObjectID
+	if s := r.URL.Query().Get("store_id"); s != "" {
+		var err error
+		if storeID, err = primitive.ObjectIDFromHex(s); err != nil {
+			response.BadRequest(w, r, "Invalid store_id")
+			return
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	result, err := lookupProduct(ctx, code)
+	if err != nil {
+		repoError(w, r, err, "No product has this code")
+		return
+	}
+	if !storeID.IsZero() {
+		if _, err := Repos.Stores.FindByID(ctx, storeID); err != nil {
+			repoError(w, r, err, "Store not found")
+			return
+		}
+		if result.Stock, err = Repos.Stock.Level(ctx, storeID, result.Product.ID); err != nil {
+			response.Internal(w, r, err)
+			return
+		}
+	}
+	response.JSON(w, http.StatusOK, result)
+}
+
+func lookupProduct(ctx context.Context, code string) (*LookupResult, error) {
+	for _, variant := range models.BarcodeVariants(code) {
+		product, err := Repos.Products.FindByBarcode(ctx, variant)
+		if err == nil {
+			return &LookupResult{Product: product, MatchedBy: "barcode"}, nil
+		}
+		if !errors.Is(err, repository.ErrNotFound) {
+			return nil, err
+		}
+	}
+	product, err := Repos.Products.FindBySKU(ctx, code)
+	if err != nil {
+		return nil, err
+	}
+	return &LookupResult{Product: product, MatchedBy: "sku"}, nil
+}
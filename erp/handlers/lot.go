@@ -0,0 +1,167 @@
+package handlers
+
+import (
+	"adonai-api/models"
+	"adonai-api/repository"
+	"adonai-api/response"
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var lotList = listSpec{
+	sorts:       []string{"expires_at", "received_at", "remaining"},
+	defaultSort: "expires_at",
+	filters: map[string]listFilter{
+		"store_id":   eqObjectID("store_id"),
+		"product_id": eqObjectID("product_id"),
+		"lot":        eqString("lot"),
+		"expires_to": unixTo("expires_at"),
+		// expires_within=30 keeps lots expiring in the next 30 days, and
+		// those already expired.
+		"expires_within": {field: "expires_at", op: repository.OpLte, parse: func(s string) (interface{}, error) {
+			days, err := strconv.Atoi(s)
+			if err != nil || days < 0 {
+				return nil, errors.New("must be a number of days")
+			}
+			return time.Now().AddDate(0, 0, days).Unix(), nil
+		}},
+		// in_stock=true hides lots that have all been sold or written off.
+		"in_stock": {field: "remaining", op: repository.OpGte, parse: func(s string) (interface{}, error) {
+			if b, err := strconv.ParseBool(s); err != nil || !b {
+				return nil, errors.New("only in_stock=true is supported")
+			}
+			return 1, nil
+		}},
+	},
+}
+
+// LotTrace is where some of an order's stock came from: the lot it was
+// taken from, the lot it was first received into if it reached the store
+// by transfer, and, for a lot received against a purchase order, the
+// supplier.
+type LotTrace struct {
+	ProductID       primitive.ObjectID `json:"product_id"`
+	Quantity        int                `json:"quantity"`
+	Lot             *models.StockLot   `json:"lot,omitempty"`    // absent for stock that wasn't in a lot
+	Origin          *models.StockLot   `json:"origin,omitempty"` // absent unless the lot came by transfer
+	PurchaseOrderID primitive.ObjectID `json:"purchase_order_id,omitempty"`
+	SupplierID      primitive.ObjectID `json:"supplier_id,omitempty"`
+	SupplierName    string             `json:"supplier_name,omitempty"`
+}
+
+// GetLotsHandler lists lots, soonest expiry first. Sorted by expiry, as
+// by default, it lists only lots that expire; sort=received_at lists every
+// lot. With store_id, expires_within and in_stock=true it is the list of
+// what is about to go off at a store.
+func GetLotsHandler(w http.ResponseWriter, r *http.Request) {
+	q, ok := listQuery(w, r, lotList)
+	if !ok {
+		return
+	}
+	if strings.TrimPrefix(q.Sort, "-") == "expires_at" {
+		// Lots that never expire have no expires_at, which would sort
+		// ahead of every dated lot.
+		q = q.Where("expires_at", repository.OpGte, int64(1))
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	page, err := Repos.Stock.Lots(ctx, q)
+	if err != nil {
+		listError(w, r, err)
+		return
+	}
+	writePage(w, r, q, page)
+}
+
+// GetLotHandler returns one lot. Its movements, and so the orders its
+// stock went to, are listed by GET /stock/movements?lot_id=.
+func GetLotHandler(w http.ResponseWriter, r *http.Request) {
+	id, ok := idParam(w, r, "id")
+	if !ok {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	lot, err := Repos.Stock.Lot(ctx, id)
+	if err != nil {
+		repoError(w, r, err, "Lot not found")
+		return
+	}
+	response.JSON(w, http.StatusOK, lot)
+}
+
+// TraceOrderHandler traces a shipped order's stock back through the lots
+// it was taken from to the supplier receipts that brought them in.
+func TraceOrderHandler(w http.ResponseWriter, r *http.Request) {
+	id, ok := idParam(w, r, "id")
+	if !ok {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	if _, err := Repos.Orders.FindByID(ctx, id); err != nil {
+		repoError(w, r, err, "Order not found")
+		return
+	}
+	sales, err := Repos.Stock.Movements(ctx, repository.Query{}.
+		Where("source_id", repository.OpEq, id).
+		Where("kind", repository.OpEq, models.MoveSale))
+	if err != nil {
+		response.Internal(w, r, err)
+		return
+	}
+
+	traces := make([]LotTrace, 0, len(sales.Items))
+	for _, sale := range sales.Items {
+		trace := LotTrace{ProductID: sale.ProductID, Quantity: -sale.Quantity}
+		if !sale.LotID.IsZero() {
+			if err := traceLot(ctx, &trace, sale.LotID); err != nil {
+				response.Internal(w, r, err)
+				return
+			}
+		}
+		traces = append(traces, trace)
+	}
+	response.JSON(w, http.StatusOK, traces)
+}
+
+// traceLot fills in the lot, follows transfers back to the lot the stock
+// was first received into and, if that came from one, fills in the
+// purchase order and supplier.
+func traceLot(ctx context.Context, trace *LotTrace, lotID primitive.ObjectID) error {
+	lot, err := Repos.Stock.Lot(ctx, lotID)
+	if err != nil {
+		return err
+	}
+	trace.Lot = lot
+	for !lot.OriginLotID.IsZero() {
+		if lot, err = Repos.Stock.Lot(ctx, lot.OriginLotID); err != nil {
+			return err
+		}
+		trace.Origin = lot
+	}
+	if lot.Source != "purchase_order" {
+		return nil
+	}
+	po, err := Repos.Purchases.FindByID(ctx, lot.SourceID)
+	if err != nil {
+		return err
+	}
+	trace.PurchaseOrderID, trace.SupplierID = po.ID, po.SupplierID
+	supplier, err := Repos.Suppliers.FindByID(ctx, po.SupplierID)
+	if errors.Is(err, repository.ErrNotFound) {
+		return nil // deleted since; the ID is still worth having
+	}
+	if err != nil {
+		return err
+	}
+	trace.SupplierName = supplier.Name
+	return nil
+}
@@ -3,84 +3,402 @@ package handlers
 import (
 	"adonai-api/config"
 	"adonai-api/models"
+	"adonai-api/repository"
+	"adonai-api/response"
+	"adonai-api/validate"
 	"context"
-	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
+	"strings"
 	"time"
 
-	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
+// OrderRequest is the body of POST /order. Clients from before orders had
+// lines send a single product_id and quantity instead of lines.
+type OrderRequest struct {
+	StoreID   primitive.ObjectID `json:"store_id"`
+	Draft     bool               `json:"draft"` // save without placing; place later with a transition
+	Lines     []LineRequest      `json:"lines"`
+	ProductID primitive.ObjectID `json:"product_id"`
+	Quantity  int                `json:"quantity"`
+}
+
+type LineRequest struct {
+	ProductID primitive.ObjectID `json:"product_id" validate:"required"`
+	Quantity  int                `json:"quantity" validate:"min=1"`
+	Unit      string             `json:"unit" validate:"max=32"`    // the product's base unit if empty
+	Discount  models.Money       `json:"discount" validate:"min=0"` // vendors and admins only
+}
+
+// CreateOrderHandler places an order. Prices and taxes come from the
+// catalog and configured tax rates, never from the client.
 func CreateOrderHandler(w http.ResponseWriter, r *http.Request) {
-	var order models.Order
-	_ = json.NewDecoder(r.Body).Decode(&order)
-	order.CreationDate = time.Now().Unix()
-	order.OrderStatus = "Pending"
+	claims, callerID, ok := callerFromRequest(w, r)
+	if !ok {
+		return
+	}
 
-	collection := config.Client.Database("customer_vendor_api").Collection("orders")
-	ctx, _ := context.WithTimeout(context.Background(), 5*time.Second)
+	var req OrderRequest
+	if !decode(w, r, &req) {
+		return
+	}
+	if len(req.Lines) == 0 && !req.ProductID.IsZero() {
+		req.Lines = []LineRequest{{ProductID: req.ProductID, Quantity: req.Quantity}}
+		var invalid validate.Errors
+		if err := validate.Struct(&req); errors.As(err, &invalid) {
+			response.Invalid(w, r, invalid)
+			return
+		}
+	}
+	if len(req.Lines) == 0 {
+		response.Invalid(w, r, validate.Errors{{Field: "lines", Message: "must have at least 1 elements"}})
+		return
+	}
 
-	result, err := collection.InsertOne(ctx, order)
+	now := time.Now().Unix()
+	order := models.Order{
+		UserID:       callerID,
+		StoreID:      req.StoreID,
+		CreationDate: now,
+		OrderStatus:  models.StatusPlaced,
+	}
+	if req.Draft {
+		order.OrderStatus = models.StatusDraft
+	}
+	order.StatusHistory = []models.StatusChange{{To: order.OrderStatus, Actor: callerID, Role: strings.ToLower(claims.Role), At: now}}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	invalid, err := priceLines(ctx, &order, req.Lines, claims.IsStaff())
 	if err != nil {
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		response.Internal(w, r, err)
+		return
+	}
+	if len(invalid) > 0 {
+		response.Invalid(w, r, invalid)
+		return
+	}
+
+	err = Repos.Orders.Create(ctx, &order)
+	if err != nil {
+		response.Internal(w, r, err)
+		return
+	}
+	writeCreated(w, "/order?id="+order.ID.Hex(), order.ID)
+}
+
+// priceLines fills order.Lines from the catalog and computes the totals.
+// For an order at a store it also checks that the store has the quantities
+// in stock, though nothing is held for the order until it is confirmed.
+// Problems with the request come back as field errors; err is only set when
+// storage fails.
+func priceLines(ctx context.Context, order *models.Order, lines []LineRequest, mayDiscount bool) (validate.Errors, error) {
+	var invalid validate.Errors
+	fail := func(i int, field, message string) {
+		invalid = append(invalid, validate.FieldError{Field: fmt.Sprintf("lines[%d].%s", i, field), Message: message})
+	}
+
+	order.Lines = make([]models.OrderLine, 0, len(lines))
+	wanted := map[primitive.ObjectID]int{}
+	for i, req := range lines {
+		product, err := Repos.Products.FindByID(ctx, req.ProductID)
+		if err != nil && !errors.Is(err, repository.ErrNotFound) {
+			return nil, err
+		}
+		if err != nil || !product.AvailableAt(order.StoreID) {
+			fail(i, "product_id", "is not a product available from this store")
+			continue
+		}
+		rate, ok := config.Current.Tax.Rate(product.TaxCategory)
+		if !ok {
+			fail(i, "product_id", "has tax category "+product.TaxCategory+", which has no configured rate")
+			continue
+		}
+		unit := req.Unit
+		if unit == "" {
+			unit = product.Unit
+		}
+		factor, price, ok := product.InUnit(unit)
+		if !ok {
+			fail(i, "unit", unitMessage(product))
+			continue
+		}
+
+		line := models.OrderLine{
+			ProductID:   product.ID,
+			SKU:         product.SKU,
+			Name:        product.Name,
+			Unit:        unit,
+			Factor:      factor,
+			Quantity:    req.Quantity,
+			UnitPrice:   price,
+			Discount:    req.Discount,
+			TaxCategory: product.TaxCategory,
+			TaxRate:     rate,
+		}
+		line.Price()
+		switch {
+		case line.Discount > 0 && !mayDiscount:
+			fail(i, "discount", "can only be given by a vendor")
+		case line.Discount > line.Subtotal:
+			fail(i, "discount", "is more than the line subtotal of "+line.Subtotal.String())
+		}
+		order.Lines = append(order.Lines, line)
+
+		if order.StoreID.IsZero() {
+			continue
+		}
+		level, err := Repos.Stock.Level(ctx, order.StoreID, product.ID)
+		if err != nil {
+			return nil, err
+		}
+		wanted[product.ID] += line.BaseQuantity()
+		if wanted[product.ID] > level.Available {
+			fail(i, "quantity", fmt.Sprintf("is more than the %d %s available at this store", level.Available, product.Unit))
+		}
+	}
+
+	order.Recalculate()
+	return invalid, nil
+}
+
+// GetOrderHandler returns one order. Customers only see their own.
+func GetOrderHandler(w http.ResponseWriter, r *http.Request) {
+	claims, callerID, ok := callerFromRequest(w, r)
+	if !ok {
+		return
+	}
+	orderID, ok := idParam(w, r, "id")
+	if !ok {
 		return
 	}
-	json.NewEncoder(w).Encode(result)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	order, ok := visibleOrder(ctx, w, r, claims, callerID, orderID)
+	if !ok {
+		return
+	}
+	response.JSON(w, http.StatusOK, order)
+}
+
+var orderList = listSpec{
+	sorts:       []string{"creation_date", "grand_total", "order_status"},
+	defaultSort: "-creation_date",
+	filters: map[string]listFilter{
+		"order_status": eqString("order_status"),
+		"store_id":     eqObjectID("store_id"),
+		"created_from": unixFrom("creation_date"),
+		"created_to":   unixTo("creation_date"),
+	},
 }
 
 func GetUserOrdersHandler(w http.ResponseWriter, r *http.Request) {
-	userID, _ := primitive.ObjectIDFromHex(r.URL.Query().Get("user_id"))
+	_, userID, ok := actingUserID(w, r)
+	if !ok {
+		return
+	}
+	q, ok := listQuery(w, r, orderList)
+	if !ok {
+		return
+	}
 
-	collection := config.Client.Database("customer_vendor_api").Collection("orders")
-	ctx, _ := context.WithTimeout(context.Background(), 30*time.Second)
-	cursor, err := collection.Find(ctx, bson.M{"user_id": userID})
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	page, err := Repos.Orders.List(ctx, q.Where("user_id", repository.OpEq, userID))
 	if err != nil {
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		listError(w, r, err)
+		return
+	}
+	writePage(w, r, q, page)
+}
+
+// TransitionRequest is the body of POST /order/transition.
+type TransitionRequest struct {
+	To   string `json:"to" validate:"required,oneof=Draft Placed Confirmed Picking Shipped Delivered Cancelled Returned"`
+	Note string `json:"note" validate:"max=500"`
+}
+
+// TransitionOrderHandler moves an order one step through its lifecycle and
+// records the step in its status history.
+func TransitionOrderHandler(w http.ResponseWriter, r *http.Request) {
+	claims, callerID, ok := callerFromRequest(w, r)
+	if !ok {
+		return
+	}
+	orderID, ok := idParam(w, r, "id")
+	if !ok {
+		return
+	}
+	var req TransitionRequest
+	if !decode(w, r, &req) {
 		return
 	}
-	defer cursor.Close(ctx)
 
-	var orders []models.Order
-	for cursor.Next(ctx) {
-		var order models.Order
-		cursor.Decode(&order)
-		orders = append(orders, order)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	order, ok := visibleOrder(ctx, w, r, claims, callerID, orderID)
+	if !ok {
+		return
 	}
-	json.NewEncoder(w).Encode(orders)
+	updated, ok := transitionOrder(ctx, w, r, claims, callerID, order, req.To, req.Note)
+	if !ok {
+		return
+	}
+	response.JSON(w, http.StatusOK, updated)
 }
 
+// CancelOrderHandler is the transition to Cancelled, kept for older clients.
 func CancelOrderHandler(w http.ResponseWriter, r *http.Request) {
-	orderID, _ := primitive.ObjectIDFromHex(r.URL.Query().Get("order_id"))
+	claims, callerID, ok := callerFromRequest(w, r)
+	if !ok {
+		return
+	}
+	orderID, ok := idParam(w, r, "order_id")
+	if !ok {
+		return
+	}
 
-	collection := config.Client.Database("adonai-api").Collection("orders")
-	ctx, _ := context.WithTimeout(context.Background(), 30*time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
 
-	_, err := collection.UpdateOne(ctx, bson.M{"_id": orderID}, bson.M{
-		"$set": bson.M{"order_status": "Cancelled"},
-	})
-	if err != nil {
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	order, ok := visibleOrder(ctx, w, r, claims, callerID, orderID)
+	if !ok {
 		return
 	}
-	json.NewEncoder(w).Encode("Order cancelled")
+	updated, ok := transitionOrder(ctx, w, r, claims, callerID, order, models.StatusCancelled, "")
+	if !ok {
+		return
+	}
+	response.JSON(w, http.StatusOK, updated)
 }
 
-func GetAllOrdersHandler(w http.ResponseWriter, r *http.Request) {
-	collection := config.Client.Database("adonai-api").Collection("orders")
-	ctx, _ := context.WithTimeout(context.Background(), 30*time.Second)
-	cursor, err := collection.Find(ctx, bson.M{})
+// transitionOrder checks that the caller's role may move order to status
+// to and applies the move, writing the error response itself on failure.
+func transitionOrder(ctx context.Context, w http.ResponseWriter, r *http.Request, claims *Claims, callerID primitive.ObjectID, order *models.Order, to, note string) (*models.Order, bool) {
+	from := models.NormalizeStatus(order.OrderStatus)
+	allowed, permitted := models.CanTransition(from, to, claims.Role)
+	if !allowed {
+		response.Error(w, r, http.StatusConflict, "invalid_transition", fmt.Sprintf("An order can't go from %s to %s", from, to))
+		return nil, false
+	}
+	if !permitted {
+		response.Forbidden(w, r)
+		return nil, false
+	}
+
+	change := models.StatusChange{
+		From:  from,
+		To:    to,
+		Actor: callerID,
+		Role:  strings.ToLower(claims.Role),
+		At:    time.Now().Unix(),
+		Note:  note,
+	}
+	updated, err := Repos.Orders.Transition(ctx, order.ID, order.OrderStatus, change, stockMovements(order, from, change))
+	if errors.Is(err, repository.ErrConflict) {
+		response.Conflict(w, r, "The order's status changed meanwhile; reload it and try again")
+		return nil, false
+	}
+	if errors.Is(err, repository.ErrInsufficientStock) {
+		insufficientStock(w, r)
+		return nil, false
+	}
 	if err != nil {
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		repoError(w, r, err, "Order not found")
+		return nil, false
+	}
+	return updated, true
+}
+
+// stockMovements returns what an order's move from one status to another
+// posts to its store's stock ledger: confirming reserves the lines,
+// shipping turns the reservation into a sale, cancelling after
+// confirmation releases it and a return puts the goods back on hand.
+// Orders without a store don't track stock.
+func stockMovements(order *models.Order, from string, change models.StatusChange) []models.StockMovement {
+	if order.StoreID.IsZero() {
+		return nil
+	}
+	type post struct {
+		kind string
+		sign int
+	}
+	var posts []post
+	switch change.To {
+	case models.StatusConfirmed:
+		posts = []post{{models.MoveReserve, 1}}
+	case models.StatusShipped:
+		posts = []post{{models.MoveRelease, -1}, {models.MoveSale, -1}}
+	case models.StatusCancelled:
+		if from == models.StatusConfirmed || from == models.StatusPicking {
+			posts = []post{{models.MoveRelease, -1}}
+		}
+	case models.StatusReturned:
+		posts = []post{{models.MoveReturn, 1}}
+	}
+
+	var movements []models.StockMovement
+	for _, line := range order.Lines {
+		if line.ProductID.IsZero() {
+			continue
+		}
+		for _, p := range posts {
+			mv := models.StockMovement{
+				StoreID:   order.StoreID,
+				ProductID: line.ProductID,
+				Kind:      p.kind,
+				Source:    "order",
+				SourceID:  order.ID,
+				Actor:     change.Actor,
+				CreatedAt: change.At,
+			}
+			mv.SetQuantity(p.sign*line.Quantity, line.Unit, max(line.Factor, 1))
+			movements = append(movements, mv)
+		}
+	}
+	return movements
+}
+
+// visibleOrder loads an order the caller may see: customers only their own,
+// vendors and admins any. Other people's orders look missing rather than
+// forbidden so IDs can't be probed.
+func visibleOrder(ctx context.Context, w http.ResponseWriter, r *http.Request, claims *Claims, callerID, orderID primitive.ObjectID) (*models.Order, bool) {
+	order, err := Repos.Orders.FindByID(ctx, orderID)
+	if err != nil {
+		repoError(w, r, err, "Order not found")
+		return nil, false
+	}
+	if order.UserID != callerID && !claims.IsStaff() {
+		response.NotFound(w, r, "Order not found")
+		return nil, false
+	}
+	return order, true
+}
+
+func GetAllOrdersHandler(w http.ResponseWriter, r *http.Request) {
+	spec := orderList
+	spec.filters = map[string]listFilter{"user_id": eqObjectID("user_id")}
+	for name, filter := range orderList.filters {
+		spec.filters[name] = filter
+	}
+	q, ok := listQuery(w, r, spec)
+	if !ok {
 		return
 	}
-	defer cursor.Close(ctx)
 
-	var orders []models.Order
-	for cursor.Next(ctx) {
-		var order models.Order
-		cursor.Decode(&order)
-		orders = append(orders, order)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	page, err := Repos.Orders.List(ctx, q)
+	if err != nil {
+		listError(w, r, err)
+		return
 	}
-	json.NewEncoder(w).Encode(orders)
+	writePage(w, r, q, page)
 }
@@ -0,0 +1,197 @@
+package handlers
+
+import (
+	"adonai-api/config"
+	"adonai-api/models"
+	"adonai-api/response"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+var (
+	errOTPCooldown = errors.New("otp requested too recently")
+	errOTPInvalid  = errors.New("invalid or expired otp")
+)
+
+func generateOTP() (string, error) {
+	n, err := rand.Int(rand.Reader, big.NewInt(1000000))
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%06d", n.Int64()), nil
+}
+
+// hashOTP keys the hash with the JWT secret and user ID so a leaked users
+// collection can't be brute-forced offline in a million guesses.
+func hashOTP(user *models.User, otp string) string {
+	mac := hmac.New(sha256.New, JwtKey())
+	mac.Write([]byte(user.ID.Hex() + ":" + otp))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// issueOTP stores the hash of a fresh OTP on the user and sends the code by
+// SMS. It refuses with errOTPCooldown if the previous code is too recent.
+// The cooldown is checked by the store itself, so of concurrent requests
+// only one sends a code.
+func issueOTP(ctx context.Context, user *models.User) error {
+	now := time.Now()
+	otp, err := generateOTP()
+	if err != nil {
+		return err
+	}
+	hash := hashOTP(user, otp)
+	expiresAt := now.Add(config.Current.Auth.OTPTTL).Unix()
+	sentBefore := now.Add(-config.Current.Auth.OTPResendCooldown).Unix()
+
+	stored, err := Repos.Users.SetOTP(ctx, user.ID, hash, expiresAt, now.Unix(), sentBefore)
+	if err != nil {
+		return err
+	}
+	if !stored {
+		return errOTPCooldown
+	}
+	user.OTPHash, user.OTPExpiresAt, user.OTPSentAt = hash, expiresAt, now.Unix()
+
+	return SMS.Send(ctx, user.PhoneNumber, "Your OTP is: "+otp)
+}
+
+// consumeOTP checks the code and, if it matches, clears it so it cannot be
+// used twice. Two concurrent requests with the same code can't both win
+// because the clear is conditional on the stored hash.
+func consumeOTP(ctx context.Context, user *models.User, otp string) error {
+	if user.OTPHash == "" || time.Now().Unix() > user.OTPExpiresAt {
+		return errOTPInvalid
+	}
+	if !hmac.Equal([]byte(user.OTPHash), []byte(hashOTP(user, otp))) {
+		return errOTPInvalid
+	}
+
+	consumed, err := Repos.Users.ConsumeOTP(ctx, user.ID, user.OTPHash)
+	if err != nil {
+		return err
+	}
+	if !consumed {
+		return errOTPInvalid
+	}
+	return nil
+}
+
+func otpPhoneKey(phone string) string { return "otp:phone:" + phone }
+func otpIPKey(ip string) string       { return "otp:ip:" + ip }
+func loginUserKey(name string) string { return "login:user:" + name }
+func loginIPKey(ip string) string     { return "login:ip:" + ip }
+
+// lockedOut returns how long the caller must wait if any of the keys is
+// currently locked, or zero. An error means the lockout can't be checked,
+// and the attempt must not go ahead.
+func lockedOut(ctx context.Context, keys ...string) (time.Duration, error) {
+	counters, err := Repos.Attempts.FindMany(ctx, keys...)
+	if err != nil {
+		return 0, err
+	}
+
+	var wait time.Duration
+	now := time.Now().Unix()
+	for _, counter := range counters {
+		if counter.LockedUntil > now {
+			if d := time.Duration(counter.LockedUntil-now) * time.Second; d > wait {
+				wait = d
+			}
+		}
+	}
+	return wait, nil
+}
+
+// allowAttempt reports whether none of the keys is locked out, writing a
+// 429 if one is and a 500 if the lockout can't be checked.
+func allowAttempt(ctx context.Context, w http.ResponseWriter, r *http.Request, keys ...string) bool {
+	wait, err := lockedOut(ctx, keys...)
+	if err != nil {
+		response.Internal(w, r, err)
+		return false
+	}
+	if wait > 0 {
+		tooManyAttempts(w, r, wait)
+		return false
+	}
+	return true
+}
+
+// recordFailure counts a failed attempt against key and locks it once max
+// failures land inside the attempt window. The count is incremented
+// atomically, so concurrent wrong guesses can't slip past max.
+func recordFailure(ctx context.Context, key string, max int) {
+	now := time.Now()
+	windowStart := now.Add(-config.Current.Auth.OTPAttemptWindow).Unix()
+
+	counter, err := Repos.Attempts.Increment(ctx, key, now.Unix(), windowStart)
+	if err != nil {
+		log.Printf("counting failed attempt for %s: %v", key, err)
+		return
+	}
+	if counter.Count >= max {
+		if err := Repos.Attempts.Lock(ctx, key, now.Add(config.Current.Auth.OTPLockout).Unix()); err != nil {
+			log.Printf("locking %s: %v", key, err)
+		}
+	}
+}
+
+func resetAttempts(ctx context.Context, key string) {
+	Repos.Attempts.Delete(ctx, key)
+}
+
+// verifyOTPAttempt applies lockouts and attempt counting around consumeOTP.
+// It writes the error response itself and reports whether the code was good.
+func verifyOTPAttempt(ctx context.Context, w http.ResponseWriter, r *http.Request, user *models.User, otp string) bool {
+	phoneKey, ipKey := otpPhoneKey(user.PhoneNumber), otpIPKey(clientIP(r))
+	if !allowAttempt(ctx, w, r, phoneKey, ipKey) {
+		return false
+	}
+
+	err := consumeOTP(ctx, user, otp)
+	if err == errOTPInvalid {
+		recordFailure(ctx, phoneKey, config.Current.Auth.OTPMaxPerPhone)
+		recordFailure(ctx, ipKey, config.Current.Auth.OTPMaxPerIP)
+		response.Unauthorized(w, r, "Invalid or expired OTP")
+		return false
+	}
+	if err != nil {
+		response.Internal(w, r, err)
+		return false
+	}
+
+	resetAttempts(ctx, phoneKey)
+	return true
+}
+
+// sendOTPResponse maps issueOTP errors onto the response.
+func sendOTPResponse(w http.ResponseWriter, r *http.Request, user *models.User, err error) bool {
+	if err == errOTPCooldown {
+		tooManyAttempts(w, r, time.Until(time.Unix(user.OTPSentAt, 0).Add(config.Current.Auth.OTPResendCooldown)))
+		return false
+	}
+	if err != nil {
+		response.Internal(w, r, err)
+		return false
+	}
+	return true
+}
+
+func tooManyAttempts(w http.ResponseWriter, r *http.Request, wait time.Duration) {
+	seconds := int(wait.Seconds())
+	if seconds < 1 {
+		seconds = 1
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	response.Error(w, r, http.StatusTooManyRequests, "too_many_attempts", "Too many attempts, try again later")
+}
@@ -0,0 +1,253 @@
+package handlers
+
+import (
+	"adonai-api/config"
+	"adonai-api/models"
+	"adonai-api/repository"
+	"adonai-api/response"
+	"adonai-api/validate"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var productList = listSpec{
+	sorts:       []string{"name", "sku", "price"},
+	defaultSort: "name",
+	filters: map[string]listFilter{
+		"sku":          eqString("sku"),
+		"tax_category": eqString("tax_category"),
+		"active":       eqBool("active"),
+	},
+}
+
+func CreateProductHandler(w http.ResponseWriter, r *http.Request) {
+	var product models.Product
+	if !decode(w, r, &product) {
+		return
+	}
+	if !knownTaxCategory(w, r, product.TaxCategory) || !checkUnits(w, r, &product) {
+		return
+	}
+	product.CreatedAt = time.Now().Unix()
+	product.UpdatedAt = product.CreatedAt
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if !checkBarcodes(ctx, w, r, primitive.NilObjectID, &product) {
+		return
+	}
+	err := Repos.Products.Create(ctx, &product)
+	if errors.Is(err, repository.ErrDuplicate) {
+		response.Conflict(w, r, "A product with this SKU or barcode already exists")
+		return
+	}
+	if err != nil {
+		response.Internal(w, r, err)
+		return
+	}
+	writeCreated(w, "/product?id="+product.ID.Hex(), product.ID)
+}
+
+func GetProductHandler(w http.ResponseWriter, r *http.Request) {
+	id, ok := idParam(w, r, "id")
+	if !ok {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	product, err := Repos.Products.FindByID(ctx, id)
+	if err != nil {
+		repoError(w, r, err, "Product not found")
+		return
+	}
+	response.JSON(w, http.StatusOK, product)
+}
+
+func GetProductsHandler(w http.ResponseWriter, r *http.Request) {
+	q, ok := listQuery(w, r, productList)
+	if !ok {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	page, err := Repos.Products.List(ctx, q)
+	if err != nil {
+		listError(w, r, err)
+		return
+	}
+	writePage(w, r, q, page)
+}
+
+func UpdateProductHandler(w http.ResponseWriter, r *http.Request) {
+	id, ok := idParam(w, r, "id")
+	if !ok {
+		return
+	}
+	var product models.Product
+	if !decode(w, r, &product) {
+		return
+	}
+	if !knownTaxCategory(w, r, product.TaxCategory) || !checkUnits(w, r, &product) {
+		return
+	}
+	product.ID = primitive.NilObjectID // the id comes from the query, never the body
+	product.CreatedAt = 0
+	product.UpdatedAt = time.Now().Unix()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if !checkBarcodes(ctx, w, r, id, &product) || !checkUnitChange(ctx, w, r, id, &product) {
+		return
+	}
+	updated, err := Repos.Products.Update(ctx, id, &product)
+	if errors.Is(err, repository.ErrDuplicate) {
+		response.Conflict(w, r, "A product with this SKU or barcode already exists")
+		return
+	}
+	if err != nil {
+		repoError(w, r, err, "Product not found")
+		return
+	}
+	response.JSON(w, http.StatusOK, updated)
+}
+
+// DeleteProductHandler removes a product from the catalog. Orders keep the
+// product's name, so history stays readable; to stop selling something
+// without losing it, set active to false instead. A product with stock on
+// hand or on open purchase orders can't be deleted, since its stock levels,
+// lots and order lines would point at nothing.
+func DeleteProductHandler(w http.ResponseWriter, r *http.Request) {
+	id, ok := idParam(w, r, "id")
+	if !ok {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	inUse, err := productInUse(ctx, id)
+	if err != nil {
+		response.Internal(w, r, err)
+		return
+	}
+	if inUse {
+		response.Conflict(w, r, "A product with stock on hand or on open purchase orders can't be deleted; set active to false instead")
+		return
+	}
+	err = Repos.Products.Delete(ctx, id)
+	if err != nil {
+		repoError(w, r, err, "Product not found")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// knownTaxCategory writes a 422 unless the category has a configured rate,
+// so every product can be priced on an order.
+func knownTaxCategory(w http.ResponseWriter, r *http.Request, category string) bool {
+	if _, ok := config.Current.Tax.Rate(category); ok {
+		return true
+	}
+	response.Invalid(w, r, validate.Errors{{Field: "tax_category", Message: "is not a configured tax category"}})
+	return false
+}
+
+// checkUnits writes a 422 unless the product's pack units are named apart
+// from each other and from its base unit.
+func checkUnits(w http.ResponseWriter, r *http.Request, product *models.Product) bool {
+	if product.Units == nil {
+		product.Units = []models.UnitOfMeasure{}
+	}
+	var invalid validate.Errors
+	seen := map[string]bool{product.Unit: true}
+	for i, u := range product.Units {
+		if seen[u.Name] {
+			invalid = append(invalid, validate.FieldError{Field: fmt.Sprintf("units[%d].name", i), Message: "is already a unit of this product"})
+		}
+		seen[u.Name] = true
+	}
+	if len(invalid) > 0 {
+		response.Invalid(w, r, invalid)
+		return false
+	}
+	return true
+}
+
+// checkUnitChange writes a 409 if the update changes the product's base
+// unit or a pack's factor while stock is on hand or on its way from a
+// supplier, since those quantities were counted in the old units.
+func checkUnitChange(ctx context.Context, w http.ResponseWriter, r *http.Request, id primitive.ObjectID, product *models.Product) bool {
+	existing, err := Repos.Products.FindByID(ctx, id)
+	if err != nil {
+		repoError(w, r, err, "Product not found")
+		return false
+	}
+	if !product.UnitsChanged(existing) {
+		return true
+	}
+	inUse, err := productInUse(ctx, id)
+	if err != nil {
+		response.Internal(w, r, err)
+		return false
+	}
+	if inUse {
+		response.Conflict(w, r, "The base unit and pack sizes can't change while the product has stock on hand or on open purchase orders")
+		return false
+	}
+	return true
+}
+
+// productInUse reports whether any store has the product on hand or any
+// purchase order is still to bring it in.
+func productInUse(ctx context.Context, id primitive.ObjectID) (bool, error) {
+	stocked, err := Repos.Stock.Stocked(ctx, id)
+	if err != nil || stocked {
+		return stocked, err
+	}
+	return Repos.Purchases.Awaiting(ctx, id)
+}
+
+// checkBarcodes writes a 422 unless each of the product's barcodes is valid
+// for its kind and used by no other product, counting a UPC-A and its
+// EAN-13 form as the same code.
+func checkBarcodes(ctx context.Context, w http.ResponseWriter, r *http.Request, self primitive.ObjectID, product *models.Product) bool {
+	if product.Barcodes == nil {
+		product.Barcodes = []models.Barcode{}
+	}
+	var invalid validate.Errors
+	seen := map[string]bool{}
+	for i, b := range product.Barcodes {
+		field := fmt.Sprintf("barcodes[%d].code", i)
+		if err := b.Check(); err != nil {
+			invalid = append(invalid, validate.FieldError{Field: field, Message: err.Error()})
+			continue
+		}
+		for _, code := range models.BarcodeVariants(b.Code) {
+			if seen[code] {
+				invalid = append(invalid, validate.FieldError{Field: field, Message: "is listed twice"})
+				break
+			}
+			seen[code] = true
+			other, err := Repos.Products.FindByBarcode(ctx, code)
+			if errors.Is(err, repository.ErrNotFound) {
+				continue
+			}
+			if err != nil {
+				response.Internal(w, r, err)
+				return false
+			}
+			if other.ID != self {
+				invalid = append(invalid, validate.FieldError{Field: field, Message: "is already used by " + other.SKU})
+				break
+			}
+		}
+	}
+	if len(invalid) > 0 {
+		response.Invalid(w, r, invalid)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,341 @@
+package handlers
+
+import (
+	"adonai-api/models"
+	"adonai-api/repository"
+	"adonai-api/response"
+	"adonai-api/validate"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var purchaseOrderList = listSpec{
+	sorts:       []string{"created_at", "total", "outstanding"},
+	defaultSort: "-created_at",
+	filters: map[string]listFilter{
+		"supplier_id": eqObjectID("supplier_id"),
+		"store_id":    eqObjectID("store_id"),
+		"status":      eqString("status"),
+		// outstanding=true keeps orders that still have goods to come.
+		"outstanding": {field: "outstanding", op: repository.OpGte, parse: func(s string) (interface{}, error) {
+			if b, err := strconv.ParseBool(s); err != nil || !b {
+				return nil, errors.New("only outstanding=true is supported")
+			}
+			return 1, nil
+		}},
+	},
+}
+
+// PurchaseOrderRequest is the body of POST and PUT /purchase-order.
+type PurchaseOrderRequest struct {
+	SupplierID primitive.ObjectID    `json:"supplier_id" validate:"required"`
+	StoreID    primitive.ObjectID    `json:"store_id" validate:"required"`
+	Lines      []PurchaseLineRequest `json:"lines" validate:"min=1"`
+	Note       string                `json:"note" validate:"max=2000"`
+}
+
+type PurchaseLineRequest struct {
+	ProductID primitive.ObjectID `json:"product_id" validate:"required"`
+	Quantity  int                `json:"quantity" validate:"min=1"`
+	Unit      string             `json:"unit" validate:"max=32"` // the product's base unit if empty
+	UnitCost  models.Money       `json:"unit_cost" validate:"min=0"`
+}
+
+// ReceiveRequest is the body of POST /purchase-order/receive: what arrived
+// in one delivery, counted in each line's unit.
+type ReceiveRequest struct {
+	Lines []ReceiptLineRequest `json:"lines" validate:"min=1"`
+	Note  string               `json:"note" validate:"max=2000"`
+}
+
+type ReceiptLineRequest struct {
+	ProductID primitive.ObjectID `json:"product_id" validate:"required"`
+	Quantity  int                `json:"quantity" validate:"min=1"`
+	Lot       string             `json:"lot" validate:"max=100"`
+	ExpiresAt int64              `json:"expires_at" validate:"min=0"` // Unix seconds
+}
+
+func CreatePurchaseOrderHandler(w http.ResponseWriter, r *http.Request) {
+	_, callerID, ok := callerFromRequest(w, r)
+	if !ok {
+		return
+	}
+	var req PurchaseOrderRequest
+	if !decode(w, r, &req) {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	now := time.Now().Unix()
+	po := models.PurchaseOrder{
+		Status:    models.PODraft,
+		Receipts:  []models.PurchaseReceipt{},
+		CreatedBy: callerID,
+		CreatedAt: now,
+	}
+	if !fillPurchaseOrder(ctx, w, r, &po, &req) {
+		return
+	}
+	po.UpdatedAt = now
+	if err := Repos.Purchases.Create(ctx, &po); err != nil {
+		response.Internal(w, r, err)
+		return
+	}
+	writeCreated(w, "/purchase-order?id="+po.ID.Hex(), po.ID)
+}
+
+// fillPurchaseOrder checks the supplier, store and products a request names
+// and copies it onto po, writing a 422 when any of them is unknown.
+func fillPurchaseOrder(ctx context.Context, w http.ResponseWriter, r *http.Request, po *models.PurchaseOrder, req *PurchaseOrderRequest) bool {
+	var invalid validate.Errors
+	if _, err := Repos.Suppliers.FindByID(ctx, req.SupplierID); errors.Is(err, repository.ErrNotFound) {
+		invalid = append(invalid, validate.FieldError{Field: "supplier_id", Message: "is not a supplier"})
+	} else if err != nil {
+		response.Internal(w, r, err)
+		return false
+	}
+	if _, err := Repos.Stores.FindByID(ctx, req.StoreID); errors.Is(err, repository.ErrNotFound) {
+		invalid = append(invalid, validate.FieldError{Field: "store_id", Message: "is not a store"})
+	} else if err != nil {
+		response.Internal(w, r, err)
+		return false
+	}
+
+	lines := make([]models.PurchaseLine, 0, len(req.Lines))
+	seen := map[primitive.ObjectID]bool{}
+	for i, line := range req.Lines {
+		field := fmt.Sprintf("lines[%d].product_id", i)
+		product, err := Repos.Products.FindByID(ctx, line.ProductID)
+		if errors.Is(err, repository.ErrNotFound) {
+			invalid = append(invalid, validate.FieldError{Field: field, Message: "is not a product"})
+			continue
+		}
+		if err != nil {
+			response.Internal(w, r, err)
+			return false
+		}
+		if seen[product.ID] {
+			invalid = append(invalid, validate.FieldError{Field: field, Message: "is already on another line"})
+			continue
+		}
+		seen[product.ID] = true
+		unit := line.Unit
+		if unit == "" {
+			unit = product.Unit
+		}
+		factor, _, ok := product.InUnit(unit)
+		if !ok {
+			invalid = append(invalid, validate.FieldError{Field: fmt.Sprintf("lines[%d].unit", i), Message: unitMessage(product)})
+			continue
+		}
+		lines = append(lines, models.PurchaseLine{
+			ProductID: product.ID,
+			SKU:       product.SKU,
+			Name:      product.Name,
+			Unit:      unit,
+			Factor:    factor,
+			Quantity:  line.Quantity,
+			UnitCost:  line.UnitCost,
+		})
+	}
+	if len(invalid) > 0 {
+		response.Invalid(w, r, invalid)
+		return false
+	}
+
+	po.SupplierID, po.StoreID, po.Lines, po.Note = req.SupplierID, req.StoreID, lines, req.Note
+	po.Recalculate()
+	return true
+}
+
+func GetPurchaseOrderHandler(w http.ResponseWriter, r *http.Request) {
+	id, ok := idParam(w, r, "id")
+	if !ok {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	po, err := Repos.Purchases.FindByID(ctx, id)
+	if err != nil {
+		repoError(w, r, err, "Purchase order not found")
+		return
+	}
+	response.JSON(w, http.StatusOK, po)
+}
+
+func GetPurchaseOrdersHandler(w http.ResponseWriter, r *http.Request) {
+	q, ok := listQuery(w, r, purchaseOrderList)
+	if !ok {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	page, err := Repos.Purchases.List(ctx, q)
+	if err != nil {
+		listError(w, r, err)
+		return
+	}
+	writePage(w, r, q, page)
+}
+
+// UpdatePurchaseOrderHandler replaces a draft's supplier, store, lines and
+// note.
+func UpdatePurchaseOrderHandler(w http.ResponseWriter, r *http.Request) {
+	id, ok := idParam(w, r, "id")
+	if !ok {
+		return
+	}
+	var req PurchaseOrderRequest
+	if !decode(w, r, &req) {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var edited models.PurchaseOrder
+	if !fillPurchaseOrder(ctx, w, r, &edited, &req) {
+		return
+	}
+	updatePurchaseOrder(ctx, w, r, id, func(po *models.PurchaseOrder) ([]models.StockMovement, error) {
+		if po.Status != models.PODraft {
+			return nil, statusError{po.Status}
+		}
+		po.SupplierID, po.StoreID, po.Lines, po.Note = edited.SupplierID, edited.StoreID, edited.Lines, edited.Note
+		po.Recalculate()
+		return nil, nil
+	})
+}
+
+// ApprovePurchaseOrderHandler approves a draft so goods can be received
+// against it.
+func ApprovePurchaseOrderHandler(w http.ResponseWriter, r *http.Request) {
+	_, callerID, ok := callerFromRequest(w, r)
+	if !ok {
+		return
+	}
+	id, ok := idParam(w, r, "id")
+	if !ok {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	updatePurchaseOrder(ctx, w, r, id, func(po *models.PurchaseOrder) ([]models.StockMovement, error) {
+		if po.Status != models.PODraft {
+			return nil, statusError{po.Status}
+		}
+		po.Status = models.POApproved
+		po.ApprovedBy, po.ApprovedAt = callerID, time.Now().Unix()
+		po.Recalculate()
+		return nil, nil
+	})
+}
+
+// CancelPurchaseOrderHandler cancels whatever hasn't been received yet.
+// Goods already received stay in stock.
+func CancelPurchaseOrderHandler(w http.ResponseWriter, r *http.Request) {
+	id, ok := idParam(w, r, "id")
+	if !ok {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	updatePurchaseOrder(ctx, w, r, id, func(po *models.PurchaseOrder) ([]models.StockMovement, error) {
+		if po.Status != models.PODraft && !po.Receivable() {
+			return nil, statusError{po.Status}
+		}
+		po.Status = models.POCancelled
+		po.Recalculate()
+		return nil, nil
+	})
+}
+
+// ReceivePurchaseOrderHandler records a delivery against an approved
+// purchase order and posts a stock receipt for each line. Deliveries may be
+// partial, but never more than is outstanding. A line with a lot number or
+// expiry date starts a lot.
+func ReceivePurchaseOrderHandler(w http.ResponseWriter, r *http.Request) {
+	_, callerID, ok := callerFromRequest(w, r)
+	if !ok {
+		return
+	}
+	id, ok := idParam(w, r, "id")
+	if !ok {
+		return
+	}
+	var req ReceiveRequest
+	if !decode(w, r, &req) {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	updatePurchaseOrder(ctx, w, r, id, func(po *models.PurchaseOrder) ([]models.StockMovement, error) {
+		if !po.Receivable() {
+			return nil, statusError{po.Status}
+		}
+		now := time.Now().Unix()
+		receipt := models.PurchaseReceipt{Note: req.Note, ReceivedBy: callerID, ReceivedAt: now}
+		var movements []models.StockMovement
+		var invalid validate.Errors
+		for i, got := range req.Lines {
+			line := purchaseLine(po, got.ProductID)
+			switch {
+			case line == nil:
+				invalid = append(invalid, validate.FieldError{Field: fmt.Sprintf("lines[%d].product_id", i), Message: "is not on this purchase order"})
+				continue
+			case got.Quantity > line.Outstanding:
+				invalid = append(invalid, validate.FieldError{Field: fmt.Sprintf("lines[%d].quantity", i), Message: fmt.Sprintf("is more than the %d outstanding", line.Outstanding)})
+				continue
+			}
+			line.Received += got.Quantity
+			line.Outstanding -= got.Quantity
+			receipt.Lines = append(receipt.Lines, models.ReceiptLine{ProductID: got.ProductID, Quantity: got.Quantity, Lot: got.Lot, ExpiresAt: got.ExpiresAt})
+			mv := models.StockMovement{
+				StoreID:   po.StoreID,
+				ProductID: got.ProductID,
+				Kind:      models.MoveReceipt,
+				Lot:       got.Lot,
+				ExpiresAt: got.ExpiresAt,
+				Source:    "purchase_order",
+				SourceID:  po.ID,
+				Actor:     callerID,
+				CreatedAt: now,
+			}
+			mv.SetQuantity(got.Quantity, line.Unit, max(line.Factor, 1))
+			movements = append(movements, mv)
+		}
+		if len(invalid) > 0 {
+			return nil, invalid
+		}
+		po.Receipts = append(po.Receipts, receipt)
+		po.Recalculate()
+		return movements, nil
+	})
+}
+
+func purchaseLine(po *models.PurchaseOrder, productID primitive.ObjectID) *models.PurchaseLine {
+	for i := range po.Lines {
+		if po.Lines[i].ProductID == productID {
+			return &po.Lines[i]
+		}
+	}
+	return nil
+}
+
+// updatePurchaseOrder applies fn through the repository and writes the
+// updated purchase order or the error.
+func updatePurchaseOrder(ctx context.Context, w http.ResponseWriter, r *http.Request, id primitive.ObjectID, fn func(*models.PurchaseOrder) ([]models.StockMovement, error)) {
+	po, err := Repos.Purchases.Update(ctx, id, func(po *models.PurchaseOrder) ([]models.StockMovement, error) {
+		movements, err := fn(po)
+		po.UpdatedAt = time.Now().Unix()
+		return movements, err
+	})
+	writeUpdated(w, r, po, err, "purchase order")
+}
@@ -0,0 +1,346 @@
+package handlers
+
+import (
+	"adonai-api/config"
+	"adonai-api/models"
+	"adonai-api/repository"
+	"adonai-api/response"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var errSessionInvalid = errors.New("session is revoked or expired")
+
+func newRefreshToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func hashRefreshToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// clientIP returns the address of the client that made the request. Only a
+// request from a trusted proxy has its X-Forwarded-For read, and then from
+// the right: each proxy appends the address it got the request from, so the
+// right-most address that isn't a trusted proxy is the first one a client
+// couldn't have forged.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	if !config.Current.HTTP.TrustsProxy(host) {
+		return host
+	}
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if hop == "" {
+			continue
+		}
+		if !config.Current.HTTP.TrustsProxy(hop) {
+			return hop
+		}
+		host = hop
+	}
+	return host
+}
+
+// startSession creates a new session family for the user and sets both the
+// access and refresh token cookies.
+func startSession(ctx context.Context, w http.ResponseWriter, r *http.Request, user *models.User) error {
+	refreshToken, err := newRefreshToken()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	session := models.Session{
+		UserID:      user.ID,
+		RefreshHash: hashRefreshToken(refreshToken),
+		UsedHashes:  []string{},
+		UserAgent:   r.UserAgent(),
+		IPAddress:   clientIP(r),
+		CreatedAt:   now.Unix(),
+		LastUsedAt:  now.Unix(),
+		ExpiresAt:   now.Add(config.Current.Auth.RefreshTokenTTL).Unix(),
+	}
+	session.PurgeAt = time.Unix(session.ExpiresAt, 0)
+	err = Repos.Sessions.Create(ctx, &session)
+	if err != nil {
+		return err
+	}
+
+	return setSessionCookies(w, user, &session, refreshToken)
+}
+
+func setSessionCookies(w http.ResponseWriter, user *models.User, session *models.Session, refreshToken string) error {
+	expirationTime := time.Now().Add(config.Current.Auth.AccessTokenTTL)
+	claims := &Claims{
+		UserID:    user.ID.Hex(),
+		Username:  user.Username,
+		Role:      user.Role,
+		SessionID: session.ID.Hex(),
+		StandardClaims: jwt.StandardClaims{
+			ExpiresAt: expirationTime.Unix(),
+		},
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	tokenString, err := token.SignedString(JwtKey())
+	if err != nil {
+		return err
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     "token",
+		Value:    tokenString,
+		Path:     "/",
+		Expires:  expirationTime,
+		HttpOnly: true,
+		SameSite: http.SameSiteStrictMode,
+	})
+	http.SetCookie(w, &http.Cookie{
+		Name:     "refresh_token",
+		Value:    refreshToken,
+		Path:     "/",
+		Expires:  time.Unix(session.ExpiresAt, 0),
+		HttpOnly: true,
+		SameSite: http.SameSiteStrictMode,
+	})
+	return nil
+}
+
+func clearSessionCookies(w http.ResponseWriter) {
+	for _, name := range []string{"token", "refresh_token"} {
+		http.SetCookie(w, &http.Cookie{Name: name, Value: "", Path: "/", MaxAge: -1})
+	}
+}
+
+// SessionActive reports whether the session an access token was issued for
+// is still valid. JwtAuthMiddleware calls it on every request so revoking a
+// session takes effect immediately rather than when the access token expires.
+func SessionActive(ctx context.Context, sessionID string) bool {
+	session, err := findSession(ctx, sessionID)
+	if err != nil {
+		return false
+	}
+	return session.RevokedAt == 0 && time.Now().Unix() <= session.ExpiresAt
+}
+
+func findSession(ctx context.Context, sessionID string) (*models.Session, error) {
+	id, err := primitive.ObjectIDFromHex(sessionID)
+	if err != nil {
+		return nil, err
+	}
+	return Repos.Sessions.FindByID(ctx, id)
+}
+
+// rotateRefreshToken swaps the presented refresh token for a new one. A token
+// that was already rotated out is treated as stolen and revokes its family.
+func rotateRefreshToken(ctx context.Context, presented string) (*models.Session, string, error) {
+	hash := hashRefreshToken(presented)
+
+	session, err := Repos.Sessions.FindByRefreshHash(ctx, hash)
+	if err == repository.ErrNotFound {
+		reused, err := Repos.Sessions.FindByUsedHash(ctx, hash)
+		if err == nil {
+			Repos.Sessions.Revoke(ctx, reused.ID, "refresh token reuse", time.Now().Unix())
+		}
+		return nil, "", errSessionInvalid
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	if session.RevokedAt != 0 || time.Now().Unix() > session.ExpiresAt {
+		return nil, "", errSessionInvalid
+	}
+
+	next, err := newRefreshToken()
+	if err != nil {
+		return nil, "", err
+	}
+	session.RefreshHash = hashRefreshToken(next)
+	session.LastUsedAt = time.Now().Unix()
+
+	// Matching on the old hash makes concurrent refreshes with the same
+	// token race for a single winner; the loser is handled as reuse.
+	rotated, err := Repos.Sessions.Rotate(ctx, session.ID, hash, session.RefreshHash, session.LastUsedAt)
+	if err != nil {
+		return nil, "", err
+	}
+	if !rotated {
+		Repos.Sessions.Revoke(ctx, session.ID, "refresh token reuse", time.Now().Unix())
+		return nil, "", errSessionInvalid
+	}
+	return session, next, nil
+}
+
+func RefreshHandler(w http.ResponseWriter, r *http.Request) {
+	cookie, err := r.Cookie("refresh_token")
+	if err != nil {
+		response.Unauthorized(w, r, "Unauthorized")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	session, refreshToken, err := rotateRefreshToken(ctx, cookie.Value)
+	if err == errSessionInvalid {
+		clearSessionCookies(w)
+		response.Unauthorized(w, r, "Unauthorized")
+		return
+	}
+	if err != nil {
+		response.Internal(w, r, err)
+		return
+	}
+
+	user, err := Repos.Users.FindByID(ctx, session.UserID)
+	if err != nil {
+		response.Unauthorized(w, r, "Unauthorized")
+		return
+	}
+
+	if err := setSessionCookies(w, user, session, refreshToken); err != nil {
+		response.Internal(w, r, err)
+		return
+	}
+	response.Message(w, http.StatusOK, "Token refreshed")
+}
+
+func LogoutHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	claims, ok := ClaimsFromContext(r.Context())
+	if !ok {
+		response.Unauthorized(w, r, "Unauthorized")
+		return
+	}
+	sessionID, err := primitive.ObjectIDFromHex(claims.SessionID)
+	if err != nil {
+		response.Unauthorized(w, r, "Unauthorized")
+		return
+	}
+	if _, err := Repos.Sessions.Revoke(ctx, sessionID, "logout", time.Now().Unix()); err != nil {
+		response.Internal(w, r, err)
+		return
+	}
+
+	clearSessionCookies(w)
+	response.Message(w, http.StatusOK, "Logged out")
+}
+
+// GetSessionsHandler lists the caller's sessions. Admins may pass user_id to
+// list another user's sessions.
+func GetSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	caller, callerID, ok := callerFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	userID, ok := sessionTargetUser(w, r, caller, callerID)
+	if !ok {
+		return
+	}
+
+	sessions, err := Repos.Sessions.ListByUser(ctx, userID)
+	if err != nil {
+		response.Internal(w, r, err)
+		return
+	}
+	if sessions == nil {
+		sessions = []models.Session{}
+	}
+	response.JSON(w, http.StatusOK, sessions)
+}
+
+// RevokeSessionHandler revokes a single session by id. Users may revoke their
+// own sessions; admins may revoke anyone's.
+func RevokeSessionHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	caller, callerID, ok := callerFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	session, err := findSession(ctx, r.URL.Query().Get("id"))
+	if err != nil {
+		response.NotFound(w, r, "Session not found")
+		return
+	}
+	if session.UserID != callerID && !caller.HasRole("admin") {
+		response.NotFound(w, r, "Session not found")
+		return
+	}
+
+	if _, err := Repos.Sessions.Revoke(ctx, session.ID, "revoked by "+caller.Username, time.Now().Unix()); err != nil {
+		response.Internal(w, r, err)
+		return
+	}
+	response.Message(w, http.StatusOK, "Session revoked")
+}
+
+// RevokeAllSessionsHandler signs the caller out everywhere, or, for admins
+// passing user_id, signs that user out everywhere.
+func RevokeAllSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	caller, callerID, ok := callerFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	userID, ok := sessionTargetUser(w, r, caller, callerID)
+	if !ok {
+		return
+	}
+
+	revoked, err := Repos.Sessions.RevokeByUser(ctx, userID, "revoked by "+caller.Username, time.Now().Unix())
+	if err != nil {
+		response.Internal(w, r, err)
+		return
+	}
+	response.JSON(w, http.StatusOK, map[string]int64{"revoked": revoked})
+}
+
+func sessionTargetUser(w http.ResponseWriter, r *http.Request, caller *Claims, callerID primitive.ObjectID) (primitive.ObjectID, bool) {
+	param := r.URL.Query().Get("user_id")
+	if param == "" || param == callerID.Hex() {
+		return callerID, true
+	}
+	if !caller.HasRole("admin") {
+		response.Forbidden(w, r)
+		return primitive.NilObjectID, false
+	}
+	userID, err := primitive.ObjectIDFromHex(param)
+	if err != nil {
+		response.BadRequest(w, r, "Invalid user_id")
+		return primitive.NilObjectID, false
+	}
+	return userID, true
+}
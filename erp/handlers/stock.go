@@ -0,0 +1,178 @@
+package handlers
+
+import (
+	"adonai-api/models"
+	"adonai-api/repository"
+	"adonai-api/response"
+	"adonai-api/validate"
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var stockList = listSpec{
+	sorts:       []string{"available", "on_hand", "reserved", "updated_at"},
+	defaultSort: "available",
+	filters: map[string]listFilter{
+		"store_id":   eqObjectID("store_id"),
+		"product_id": eqObjectID("product_id"),
+	},
+}
+
+var movementList = listSpec{
+	sorts:       []string{"created_at"},
+	defaultSort: "-created_at",
+	filters: map[string]listFilter{
+		"store_id":     eqObjectID("store_id"),
+		"product_id":   eqObjectID("product_id"),
+		"kind":         eqString("kind"),
+		"source_id":    eqObjectID("source_id"),
+		"lot_id":       eqObjectID("lot_id"),
+		"created_from": unixFrom("created_at"),
+		"created_to":   unixTo("created_at"),
+	},
+}
+
+// MovementRequest is the body of POST /stock/movements. Sales, reservations
+// and releases come from orders, so they can't be posted by hand.
+type MovementRequest struct {
+	StoreID   primitive.ObjectID `json:"store_id" validate:"required"`
+	ProductID primitive.ObjectID `json:"product_id" validate:"required"`
+	Kind      string             `json:"kind" validate:"required,oneof=receipt adjustment return"`
+	Quantity  int                `json:"quantity"`               // signed for adjustments, positive otherwise
+	Unit      string             `json:"unit" validate:"max=32"` // the product's base unit if empty
+	Lot       string             `json:"lot" validate:"max=100"`
+	ExpiresAt int64              `json:"expires_at" validate:"min=0"` // Unix seconds
+	Note      string             `json:"note" validate:"max=500"`
+}
+
+// GetStockHandler lists stock levels, lowest available first.
+func GetStockHandler(w http.ResponseWriter, r *http.Request) {
+	q, ok := listQuery(w, r, stockList)
+	if !ok {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	page, err := Repos.Stock.Levels(ctx, q)
+	if err != nil {
+		listError(w, r, err)
+		return
+	}
+	writePage(w, r, q, page)
+}
+
+// GetMovementsHandler lists the stock ledger, newest first.
+func GetMovementsHandler(w http.ResponseWriter, r *http.Request) {
+	q, ok := listQuery(w, r, movementList)
+	if !ok {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	page, err := Repos.Stock.Movements(ctx, q)
+	if err != nil {
+		listError(w, r, err)
+		return
+	}
+	writePage(w, r, q, page)
+}
+
+// PostMovementHandler records a receipt, return or adjustment.
+func PostMovementHandler(w http.ResponseWriter, r *http.Request) {
+	_, callerID, ok := callerFromRequest(w, r)
+	if !ok {
+		return
+	}
+	var req MovementRequest
+	if !decode(w, r, &req) {
+		return
+	}
+	if req.Quantity == 0 || (req.Kind != models.MoveAdjustment && req.Quantity < 0) {
+		response.Invalid(w, r, validate.Errors{{Field: "quantity", Message: "must be positive, or non-zero for an adjustment"}})
+		return
+	}
+	if req.Kind != models.MoveReceipt && (req.Lot != "" || req.ExpiresAt != 0) {
+		response.Invalid(w, r, validate.Errors{{Field: "lot", Message: "can only be given for a receipt"}})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	product, ok := stockedItem(ctx, w, r, req.StoreID, req.ProductID)
+	if !ok {
+		return
+	}
+	factor, _, ok := product.InUnit(req.Unit)
+	if !ok {
+		response.Invalid(w, r, validate.Errors{{Field: "unit", Message: unitMessage(product)}})
+		return
+	}
+
+	movements := []models.StockMovement{{
+		StoreID:   req.StoreID,
+		ProductID: req.ProductID,
+		Kind:      req.Kind,
+		Lot:       req.Lot,
+		ExpiresAt: req.ExpiresAt,
+		Note:      req.Note,
+		Actor:     callerID,
+		CreatedAt: time.Now().Unix(),
+	}}
+	movements[0].SetQuantity(req.Quantity, req.Unit, factor)
+	if !postStock(ctx, w, r, movements) {
+		return
+	}
+	writeCreated(w, "/stock?store_id="+req.StoreID.Hex()+"&product_id="+req.ProductID.Hex(), movements[0].ID)
+}
+
+// stockedItem checks that a store and product exist and returns the
+// product, writing a 422 naming whichever doesn't.
+func stockedItem(ctx context.Context, w http.ResponseWriter, r *http.Request, storeID, productID primitive.ObjectID) (*models.Product, bool) {
+	var invalid validate.Errors
+	if _, err := Repos.Stores.FindByID(ctx, storeID); errors.Is(err, repository.ErrNotFound) {
+		invalid = append(invalid, validate.FieldError{Field: "store_id", Message: "is not a store"})
+	} else if err != nil {
+		response.Internal(w, r, err)
+		return nil, false
+	}
+	product, err := Repos.Products.FindByID(ctx, productID)
+	if errors.Is(err, repository.ErrNotFound) {
+		invalid = append(invalid, validate.FieldError{Field: "product_id", Message: "is not a product"})
+	} else if err != nil {
+		response.Internal(w, r, err)
+		return nil, false
+	}
+	if len(invalid) > 0 {
+		response.Invalid(w, r, invalid)
+		return nil, false
+	}
+	return product, true
+}
+
+// unitMessage is the field error for a unit a product doesn't come in.
+func unitMessage(product *models.Product) string {
+	return "is not a unit of " + product.SKU + "; use one of: " + strings.Join(product.UnitNames(), ", ")
+}
+
+// postStock posts movements, answering 409 when there isn't enough stock.
+func postStock(ctx context.Context, w http.ResponseWriter, r *http.Request, movements []models.StockMovement) bool {
+	err := Repos.Stock.Post(ctx, movements)
+	if errors.Is(err, repository.ErrInsufficientStock) {
+		insufficientStock(w, r)
+		return false
+	}
+	if err != nil {
+		response.Internal(w, r, err)
+		return false
+	}
+	return true
+}
+
+func insufficientStock(w http.ResponseWriter, r *http.Request) {
+	response.Error(w, r, http.StatusConflict, "insufficient_stock", "Not enough stock available")
+}
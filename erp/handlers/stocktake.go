@@ -0,0 +1,325 @@
+package handlers
+
+import (
+	"adonai-api/models"
+	"adonai-api/repository"
+	"adonai-api/response"
+	"adonai-api/validate"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var stocktakeList = listSpec{
+	sorts:       []string{"opened_at"},
+	defaultSort: "-opened_at",
+	filters: map[string]listFilter{
+		"store_id": eqObjectID("store_id"),
+		"status":   eqString("status"),
+	},
+}
+
+// StocktakeRequest is the body of POST /stocktake. Without product_ids it
+// counts every product the store has stock records for; with them it is a
+// cycle count of just those.
+type StocktakeRequest struct {
+	StoreID    primitive.ObjectID   `json:"store_id" validate:"required"`
+	ProductIDs []primitive.ObjectID `json:"product_ids"`
+	Note       string               `json:"note" validate:"max=2000"`
+}
+
+// CountRequest is the body of POST /stocktake/count: one device's counts.
+type CountRequest struct {
+	Device string             `json:"device" validate:"required,max=100"`
+	Counts []CountLineRequest `json:"counts" validate:"min=1"`
+}
+
+type CountLineRequest struct {
+	ProductID primitive.ObjectID `json:"product_id" validate:"required"`
+	Quantity  int                `json:"quantity" validate:"min=0"`
+	Unit      string             `json:"unit" validate:"max=32"` // the product's base unit if empty
+}
+
+// OpenStocktakeHandler opens a count session, snapshotting what the stock
+// ledger expects on hand for each product.
+func OpenStocktakeHandler(w http.ResponseWriter, r *http.Request) {
+	_, callerID, ok := callerFromRequest(w, r)
+	if !ok {
+		return
+	}
+	var req StocktakeRequest
+	if !decode(w, r, &req) {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	if _, err := Repos.Stores.FindByID(ctx, req.StoreID); errors.Is(err, repository.ErrNotFound) {
+		response.Invalid(w, r, validate.Errors{{Field: "store_id", Message: "is not a store"}})
+		return
+	} else if err != nil {
+		response.Internal(w, r, err)
+		return
+	}
+
+	productIDs := req.ProductIDs
+	if len(productIDs) == 0 {
+		levels, err := Repos.Stock.Levels(ctx, repository.Query{}.Where("store_id", repository.OpEq, req.StoreID))
+		if err != nil {
+			response.Internal(w, r, err)
+			return
+		}
+		for _, level := range levels.Items {
+			productIDs = append(productIDs, level.ProductID)
+		}
+	}
+
+	now := time.Now().Unix()
+	stocktake := models.Stocktake{
+		StoreID:  req.StoreID,
+		Status:   models.StocktakeOpen,
+		Lines:    []models.StocktakeLine{},
+		Note:     req.Note,
+		OpenedBy: callerID,
+		OpenedAt: now,
+	}
+	var invalid validate.Errors
+	seen := map[primitive.ObjectID]bool{}
+	for i, id := range productIDs {
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+		line, err := stocktakeLine(ctx, req.StoreID, id)
+		if errors.Is(err, repository.ErrNotFound) {
+			invalid = append(invalid, validate.FieldError{Field: fmt.Sprintf("product_ids[%d]", i), Message: "is not a product"})
+			continue
+		}
+		if err != nil {
+			response.Internal(w, r, err)
+			return
+		}
+		stocktake.Lines = append(stocktake.Lines, *line)
+	}
+	if len(invalid) > 0 {
+		response.Invalid(w, r, invalid)
+		return
+	}
+	stocktake.Recalculate()
+	stocktake.UpdatedAt = now
+
+	if err := Repos.Stocktakes.Create(ctx, &stocktake); err != nil {
+		response.Internal(w, r, err)
+		return
+	}
+	writeCreated(w, "/stocktake?id="+stocktake.ID.Hex(), stocktake.ID)
+}
+
+// stocktakeLine snapshots a product's expected on-hand quantity at a store.
+func stocktakeLine(ctx context.Context, storeID, productID primitive.ObjectID) (*models.StocktakeLine, error) {
+	product, err := Repos.Products.FindByID(ctx, productID)
+	if err != nil {
+		return nil, err
+	}
+	level, err := Repos.Stock.Level(ctx, storeID, productID)
+	if err != nil {
+		return nil, err
+	}
+	return &models.StocktakeLine{
+		ProductID: product.ID,
+		SKU:       product.SKU,
+		Name:      product.Name,
+		Expected:  level.OnHand,
+		Counts:    []models.StocktakeCount{},
+	}, nil
+}
+
+// GetStocktakeHandler returns a stocktake with its variances so far.
+func GetStocktakeHandler(w http.ResponseWriter, r *http.Request) {
+	id, ok := idParam(w, r, "id")
+	if !ok {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	stocktake, err := Repos.Stocktakes.FindByID(ctx, id)
+	if err != nil {
+		repoError(w, r, err, "Stocktake not found")
+		return
+	}
+	response.JSON(w, http.StatusOK, stocktake)
+}
+
+func GetStocktakesHandler(w http.ResponseWriter, r *http.Request) {
+	q, ok := listQuery(w, r, stocktakeList)
+	if !ok {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	page, err := Repos.Stocktakes.List(ctx, q)
+	if err != nil {
+		listError(w, r, err)
+		return
+	}
+	writePage(w, r, q, page)
+}
+
+// CountStocktakeHandler records one device's counts. Devices count
+// separately and their counts of a product add up; a device sending a
+// product again replaces its earlier count. A product found that wasn't in
+// the snapshot is added with nothing expected.
+func CountStocktakeHandler(w http.ResponseWriter, r *http.Request) {
+	_, callerID, ok := callerFromRequest(w, r)
+	if !ok {
+		return
+	}
+	id, ok := idParam(w, r, "id")
+	if !ok {
+		return
+	}
+	var req CountRequest
+	if !decode(w, r, &req) {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	existing, err := Repos.Stocktakes.FindByID(ctx, id)
+	if err != nil {
+		repoError(w, r, err, "Stocktake not found")
+		return
+	}
+	// Look up the counted products before the update, which should stay
+	// short, to convert counts given in a pack unit and to add products
+	// missing from the snapshot.
+	now := time.Now().Unix()
+	counts := make([]models.StocktakeCount, len(req.Counts))
+	found := map[primitive.ObjectID]*models.StocktakeLine{}
+	var invalid validate.Errors
+	for i, count := range req.Counts {
+		product, err := Repos.Products.FindByID(ctx, count.ProductID)
+		if errors.Is(err, repository.ErrNotFound) {
+			invalid = append(invalid, validate.FieldError{Field: fmt.Sprintf("counts[%d].product_id", i), Message: "is not a product"})
+			continue
+		}
+		if err != nil {
+			response.Internal(w, r, err)
+			return
+		}
+		factor, _, ok := product.InUnit(count.Unit)
+		if !ok {
+			invalid = append(invalid, validate.FieldError{Field: fmt.Sprintf("counts[%d].unit", i), Message: unitMessage(product)})
+			continue
+		}
+		counts[i] = models.StocktakeCount{Device: req.Device, CountedBy: callerID, CountedAt: now}
+		counts[i].SetQuantity(count.Quantity, count.Unit, factor)
+		if stocktakeLineFor(existing, product.ID) == nil && found[product.ID] == nil {
+			found[product.ID] = &models.StocktakeLine{ProductID: product.ID, SKU: product.SKU, Name: product.Name, Counts: []models.StocktakeCount{}}
+		}
+	}
+	if len(invalid) > 0 {
+		response.Invalid(w, r, invalid)
+		return
+	}
+
+	updateStocktake(ctx, w, r, id, func(stocktake *models.Stocktake) ([]models.StockMovement, error) {
+		if stocktake.Status != models.StocktakeOpen {
+			return nil, statusError{stocktake.Status}
+		}
+		for i, count := range req.Counts {
+			line := stocktakeLineFor(stocktake, count.ProductID)
+			if line == nil {
+				stocktake.Lines = append(stocktake.Lines, *found[count.ProductID])
+				line = &stocktake.Lines[len(stocktake.Lines)-1]
+			}
+			line.SetCount(counts[i])
+		}
+		stocktake.Recalculate()
+		return nil, nil
+	})
+}
+
+// ApproveStocktakeHandler closes a stocktake and posts a count adjustment
+// for every counted line. The adjustment brings what is on hand now to the
+// count, rather than applying the variance from the snapshot, so sales and
+// receipts posted while the count was open aren't applied twice. Lines
+// nobody counted are left alone.
+func ApproveStocktakeHandler(w http.ResponseWriter, r *http.Request) {
+	_, callerID, ok := callerFromRequest(w, r)
+	if !ok {
+		return
+	}
+	id, ok := idParam(w, r, "id")
+	if !ok {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	updateStocktake(ctx, w, r, id, func(stocktake *models.Stocktake) ([]models.StockMovement, error) {
+		if stocktake.Status != models.StocktakeOpen {
+			return nil, statusError{stocktake.Status}
+		}
+		now := time.Now().Unix()
+		stocktake.Status = models.StocktakeApproved
+		stocktake.ApprovedBy, stocktake.ApprovedAt = callerID, now
+		stocktake.Recalculate()
+
+		var movements []models.StockMovement
+		for _, line := range stocktake.Lines {
+			if len(line.Counts) == 0 {
+				continue
+			}
+			mv := models.StockMovement{
+				StoreID:   stocktake.StoreID,
+				ProductID: line.ProductID,
+				Source:    "stocktake",
+				SourceID:  stocktake.ID,
+				Actor:     callerID,
+				CreatedAt: now,
+			}
+			mv.SetCounted(line.Counted)
+			movements = append(movements, mv)
+		}
+		return movements, nil
+	})
+}
+
+func CancelStocktakeHandler(w http.ResponseWriter, r *http.Request) {
+	id, ok := idParam(w, r, "id")
+	if !ok {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	updateStocktake(ctx, w, r, id, func(stocktake *models.Stocktake) ([]models.StockMovement, error) {
+		if stocktake.Status != models.StocktakeOpen {
+			return nil, statusError{stocktake.Status}
+		}
+		stocktake.Status = models.StocktakeCancelled
+		return nil, nil
+	})
+}
+
+func stocktakeLineFor(stocktake *models.Stocktake, productID primitive.ObjectID) *models.StocktakeLine {
+	for i := range stocktake.Lines {
+		if stocktake.Lines[i].ProductID == productID {
+			return &stocktake.Lines[i]
+		}
+	}
+	return nil
+}
+
+func updateStocktake(ctx context.Context, w http.ResponseWriter, r *http.Request, id primitive.ObjectID, fn func(*models.Stocktake) ([]models.StockMovement, error)) {
+	stocktake, err := Repos.Stocktakes.Update(ctx, id, func(stocktake *models.Stocktake) ([]models.StockMovement, error) {
+		movements, err := fn(stocktake)
+		stocktake.UpdatedAt = time.Now().Unix()
+		return movements, err
+	})
+	writeUpdated(w, r, stocktake, err, "stocktake")
+}
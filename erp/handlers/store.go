@@ -1,91 +1,95 @@
 package handlers
 
 import (
-	"adonai-api/config"
 	"adonai-api/models"
+	"adonai-api/response"
 	"context"
-	"encoding/json"
 	"net/http"
 	"time"
 
-	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 func CreateStoreHandler(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
 	var store models.Store
-	_ = json.NewDecoder(r.Body).Decode(&store)
-	collection := config.Client.Database("customer_vendor_api").Collection("stores")
-	ctx, _ := context.WithTimeout(context.Background(), 5*time.Second)
-	result, _ := collection.InsertOne(ctx, store)
-	json.NewEncoder(w).Encode(result)
+	if !decode(w, r, &store) {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	err := Repos.Stores.Create(ctx, &store)
+	if err != nil {
+		response.Internal(w, r, err)
+		return
+	}
+	writeCreated(w, "/store?id="+store.ID.Hex(), store.ID)
 }
 
 func GetStoreHandler(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
-	params := r.URL.Query()
-	id, _ := primitive.ObjectIDFromHex(params.Get("id"))
-	var store models.Store
-	collection := config.Client.Database("customer_vendor_api").Collection("stores")
-	ctx, _ := context.WithTimeout(context.Background(), 30*time.Second)
-	err := collection.FindOne(ctx, models.Store{ID: id}).Decode(&store)
+	id, ok := idParam(w, r, "id")
+	if !ok {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	store, err := Repos.Stores.FindByID(ctx, id)
 	if err != nil {
-		http.Error(w, "Store not found", http.StatusNotFound)
+		repoError(w, r, err, "Store not found")
 		return
 	}
-	json.NewEncoder(w).Encode(store)
+	response.JSON(w, http.StatusOK, store)
+}
+
+var storeList = listSpec{
+	sorts: []string{"name"},
 }
 
 func GetStoresHandler(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
-	var stores []models.Store
-	collection := config.Client.Database("customer_vendor_api").Collection("stores")
-	ctx, _ := context.WithTimeout(context.Background(), 30*time.Second)
-	cursor, err := collection.Find(ctx, bson.M{})
-	if err != nil {
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	q, ok := listQuery(w, r, storeList)
+	if !ok {
 		return
 	}
-	defer cursor.Close(ctx)
-	for cursor.Next(ctx) {
-		var store models.Store
-		cursor.Decode(&store)
-		stores = append(stores, store)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	page, err := Repos.Stores.List(ctx, q)
+	if err != nil {
+		listError(w, r, err)
+		return
 	}
-	json.NewEncoder(w).Encode(stores)
+	writePage(w, r, q, page)
 }
 
 func UpdateStoreHandler(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
+	id, ok := idParam(w, r, "id")
+	if !ok {
+		return
+	}
 	var store models.Store
-	_ = json.NewDecoder(r.Body).Decode(&store)
-	collection := config.Client.Database("customer_vendor_api").Collection("stores")
-	ctx, _ := context.WithTimeout(context.Background(), 5*time.Second)
-	params := r.URL.Query()
-	id, _ := primitive.ObjectIDFromHex(params.Get("id"))
-	filter := bson.M{"_id": id}
-	update := bson.M{
-		"$set": store,
+	if !decode(w, r, &store) {
+		return
 	}
-	_, err := collection.UpdateOne(ctx, filter, update)
+	store.ID = primitive.NilObjectID // the id comes from the query, never the body
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	updated, err := Repos.Stores.Update(ctx, id, &store)
 	if err != nil {
-		http.Error(w, "Store not found", http.StatusNotFound)
+		repoError(w, r, err, "Store not found")
 		return
 	}
-	json.NewEncoder(w).Encode(store)
+	response.JSON(w, http.StatusOK, updated)
 }
 
 func DeleteStoreHandler(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
-	params := r.URL.Query()
-	id, _ := primitive.ObjectIDFromHex(params.Get("id"))
-	collection := config.Client.Database("customer_vendor_api").Collection("stores")
-	ctx, _ := context.WithTimeout(context.Background(), 30*time.Second)
-	_, err := collection.DeleteOne(ctx, bson.M{"_id": id})
+	id, ok := idParam(w, r, "id")
+	if !ok {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	err := Repos.Stores.Delete(ctx, id)
 	if err != nil {
-		http.Error(w, "Store not found", http.StatusNotFound)
+		repoError(w, r, err, "Store not found")
 		return
 	}
-	json.NewEncoder(w).Encode("Store deleted")
+	w.WriteHeader(http.StatusNoContent)
 }
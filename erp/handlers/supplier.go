@@ -0,0 +1,95 @@
+package handlers
+
+import (
+	"adonai-api/models"
+	"adonai-api/response"
+	"context"
+	"net/http"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func CreateSupplierHandler(w http.ResponseWriter, r *http.Request) {
+	var supplier models.Supplier
+	if !decode(w, r, &supplier) {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	err := Repos.Suppliers.Create(ctx, &supplier)
+	if err != nil {
+		response.Internal(w, r, err)
+		return
+	}
+	writeCreated(w, "/supplier?id="+supplier.ID.Hex(), supplier.ID)
+}
+
+func GetSupplierHandler(w http.ResponseWriter, r *http.Request) {
+	id, ok := idParam(w, r, "id")
+	if !ok {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	supplier, err := Repos.Suppliers.FindByID(ctx, id)
+	if err != nil {
+		repoError(w, r, err, "Supplier not found")
+		return
+	}
+	response.JSON(w, http.StatusOK, supplier)
+}
+
+var supplierList = listSpec{
+	sorts: []string{"name"},
+}
+
+func GetSuppliersHandler(w http.ResponseWriter, r *http.Request) {
+	q, ok := listQuery(w, r, supplierList)
+	if !ok {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	page, err := Repos.Suppliers.List(ctx, q)
+	if err != nil {
+		listError(w, r, err)
+		return
+	}
+	writePage(w, r, q, page)
+}
+
+func UpdateSupplierHandler(w http.ResponseWriter, r *http.Request) {
+	id, ok := idParam(w, r, "id")
+	if !ok {
+		return
+	}
+	var supplier models.Supplier
+	if !decode(w, r, &supplier) {
+		return
+	}
+	supplier.ID = primitive.NilObjectID // the id comes from the query, never the body
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	updated, err := Repos.Suppliers.Update(ctx, id, &supplier)
+	if err != nil {
+		repoError(w, r, err, "Supplier not found")
+		return
+	}
+	response.JSON(w, http.StatusOK, updated)
+}
+
+func DeleteSupplierHandler(w http.ResponseWriter, r *http.Request) {
+	id, ok := idParam(w, r, "id")
+	if !ok {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	err := Repos.Suppliers.Delete(ctx, id)
+	if err != nil {
+		repoError(w, r, err, "Supplier not found")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
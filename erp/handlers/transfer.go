@@ -0,0 +1,342 @@
+package handlers
+
+import (
+	"adonai-api/models"
+	"adonai-api/repository"
+	"adonai-api/response"
+	"adonai-api/validate"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var transferList = listSpec{
+	sorts:       []string{"created_at"},
+	defaultSort: "-created_at",
+	filters: map[string]listFilter{
+		"from_store_id":   eqObjectID("from_store_id"),
+		"to_store_id":     eqObjectID("to_store_id"),
+		"status":          eqString("status"),
+		"has_discrepancy": eqBool("has_discrepancy"),
+	},
+}
+
+// TransferRequest is the body of POST and PUT /transfer.
+type TransferRequest struct {
+	FromStoreID primitive.ObjectID    `json:"from_store_id" validate:"required"`
+	ToStoreID   primitive.ObjectID    `json:"to_store_id" validate:"required"`
+	Lines       []TransferLineRequest `json:"lines" validate:"min=1"`
+	Note        string                `json:"note" validate:"max=2000"`
+}
+
+type TransferLineRequest struct {
+	ProductID primitive.ObjectID `json:"product_id" validate:"required"`
+	Quantity  int                `json:"quantity" validate:"min=1"`
+	Unit      string             `json:"unit" validate:"max=32"` // the product's base unit if empty
+}
+
+// ReceiveTransferRequest is the body of POST /transfer/receive. Lines list
+// only what arrived differently from how it was dispatched; every other
+// line is taken to have arrived in full.
+type ReceiveTransferRequest struct {
+	Lines []TransferCountRequest `json:"lines"`
+}
+
+type TransferCountRequest struct {
+	ProductID primitive.ObjectID `json:"product_id" validate:"required"`
+	Quantity  int                `json:"quantity" validate:"min=0"`
+	Note      string             `json:"note" validate:"max=500"` // why it differs
+}
+
+func CreateTransferHandler(w http.ResponseWriter, r *http.Request) {
+	_, callerID, ok := callerFromRequest(w, r)
+	if !ok {
+		return
+	}
+	var req TransferRequest
+	if !decode(w, r, &req) {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	now := time.Now().Unix()
+	transfer := models.Transfer{
+		Status:    models.TransferDraft,
+		CreatedBy: callerID,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	if !fillTransfer(ctx, w, r, &transfer, &req) {
+		return
+	}
+	if err := Repos.Transfers.Create(ctx, &transfer); err != nil {
+		response.Internal(w, r, err)
+		return
+	}
+	writeCreated(w, "/transfer?id="+transfer.ID.Hex(), transfer.ID)
+}
+
+// fillTransfer checks the stores and products a request names and copies it
+// onto transfer, writing a 422 when any of them is unknown.
+func fillTransfer(ctx context.Context, w http.ResponseWriter, r *http.Request, transfer *models.Transfer, req *TransferRequest) bool {
+	var invalid validate.Errors
+	for _, store := range []struct {
+		field string
+		id    primitive.ObjectID
+	}{{"from_store_id", req.FromStoreID}, {"to_store_id", req.ToStoreID}} {
+		if _, err := Repos.Stores.FindByID(ctx, store.id); errors.Is(err, repository.ErrNotFound) {
+			invalid = append(invalid, validate.FieldError{Field: store.field, Message: "is not a store"})
+		} else if err != nil {
+			response.Internal(w, r, err)
+			return false
+		}
+	}
+	if req.FromStoreID == req.ToStoreID {
+		invalid = append(invalid, validate.FieldError{Field: "to_store_id", Message: "must differ from from_store_id"})
+	}
+
+	lines := make([]models.TransferLine, 0, len(req.Lines))
+	seen := map[primitive.ObjectID]bool{}
+	for i, line := range req.Lines {
+		field := fmt.Sprintf("lines[%d].product_id", i)
+		product, err := Repos.Products.FindByID(ctx, line.ProductID)
+		if errors.Is(err, repository.ErrNotFound) {
+			invalid = append(invalid, validate.FieldError{Field: field, Message: "is not a product"})
+			continue
+		}
+		if err != nil {
+			response.Internal(w, r, err)
+			return false
+		}
+		if seen[product.ID] {
+			invalid = append(invalid, validate.FieldError{Field: field, Message: "is already on another line"})
+			continue
+		}
+		seen[product.ID] = true
+		factor, _, ok := product.InUnit(line.Unit)
+		if !ok {
+			invalid = append(invalid, validate.FieldError{Field: fmt.Sprintf("lines[%d].unit", i), Message: unitMessage(product)})
+			continue
+		}
+		added := models.TransferLine{ProductID: product.ID, SKU: product.SKU, Name: product.Name}
+		added.SetQuantity(line.Quantity, line.Unit, factor)
+		lines = append(lines, added)
+	}
+	if len(invalid) > 0 {
+		response.Invalid(w, r, invalid)
+		return false
+	}
+
+	transfer.FromStoreID, transfer.ToStoreID, transfer.Lines, transfer.Note = req.FromStoreID, req.ToStoreID, lines, req.Note
+	transfer.Recalculate()
+	return true
+}
+
+func GetTransferHandler(w http.ResponseWriter, r *http.Request) {
+	id, ok := idParam(w, r, "id")
+	if !ok {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	transfer, err := Repos.Transfers.FindByID(ctx, id)
+	if err != nil {
+		repoError(w, r, err, "Transfer not found")
+		return
+	}
+	response.JSON(w, http.StatusOK, transfer)
+}
+
+// GetTransfersHandler lists transfers. status=dispatched with to_store_id
+// shows what is on its way to a store.
+func GetTransfersHandler(w http.ResponseWriter, r *http.Request) {
+	q, ok := listQuery(w, r, transferList)
+	if !ok {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	page, err := Repos.Transfers.List(ctx, q)
+	if err != nil {
+		listError(w, r, err)
+		return
+	}
+	writePage(w, r, q, page)
+}
+
+// UpdateTransferHandler replaces a draft's stores, lines and note.
+func UpdateTransferHandler(w http.ResponseWriter, r *http.Request) {
+	id, ok := idParam(w, r, "id")
+	if !ok {
+		return
+	}
+	var req TransferRequest
+	if !decode(w, r, &req) {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var edited models.Transfer
+	if !fillTransfer(ctx, w, r, &edited, &req) {
+		return
+	}
+	updateTransfer(ctx, w, r, id, func(transfer *models.Transfer) ([]models.StockMovement, error) {
+		if transfer.Status != models.TransferDraft {
+			return nil, statusError{transfer.Status}
+		}
+		transfer.FromStoreID, transfer.ToStoreID, transfer.Lines, transfer.Note = edited.FromStoreID, edited.ToStoreID, edited.Lines, edited.Note
+		transfer.Recalculate()
+		return nil, nil
+	})
+}
+
+// DispatchTransferHandler sends a draft on its way, taking its lines out of
+// the source store's stock. The lots the stock is taken from are recorded
+// on the lines.
+func DispatchTransferHandler(w http.ResponseWriter, r *http.Request) {
+	_, callerID, ok := callerFromRequest(w, r)
+	if !ok {
+		return
+	}
+	id, ok := idParam(w, r, "id")
+	if !ok {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	updateTransfer(ctx, w, r, id, func(transfer *models.Transfer) ([]models.StockMovement, error) {
+		if transfer.Status != models.TransferDraft {
+			return nil, statusError{transfer.Status}
+		}
+		now := time.Now().Unix()
+		transfer.Status = models.TransferDispatched
+		transfer.DispatchedBy, transfer.DispatchedAt = callerID, now
+		movements := make([]models.StockMovement, 0, len(transfer.Lines))
+		for i := range transfer.Lines {
+			line := &transfer.Lines[i]
+			line.Dispatched = line.Quantity
+			movements = append(movements, transferMovement(transfer, transfer.FromStoreID, line.ProductID, models.MoveTransferOut, -line.Dispatched, callerID, now))
+		}
+		transfer.Recalculate()
+		return movements, nil
+	})
+}
+
+// ReceiveTransferHandler records what arrived at the destination, puts it
+// into that store's stock and notes any discrepancy with what was
+// dispatched. Stock dispatched from a lot goes into the same lot at the
+// destination, keeping its number and expiry date.
+func ReceiveTransferHandler(w http.ResponseWriter, r *http.Request) {
+	_, callerID, ok := callerFromRequest(w, r)
+	if !ok {
+		return
+	}
+	id, ok := idParam(w, r, "id")
+	if !ok {
+		return
+	}
+	var req ReceiveTransferRequest
+	if !decode(w, r, &req) {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	updateTransfer(ctx, w, r, id, func(transfer *models.Transfer) ([]models.StockMovement, error) {
+		if transfer.Status != models.TransferDispatched {
+			return nil, statusError{transfer.Status}
+		}
+		for i := range transfer.Lines {
+			transfer.Lines[i].Received = transfer.Lines[i].Dispatched
+		}
+		var invalid validate.Errors
+		for i, count := range req.Lines {
+			line := transferLine(transfer, count.ProductID)
+			if line == nil {
+				invalid = append(invalid, validate.FieldError{Field: fmt.Sprintf("lines[%d].product_id", i), Message: "is not on this transfer"})
+				continue
+			}
+			line.Received, line.DiscrepancyNote = count.Quantity, count.Note
+		}
+		if len(invalid) > 0 {
+			return nil, invalid
+		}
+
+		now := time.Now().Unix()
+		transfer.Status = models.TransferReceived
+		transfer.ReceivedBy, transfer.ReceivedAt = callerID, now
+		var movements []models.StockMovement
+		for i := range transfer.Lines {
+			line := &transfer.Lines[i]
+			rest := line.ReceiveLots()
+			for _, lot := range line.Lots {
+				if lot.Received == 0 {
+					continue
+				}
+				mv := transferMovement(transfer, transfer.ToStoreID, line.ProductID, models.MoveTransferIn, lot.Received, callerID, now)
+				mv.Lot, mv.ExpiresAt, mv.OriginLotID = lot.Lot, lot.ExpiresAt, lot.LotID
+				movements = append(movements, mv)
+			}
+			if rest > 0 {
+				movements = append(movements, transferMovement(transfer, transfer.ToStoreID, line.ProductID, models.MoveTransferIn, rest, callerID, now))
+			}
+		}
+		transfer.Recalculate()
+		return movements, nil
+	})
+}
+
+// CancelTransferHandler cancels a transfer that hasn't been dispatched.
+func CancelTransferHandler(w http.ResponseWriter, r *http.Request) {
+	id, ok := idParam(w, r, "id")
+	if !ok {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	updateTransfer(ctx, w, r, id, func(transfer *models.Transfer) ([]models.StockMovement, error) {
+		if transfer.Status != models.TransferDraft {
+			return nil, statusError{transfer.Status}
+		}
+		transfer.Status = models.TransferCancelled
+		return nil, nil
+	})
+}
+
+func transferLine(transfer *models.Transfer, productID primitive.ObjectID) *models.TransferLine {
+	for i := range transfer.Lines {
+		if transfer.Lines[i].ProductID == productID {
+			return &transfer.Lines[i]
+		}
+	}
+	return nil
+}
+
+func transferMovement(transfer *models.Transfer, storeID, productID primitive.ObjectID, kind string, quantity int, actor primitive.ObjectID, at int64) models.StockMovement {
+	return models.StockMovement{
+		StoreID:   storeID,
+		ProductID: productID,
+		Kind:      kind,
+		Quantity:  quantity,
+		Source:    "transfer",
+		SourceID:  transfer.ID,
+		Actor:     actor,
+		CreatedAt: at,
+	}
+}
+
+func updateTransfer(ctx context.Context, w http.ResponseWriter, r *http.Request, id primitive.ObjectID, fn func(*models.Transfer) ([]models.StockMovement, error)) {
+	transfer, err := Repos.Transfers.Update(ctx, id, func(transfer *models.Transfer) ([]models.StockMovement, error) {
+		movements, err := fn(transfer)
+		transfer.UpdatedAt = time.Now().Unix()
+		return movements, err
+	})
+	writeUpdated(w, r, transfer, err, "transfer")
+}
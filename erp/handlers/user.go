@@ -0,0 +1,44 @@
+package handlers
+
+import (
+	"adonai-api/response"
+	"context"
+	"net/http"
+	"time"
+)
+
+// RoleRequest is the body of PUT /user/role.
+type RoleRequest struct {
+	Role      string `json:"role" validate:"required,oneof=customer vendor admin"`
+	TwoFactor bool   `json:"two_factor"` // vendors only: password login also needs an OTP
+}
+
+// SetUserRoleHandler gives a user a role. The user's sessions are revoked
+// because their access tokens carry the old role. PUT /user/role?id=
+func SetUserRoleHandler(w http.ResponseWriter, r *http.Request) {
+	caller, _, ok := callerFromRequest(w, r)
+	if !ok {
+		return
+	}
+	id, ok := idParam(w, r, "id")
+	if !ok {
+		return
+	}
+	var req RoleRequest
+	if !decode(w, r, &req) {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	user, err := Repos.Users.SetRole(ctx, id, req.Role, req.TwoFactor)
+	if err != nil {
+		repoError(w, r, err, "User not found")
+		return
+	}
+	if _, err := Repos.Sessions.RevokeByUser(ctx, id, "role changed by "+caller.Username, time.Now().Unix()); err != nil {
+		response.Internal(w, r, err)
+		return
+	}
+	response.JSON(w, http.StatusOK, user)
+}
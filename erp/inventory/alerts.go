@@ -0,0 +1,101 @@
+// Package inventory holds stock housekeeping that runs in the background
+// rather than in response to a request.
+package inventory
+
+import (
+	"adonai-api/models"
+	"adonai-api/notify"
+	"adonai-api/repository"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// CheckReorderPoints raises an alert for every product whose available
+// stock at a store is below its reorder point and has no open alert yet,
+// texting it to the store's phone number when it has one. Open alerts whose
+// stock has recovered are resolved. It returns how many alerts it raised.
+func CheckReorderPoints(ctx context.Context, repos *repository.Repositories, sms notify.Notifier) (int, error) {
+	now := time.Now().Unix()
+	low, err := repos.Stock.BelowReorderPoint(ctx)
+	if err != nil {
+		return 0, err
+	}
+	stillLow := map[[2]primitive.ObjectID]bool{}
+	raised := 0
+	for _, level := range low {
+		stillLow[[2]primitive.ObjectID{level.StoreID, level.ProductID}] = true
+
+		alert := models.StockAlert{
+			StoreID:      level.StoreID,
+			ProductID:    level.ProductID,
+			Available:    level.Available,
+			ReorderPoint: level.ReorderPoint,
+			RaisedAt:     now,
+		}
+		err := repos.Alerts.Raise(ctx, &alert)
+		if errors.Is(err, repository.ErrDuplicate) {
+			continue // already open
+		}
+		if err != nil {
+			return raised, err
+		}
+		raised++
+		if err := notifyStore(ctx, repos, sms, &alert); err != nil {
+			log.Printf("stock alert %s: %v", alert.ID.Hex(), err)
+		}
+	}
+
+	open, err := repos.Alerts.Open(ctx)
+	if err != nil {
+		return raised, err
+	}
+	for _, alert := range open {
+		if stillLow[[2]primitive.ObjectID{alert.StoreID, alert.ProductID}] {
+			continue
+		}
+		if err := repos.Alerts.Resolve(ctx, alert.ID, now); err != nil && !errors.Is(err, repository.ErrNotFound) {
+			return raised, err
+		}
+	}
+	return raised, nil
+}
+
+func notifyStore(ctx context.Context, repos *repository.Repositories, sms notify.Notifier, alert *models.StockAlert) error {
+	store, err := repos.Stores.FindByID(ctx, alert.StoreID)
+	if err != nil || store.PhoneNumber == "" {
+		return err
+	}
+	product, err := repos.Products.FindByID(ctx, alert.ProductID)
+	if err != nil {
+		return err
+	}
+	body := fmt.Sprintf("Low stock at %s: %s (%s) has %d available, reorder point %d",
+		store.Name, product.Name, product.SKU, alert.Available, alert.ReorderPoint)
+	if err := sms.Send(ctx, store.PhoneNumber, body); err != nil {
+		return err
+	}
+	return repos.Alerts.Notified(ctx, alert.ID, time.Now().Unix())
+}
+
+// RunAlerts checks reorder points every interval until ctx is cancelled.
+func RunAlerts(ctx context.Context, repos *repository.Repositories, sms notify.Notifier, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		checkCtx, cancel := context.WithTimeout(ctx, interval)
+		if _, err := CheckReorderPoints(checkCtx, repos, sms); err != nil {
+			log.Printf("checking reorder points: %v", err)
+		}
+		cancel()
+	}
+}
@@ -2,45 +2,200 @@ package main
 
 import (
 	"adonai-api/config"
+	"adonai-api/db"
 	"adonai-api/handlers"
+	"adonai-api/inventory"
 	"adonai-api/middleware"
+	"adonai-api/notify"
+	"adonai-api/repository"
+	"adonai-api/response"
+	"context"
+	"flag"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/gorilla/mux"
 )
 
 func main() {
-	config.InitEnv()
+	configPath := flag.String("config", os.Getenv("CONFIG_FILE"), "path to a YAML config file")
+	flag.Parse()
+
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	config.Current = cfg
+	log.Printf("Resolved configuration:\n%s", cfg)
+
 	config.ConnectDB()
+	indexCtx, cancelIndexes := context.WithTimeout(context.Background(), time.Minute)
+	if err := db.EnsureIndexes(indexCtx); err != nil {
+		log.Fatal(err)
+	}
+	cancelIndexes()
+	handlers.Repos = repository.NewMongo()
+
+	sms, err := notify.New(cfg.SMS)
+	if err != nil {
+		log.Fatal(err)
+	}
+	handlers.SMS = sms
+
+	srv := &http.Server{
+		Addr:              cfg.HTTP.Addr,
+		Handler:           newRouter(),
+		ReadTimeout:       cfg.HTTP.ReadTimeout,
+		ReadHeaderTimeout: cfg.HTTP.ReadHeaderTimeout,
+		WriteTimeout:      cfg.HTTP.WriteTimeout,
+		IdleTimeout:       cfg.HTTP.IdleTimeout,
+	}
+
+	stop, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	go inventory.RunAlerts(stop, handlers.Repos, sms, cfg.Stock.AlertInterval)
+
+	serveErr := make(chan error, 1)
+	go func() {
+		log.Println("Starting server on " + cfg.HTTP.Addr)
+		serveErr <- srv.ListenAndServe()
+	}()
+
+	select {
+	case err := <-serveErr:
+		if err != http.ErrServerClosed {
+			log.Fatal(err)
+		}
+	case <-stop.Done():
+		log.Println("Shutting down, draining in-flight requests")
+	}
+
+	ctx, cancelShutdown := context.WithTimeout(context.Background(), cfg.HTTP.ShutdownTimeout)
+	defer cancelShutdown()
+	if err := srv.Shutdown(ctx); err != nil {
+		log.Printf("HTTP shutdown: %v", err)
+	}
+	if err := config.DisconnectDB(ctx); err != nil {
+		log.Printf("MongoDB disconnect: %v", err)
+	}
+	log.Println("Server stopped")
+}
 
+// newRouter registers every route. Handlers read their storage and SMS
+// provider from handlers.Repos and handlers.SMS, which must be set first.
+func newRouter() *mux.Router {
 	r := mux.NewRouter()
+	r.Use(middleware.RequestID)
+	r.NotFoundHandler = middleware.RequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		response.NotFound(w, r, "Not found")
+	}))
+	r.MethodNotAllowedHandler = middleware.RequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		response.Error(w, r, http.StatusMethodNotAllowed, "method_not_allowed", "Method not allowed")
+	}))
+
+	// Probes
+	r.HandleFunc("/healthz", handlers.HealthzHandler).Methods("GET")
+	r.HandleFunc("/readyz", handlers.ReadyzHandler).Methods("GET")
 
 	// Authentication routes
 	r.HandleFunc("/signup", handlers.SignUpHandler).Methods("POST")
 	r.HandleFunc("/request-otp", handlers.RequestOTPHandler).Methods("POST")
 	r.HandleFunc("/verify-otp", handlers.VerifyOTPHandler).Methods("POST")
+	r.HandleFunc("/login", handlers.LoginHandler).Methods("POST")
+	r.HandleFunc("/refresh", handlers.RefreshHandler).Methods("POST")
+	r.Handle("/logout", middleware.JwtAuthMiddleware(http.HandlerFunc(handlers.LogoutHandler))).Methods("POST")
+
+	// User routes
+	r.Handle("/user/role", middleware.JwtAuthMiddleware(middleware.RoleMiddleware("admin")(http.HandlerFunc(handlers.SetUserRoleHandler)))).Methods("PUT")
+
+	// Session routes
+	r.Handle("/sessions", middleware.JwtAuthMiddleware(http.HandlerFunc(handlers.GetSessionsHandler))).Methods("GET")
+	r.Handle("/sessions", middleware.JwtAuthMiddleware(http.HandlerFunc(handlers.RevokeAllSessionsHandler))).Methods("DELETE")
+	r.Handle("/session", middleware.JwtAuthMiddleware(http.HandlerFunc(handlers.RevokeSessionHandler))).Methods("DELETE")
 
 	// Customer routes
-	r.Handle("/customers", middleware.JwtAuthMiddleware(http.HandlerFunc(handlers.GetCustomersHandler))).Methods("GET")
+	r.Handle("/customers", middleware.JwtAuthMiddleware(middleware.RoleMiddleware("vendor")(http.HandlerFunc(handlers.GetCustomersHandler)))).Methods("GET")
 	r.Handle("/customer", middleware.JwtAuthMiddleware(http.HandlerFunc(handlers.CreateCustomerHandler))).Methods("POST")
 	r.Handle("/customer", middleware.JwtAuthMiddleware(http.HandlerFunc(handlers.GetCustomerHandler))).Methods("GET")
-	r.Handle("/customer", middleware.JwtAuthMiddleware(http.HandlerFunc(handlers.UpdateCustomerHandler))).Methods("PUT")
-	r.Handle("/customer", middleware.JwtAuthMiddleware(http.HandlerFunc(handlers.DeleteCustomerHandler))).Methods("DELETE")
+	r.Handle("/customer", middleware.JwtAuthMiddleware(middleware.RoleMiddleware("vendor")(http.HandlerFunc(handlers.UpdateCustomerHandler)))).Methods("PUT")
+	r.Handle("/customer", middleware.JwtAuthMiddleware(middleware.RoleMiddleware("vendor")(http.HandlerFunc(handlers.DeleteCustomerHandler)))).Methods("DELETE")
 
 	// Store routes
 	r.Handle("/stores", middleware.JwtAuthMiddleware(http.HandlerFunc(handlers.GetStoresHandler))).Methods("GET")
-	r.Handle("/store", middleware.JwtAuthMiddleware(http.HandlerFunc(handlers.CreateStoreHandler))).Methods("POST")
+	r.Handle("/store", middleware.JwtAuthMiddleware(middleware.RoleMiddleware("vendor")(http.HandlerFunc(handlers.CreateStoreHandler)))).Methods("POST")
 	r.Handle("/store", middleware.JwtAuthMiddleware(http.HandlerFunc(handlers.GetStoreHandler))).Methods("GET")
-	r.Handle("/store", middleware.JwtAuthMiddleware(http.HandlerFunc(handlers.UpdateStoreHandler))).Methods("PUT")
-	r.Handle("/store", middleware.JwtAuthMiddleware(http.HandlerFunc(handlers.DeleteStoreHandler))).Methods("DELETE")
+	r.Handle("/store", middleware.JwtAuthMiddleware(middleware.RoleMiddleware("vendor")(http.HandlerFunc(handlers.UpdateStoreHandler)))).Methods("PUT")
+	r.Handle("/store", middleware.JwtAuthMiddleware(middleware.RoleMiddleware("vendor")(http.HandlerFunc(handlers.DeleteStoreHandler)))).Methods("DELETE")
+
+	// Product routes
+	r.Handle("/products", middleware.JwtAuthMiddleware(http.HandlerFunc(handlers.GetProductsHandler))).Methods("GET")
+	r.Handle("/product", middleware.JwtAuthMiddleware(http.HandlerFunc(handlers.GetProductHandler))).Methods("GET")
+	r.Handle("/product", middleware.JwtAuthMiddleware(middleware.RoleMiddleware("vendor")(http.HandlerFunc(handlers.CreateProductHandler)))).Methods("POST")
+	r.Handle("/product", middleware.JwtAuthMiddleware(middleware.RoleMiddleware("vendor")(http.HandlerFunc(handlers.UpdateProductHandler)))).Methods("PUT")
+	r.Handle("/product", middleware.JwtAuthMiddleware(middleware.RoleMiddleware("vendor")(http.HandlerFunc(handlers.DeleteProductHandler)))).Methods("DELETE")
+	r.Handle("/product/lookup", middleware.JwtAuthMiddleware(middleware.RoleMiddleware("vendor")(http.HandlerFunc(handlers.LookupProductHandler)))).Methods("GET")
+
+	// Stock routes
+	r.Handle("/stock", middleware.JwtAuthMiddleware(middleware.RoleMiddleware("vendor")(http.HandlerFunc(handlers.GetStockHandler)))).Methods("GET")
+	r.Handle("/stock/movements", middleware.JwtAuthMiddleware(middleware.RoleMiddleware("vendor")(http.HandlerFunc(handlers.GetMovementsHandler)))).Methods("GET")
+	r.Handle("/stock/movements", middleware.JwtAuthMiddleware(middleware.RoleMiddleware("vendor")(http.HandlerFunc(handlers.PostMovementHandler)))).Methods("POST")
+	r.Handle("/stock/lots", middleware.JwtAuthMiddleware(middleware.RoleMiddleware("vendor")(http.HandlerFunc(handlers.GetLotsHandler)))).Methods("GET")
+	r.Handle("/stock/lot", middleware.JwtAuthMiddleware(middleware.RoleMiddleware("vendor")(http.HandlerFunc(handlers.GetLotHandler)))).Methods("GET")
+	r.Handle("/stock/reorder-point", middleware.JwtAuthMiddleware(middleware.RoleMiddleware("vendor")(http.HandlerFunc(handlers.SetReorderPointHandler)))).Methods("PUT")
+	r.Handle("/stock/alerts", middleware.JwtAuthMiddleware(middleware.RoleMiddleware("vendor")(http.HandlerFunc(handlers.GetAlertsHandler)))).Methods("GET")
+	r.Handle("/stock/alert/acknowledge", middleware.JwtAuthMiddleware(middleware.RoleMiddleware("vendor")(http.HandlerFunc(handlers.AcknowledgeAlertHandler)))).Methods("POST")
+
+	// Transfer routes
+	r.Handle("/transfers", middleware.JwtAuthMiddleware(middleware.RoleMiddleware("vendor")(http.HandlerFunc(handlers.GetTransfersHandler)))).Methods("GET")
+	r.Handle("/transfer", middleware.JwtAuthMiddleware(middleware.RoleMiddleware("vendor")(http.HandlerFunc(handlers.CreateTransferHandler)))).Methods("POST")
+	r.Handle("/transfer", middleware.JwtAuthMiddleware(middleware.RoleMiddleware("vendor")(http.HandlerFunc(handlers.GetTransferHandler)))).Methods("GET")
+	r.Handle("/transfer", middleware.JwtAuthMiddleware(middleware.RoleMiddleware("vendor")(http.HandlerFunc(handlers.UpdateTransferHandler)))).Methods("PUT")
+	r.Handle("/transfer/dispatch", middleware.JwtAuthMiddleware(middleware.RoleMiddleware("vendor")(http.HandlerFunc(handlers.DispatchTransferHandler)))).Methods("POST")
+	r.Handle("/transfer/receive", middleware.JwtAuthMiddleware(middleware.RoleMiddleware("vendor")(http.HandlerFunc(handlers.ReceiveTransferHandler)))).Methods("POST")
+	r.Handle("/transfer/cancel", middleware.JwtAuthMiddleware(middleware.RoleMiddleware("vendor")(http.HandlerFunc(handlers.CancelTransferHandler)))).Methods("POST")
+
+	// Stocktake routes
+	r.Handle("/stocktakes", middleware.JwtAuthMiddleware(middleware.RoleMiddleware("vendor")(http.HandlerFunc(handlers.GetStocktakesHandler)))).Methods("GET")
+	r.Handle("/stocktake", middleware.JwtAuthMiddleware(middleware.RoleMiddleware("vendor")(http.HandlerFunc(handlers.OpenStocktakeHandler)))).Methods("POST")
+	r.Handle("/stocktake", middleware.JwtAuthMiddleware(middleware.RoleMiddleware("vendor")(http.HandlerFunc(handlers.GetStocktakeHandler)))).Methods("GET")
+	r.Handle("/stocktake/count", middleware.JwtAuthMiddleware(middleware.RoleMiddleware("vendor")(http.HandlerFunc(handlers.CountStocktakeHandler)))).Methods("POST")
+	r.Handle("/stocktake/approve", middleware.JwtAuthMiddleware(middleware.RoleMiddleware("vendor")(http.HandlerFunc(handlers.ApproveStocktakeHandler)))).Methods("POST")
+	r.Handle("/stocktake/cancel", middleware.JwtAuthMiddleware(middleware.RoleMiddleware("vendor")(http.HandlerFunc(handlers.CancelStocktakeHandler)))).Methods("POST")
+
+	// Purchasing routes
+	r.Handle("/suppliers", middleware.JwtAuthMiddleware(middleware.RoleMiddleware("vendor")(http.HandlerFunc(handlers.GetSuppliersHandler)))).Methods("GET")
+	r.Handle("/supplier", middleware.JwtAuthMiddleware(middleware.RoleMiddleware("vendor")(http.HandlerFunc(handlers.CreateSupplierHandler)))).Methods("POST")
+	r.Handle("/supplier", middleware.JwtAuthMiddleware(middleware.RoleMiddleware("vendor")(http.HandlerFunc(handlers.GetSupplierHandler)))).Methods("GET")
+	r.Handle("/supplier", middleware.JwtAuthMiddleware(middleware.RoleMiddleware("vendor")(http.HandlerFunc(handlers.UpdateSupplierHandler)))).Methods("PUT")
+	r.Handle("/supplier", middleware.JwtAuthMiddleware(middleware.RoleMiddleware("vendor")(http.HandlerFunc(handlers.DeleteSupplierHandler)))).Methods("DELETE")
+	r.Handle("/purchase-orders", middleware.JwtAuthMiddleware(middleware.RoleMiddleware("vendor")(http.HandlerFunc(handlers.GetPurchaseOrdersHandler)))).Methods("GET")
+	r.Handle("/purchase-order", middleware.JwtAuthMiddleware(middleware.RoleMiddleware("vendor")(http.HandlerFunc(handlers.CreatePurchaseOrderHandler)))).Methods("POST")
+	r.Handle("/purchase-order", middleware.JwtAuthMiddleware(middleware.RoleMiddleware("vendor")(http.HandlerFunc(handlers.GetPurchaseOrderHandler)))).Methods("GET")
+	r.Handle("/purchase-order", middleware.JwtAuthMiddleware(middleware.RoleMiddleware("vendor")(http.HandlerFunc(handlers.UpdatePurchaseOrderHandler)))).Methods("PUT")
+	r.Handle("/purchase-order/approve", middleware.JwtAuthMiddleware(middleware.RoleMiddleware("vendor")(http.HandlerFunc(handlers.ApprovePurchaseOrderHandler)))).Methods("POST")
+	r.Handle("/purchase-order/receive", middleware.JwtAuthMiddleware(middleware.RoleMiddleware("vendor")(http.HandlerFunc(handlers.ReceivePurchaseOrderHandler)))).Methods("POST")
+	r.Handle("/purchase-order/cancel", middleware.JwtAuthMiddleware(middleware.RoleMiddleware("vendor")(http.HandlerFunc(handlers.CancelPurchaseOrderHandler)))).Methods("POST")
 
 	// Order routes
 	r.Handle("/orders", middleware.JwtAuthMiddleware(http.HandlerFunc(handlers.GetUserOrdersHandler))).Methods("GET")
 	r.Handle("/order", middleware.JwtAuthMiddleware(http.HandlerFunc(handlers.CreateOrderHandler))).Methods("POST")
+	r.Handle("/order", middleware.JwtAuthMiddleware(http.HandlerFunc(handlers.GetOrderHandler))).Methods("GET")
+	r.Handle("/order/transition", middleware.JwtAuthMiddleware(http.HandlerFunc(handlers.TransitionOrderHandler))).Methods("POST")
+	r.Handle("/order/trace", middleware.JwtAuthMiddleware(middleware.RoleMiddleware("vendor")(http.HandlerFunc(handlers.TraceOrderHandler)))).Methods("GET")
 	r.Handle("/cancel-order", middleware.JwtAuthMiddleware(http.HandlerFunc(handlers.CancelOrderHandler))).Methods("PUT")
 	r.Handle("/all-orders", middleware.JwtAuthMiddleware(middleware.RoleMiddleware("vendor")(http.HandlerFunc(handlers.GetAllOrdersHandler)))).Methods("GET")
 
+	// Invoice routes
+	r.Handle("/invoices", middleware.JwtAuthMiddleware(middleware.RoleMiddleware("vendor")(http.HandlerFunc(handlers.GetInvoicesHandler)))).Methods("GET")
+	r.Handle("/invoice", middleware.JwtAuthMiddleware(middleware.RoleMiddleware("vendor")(http.HandlerFunc(handlers.IssueInvoiceHandler)))).Methods("POST")
+	r.Handle("/invoice", middleware.JwtAuthMiddleware(http.HandlerFunc(handlers.GetInvoiceHandler))).Methods("GET")
+	r.Handle("/invoice/pdf", middleware.JwtAuthMiddleware(http.HandlerFunc(handlers.GetInvoicePDFHandler))).Methods("GET")
+
 	// Chat routes
 	r.Handle("/send-message", middleware.JwtAuthMiddleware(http.HandlerFunc(handlers.SendMessageHandler))).Methods("POST")
 	r.Handle("/chat-history", middleware.JwtAuthMiddleware(http.HandlerFunc(handlers.GetChatHistoryHandler))).Methods("GET")
@@ -50,6 +205,5 @@ func main() {
 	r.Handle("/feeds", middleware.JwtAuthMiddleware(http.HandlerFunc(handlers.GetFeedsHandler))).Methods("GET")
 	r.Handle("/feed", middleware.JwtAuthMiddleware(http.HandlerFunc(handlers.CreateFeedHandler))).Methods("POST")
 
-	log.Println("Starting server on :8080")
-	http.ListenAndServe(":8080", r)
+	return r
 }
@@ -0,0 +1,1930 @@
+package main
+
+import (
+	"adonai-api/config"
+	"adonai-api/handlers"
+	"adonai-api/inventory"
+	"adonai-api/models"
+	"adonai-api/notify"
+	"adonai-api/repository"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// testEnv is an in-process server backed by in-memory storage and a
+// recording SMS provider.
+type testEnv struct {
+	t      *testing.T
+	server *httptest.Server
+	sms    *notify.Recorder
+}
+
+func newTestEnv(t *testing.T) *testEnv {
+	t.Helper()
+
+	cfg := config.Default()
+	cfg.SMS.Provider = "test"
+	cfg.Auth.OTPResendCooldown = 0
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	config.Current = cfg
+
+	sms := notify.NewRecorder()
+	handlers.Repos = repository.NewMemory()
+	handlers.SMS = sms
+
+	server := httptest.NewServer(newRouter())
+	t.Cleanup(server.Close)
+	return &testEnv{t: t, server: server, sms: sms}
+}
+
+// client is one browser: it keeps its own cookies.
+type client struct {
+	env  *testEnv
+	http *http.Client
+}
+
+func (e *testEnv) client() *client {
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		e.t.Fatal(err)
+	}
+	return &client{env: e, http: &http.Client{Jar: jar}}
+}
+
+type result struct {
+	status int
+	header http.Header
+	body   []byte
+}
+
+func (r result) decode(t *testing.T, v interface{}) {
+	t.Helper()
+	if err := json.Unmarshal(r.body, v); err != nil {
+		t.Fatalf("decoding %q: %v", r.body, err)
+	}
+}
+
+func (c *client) do(method, path string, body interface{}) result {
+	c.env.t.Helper()
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			c.env.t.Fatal(err)
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, c.env.server.URL+path, reader)
+	if err != nil {
+		c.env.t.Fatal(err)
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		c.env.t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(resp.Body)
+	return result{status: resp.StatusCode, header: resp.Header, body: data}
+}
+
+func (c *client) expect(method, path string, body interface{}, status int) result {
+	c.env.t.Helper()
+	resp := c.do(method, path, body)
+	if resp.status != status {
+		c.env.t.Fatalf("%s %s: got %d (%s), want %d", method, path, resp.status, strings.TrimSpace(string(resp.body)), status)
+	}
+	return resp
+}
+
+// signup registers a user and returns its ID. Customers sign up through
+// /signup; vendors and admins can't, so they are stored directly.
+func (e *testEnv) signup(username, password, role, phone string) string {
+	e.t.Helper()
+	if role != models.RoleCustomer {
+		return e.seedUser(models.User{Username: username, Password: password, Role: role, PhoneNumber: phone})
+	}
+	resp := e.client().expect("POST", "/signup", map[string]interface{}{
+		"username":     username,
+		"password":     password,
+		"phone_number": phone,
+	}, http.StatusCreated)
+	var created struct {
+		InsertedID string `json:"InsertedID"`
+	}
+	resp.decode(e.t, &created)
+	return created.InsertedID
+}
+
+// seedUser stores u with its password hashed and returns its ID.
+func (e *testEnv) seedUser(u models.User) string {
+	e.t.Helper()
+	hash, err := bcrypt.GenerateFromPassword([]byte(u.Password), bcrypt.MinCost)
+	if err != nil {
+		e.t.Fatal(err)
+	}
+	u.Password = string(hash)
+	if err := handlers.Repos.Users.Create(context.Background(), &u); err != nil {
+		e.t.Fatal(err)
+	}
+	return u.ID.Hex()
+}
+
+func (e *testEnv) lastOTP(phone string) string {
+	e.t.Helper()
+	msg, ok := e.sms.Last(phone)
+	if !ok {
+		e.t.Fatalf("no SMS sent to %s", phone)
+	}
+	return strings.TrimPrefix(msg.Body, "Your OTP is: ")
+}
+
+// loginWithOTP signs in through the SMS flow and returns the logged-in client.
+func (e *testEnv) loginWithOTP(phone string) *client {
+	e.t.Helper()
+	c := e.client()
+	c.expect("POST", "/request-otp", map[string]string{"phone_number": phone}, http.StatusOK)
+	c.expect("POST", "/verify-otp", map[string]string{"phone_number": phone, "otp": e.lastOTP(phone)}, http.StatusOK)
+	return c
+}
+
+func (e *testEnv) loginWithPassword(username, password string) *client {
+	e.t.Helper()
+	c := e.client()
+	c.expect("POST", "/login", map[string]string{"username": username, "password": password}, http.StatusOK)
+	return c
+}
+
+// product seeds an active catalog product and returns its ID.
+func (e *testEnv) product(sku, name, price string) string {
+	e.t.Helper()
+	amount, err := models.ParseMoney(price)
+	if err != nil {
+		e.t.Fatal(err)
+	}
+	p := &models.Product{SKU: sku, Name: name, Unit: "each", Price: amount, TaxCategory: "standard", Active: true}
+	if err := handlers.Repos.Products.Create(context.Background(), p); err != nil {
+		e.t.Fatal(err)
+	}
+	return p.ID.Hex()
+}
+
+type order struct {
+	ID          string `json:"id"`
+	UserID      string `json:"user_id"`
+	OrderStatus string `json:"order_status"`
+	Lines       []struct {
+		Name      string `json:"name"`
+		Quantity  int    `json:"quantity"`
+		UnitPrice string `json:"unit_price"`
+		Discount  string `json:"discount"`
+		Tax       string `json:"tax"`
+		Total     string `json:"total"`
+	} `json:"lines"`
+	Subtotal      string `json:"subtotal"`
+	DiscountTotal string `json:"discount_total"`
+	TaxTotal      string `json:"tax_total"`
+	GrandTotal    string `json:"grand_total"`
+	StatusHistory []struct {
+		From  string `json:"from"`
+		To    string `json:"to"`
+		Actor string `json:"actor"`
+		Role  string `json:"role"`
+		At    int64  `json:"at"`
+		Note  string `json:"note"`
+	} `json:"status_history"`
+}
+
+func TestOrderFlowEndToEnd(t *testing.T) {
+	env := newTestEnv(t)
+	customerID := env.signup("alice", "alice-pass", "customer", "+15550000001")
+	env.signup("vic", "vic-pass", "vendor", "+15550000002")
+
+	tea := env.product("TEA-1", "Tea", "2.50")
+	alice := env.loginWithOTP("+15550000001")
+
+	resp := alice.expect("POST", "/order", map[string]interface{}{"product_id": tea, "quantity": 3}, http.StatusCreated)
+	var created struct {
+		InsertedID string `json:"InsertedID"`
+	}
+	resp.decode(t, &created)
+
+	var orders []order
+	alice.expect("GET", "/orders", nil, http.StatusOK).decode(t, &orders)
+	if len(orders) != 1 || orders[0].UserID != customerID || orders[0].OrderStatus != "Placed" ||
+		len(orders[0].Lines) != 1 || orders[0].Lines[0].Name != "Tea" || orders[0].GrandTotal != "7.50" {
+		t.Fatalf("orders after create = %+v", orders)
+	}
+
+	alice.expect("PUT", "/cancel-order?order_id="+created.InsertedID, nil, http.StatusOK)
+
+	vic := env.loginWithPassword("vic", "vic-pass")
+	vic.expect("GET", "/all-orders", nil, http.StatusOK).decode(t, &orders)
+	if len(orders) != 1 || orders[0].ID != created.InsertedID || orders[0].OrderStatus != "Cancelled" {
+		t.Fatalf("vendor listing = %+v", orders)
+	}
+}
+
+func TestAuthFailures(t *testing.T) {
+	env := newTestEnv(t)
+	env.signup("alice", "alice-pass", "customer", "+15550000001")
+	anon := env.client()
+
+	anon.expect("GET", "/orders", nil, http.StatusUnauthorized)
+	anon.expect("POST", "/login", map[string]string{"username": "alice", "password": "wrong"}, http.StatusUnauthorized)
+	anon.expect("POST", "/login", map[string]string{"username": "nobody", "password": "x"}, http.StatusUnauthorized)
+	anon.expect("POST", "/request-otp", map[string]string{"phone_number": "+15559999999"}, http.StatusNotFound)
+
+	anon.expect("POST", "/request-otp", map[string]string{"phone_number": "+15550000001"}, http.StatusOK)
+	otp := env.lastOTP("+15550000001")
+	wrong := "000000"
+	if otp == wrong {
+		wrong = "111111"
+	}
+	anon.expect("POST", "/verify-otp", map[string]string{"phone_number": "+15550000001", "otp": wrong}, http.StatusUnauthorized)
+	anon.expect("GET", "/orders", nil, http.StatusUnauthorized)
+
+	// The right code still works, but only once.
+	anon.expect("POST", "/verify-otp", map[string]string{"phone_number": "+15550000001", "otp": otp}, http.StatusOK)
+	env.client().expect("POST", "/verify-otp", map[string]string{"phone_number": "+15550000001", "otp": otp}, http.StatusUnauthorized)
+}
+
+func TestAccessTokenErrors(t *testing.T) {
+	env := newTestEnv(t)
+	env.signup("alice", "alice-pass", "customer", "+15550000001")
+	u := env.server.URL + "/"
+
+	// Both session cookies are out of reach of page scripts and cover the
+	// whole API.
+	login := env.client().expect("POST", "/login", map[string]string{"username": "alice", "password": "alice-pass"}, http.StatusOK)
+	cookies := (&http.Response{Header: login.header}).Cookies()
+	if len(cookies) != 2 {
+		t.Fatalf("login cookies = %+v", cookies)
+	}
+	for _, cookie := range cookies {
+		if !cookie.HttpOnly || cookie.Path != "/" || cookie.SameSite != http.SameSiteStrictMode {
+			t.Fatalf("cookie %s = %+v", cookie.Name, cookie)
+		}
+	}
+
+	// An expired access token is a 401, so the client knows to refresh.
+	config.Current.Auth.AccessTokenTTL = -time.Minute
+	alice := env.loginWithPassword("alice", "alice-pass")
+	alice.expect("GET", "/orders", nil, http.StatusUnauthorized)
+	config.Current.Auth.AccessTokenTTL = config.Default().Auth.AccessTokenTTL
+	alice.expect("POST", "/refresh", nil, http.StatusOK)
+	alice.expect("GET", "/orders", nil, http.StatusOK)
+
+	// So is one signed with another key.
+	forged, err := jwt.NewWithClaims(jwt.SigningMethodHS256, &handlers.Claims{
+		UserID: "000000000000000000000000", Role: "admin",
+		StandardClaims: jwt.StandardClaims{ExpiresAt: time.Now().Add(time.Hour).Unix()},
+	}).SignedString([]byte("not the key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	setCookie(t, alice, u, "token", forged)
+	alice.expect("GET", "/orders", nil, http.StatusUnauthorized)
+
+	// Something that isn't a JWT at all is a bad request.
+	setCookie(t, alice, u, "token", "not-a-jwt")
+	alice.expect("GET", "/orders", nil, http.StatusBadRequest)
+}
+
+func TestOTPLockout(t *testing.T) {
+	env := newTestEnv(t)
+	env.signup("alice", "alice-pass", "customer", "+15550000001")
+	c := env.client()
+
+	c.expect("POST", "/request-otp", map[string]string{"phone_number": "+15550000001"}, http.StatusOK)
+	otp := env.lastOTP("+15550000001")
+	wrong := "000000"
+	if otp == wrong {
+		wrong = "111111"
+	}
+	for i := 0; i < config.Current.Auth.OTPMaxPerPhone; i++ {
+		c.expect("POST", "/verify-otp", map[string]string{"phone_number": "+15550000001", "otp": wrong}, http.StatusUnauthorized)
+	}
+	c.expect("POST", "/verify-otp", map[string]string{"phone_number": "+15550000001", "otp": otp}, http.StatusTooManyRequests)
+}
+
+func TestPasswordLockout(t *testing.T) {
+	env := newTestEnv(t)
+	env.signup("alice", "alice-pass", "customer", "+15550000001")
+	env.signup("bob", "bob-pass", "customer", "+15550000003")
+	c := env.client()
+
+	login := func(username, password string, status int) {
+		t.Helper()
+		c.expect("POST", "/login", map[string]string{"username": username, "password": password}, status)
+	}
+	login("alice", "alice-pass", http.StatusOK)
+	for i := 0; i < config.Current.Auth.LoginMaxPerUser; i++ {
+		login("alice", "guess", http.StatusUnauthorized)
+	}
+	login("alice", "alice-pass", http.StatusTooManyRequests)
+	login("bob", "bob-pass", http.StatusOK)
+
+	// Guessing across usernames runs into the per-IP limit.
+	config.Current.Auth.LoginMaxPerIP = config.Current.Auth.LoginMaxPerUser + 1
+	login("nobody", "guess", http.StatusUnauthorized)
+	login("bob", "bob-pass", http.StatusTooManyRequests)
+}
+
+func TestOTPConcurrentFailures(t *testing.T) {
+	env := newTestEnv(t)
+	env.signup("alice", "alice-pass", "customer", "+15550000001")
+	env.client().expect("POST", "/request-otp", map[string]string{"phone_number": "+15550000001"}, http.StatusOK)
+	wrong := "000000"
+	if env.lastOTP("+15550000001") == wrong {
+		wrong = "111111"
+	}
+
+	// Every wrong guess that got past the lockout check is counted, however
+	// they interleave.
+	var wg sync.WaitGroup
+	var failed atomic.Int64
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if env.client().do("POST", "/verify-otp", map[string]string{"phone_number": "+15550000001", "otp": wrong}).status == http.StatusUnauthorized {
+				failed.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+	counters, _ := handlers.Repos.Attempts.FindMany(context.Background(), "otp:phone:+15550000001")
+	if len(counters) != 1 || int64(counters[0].Count) != failed.Load() || counters[0].LockedUntil == 0 {
+		t.Fatalf("counters = %+v after %d failures", counters, failed.Load())
+	}
+}
+
+func TestOTPLockoutByForwardedIP(t *testing.T) {
+	env := newTestEnv(t)
+	config.Current.Auth.OTPMaxPerIP = 3
+	verify := func(forwardedFor string) int {
+		t.Helper()
+		req, _ := http.NewRequest("POST", env.server.URL+"/verify-otp", strings.NewReader(`{"phone_number": "+15559999999", "otp": "000000"}`))
+		req.Header.Set("X-Forwarded-For", forwardedFor)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	// Without a trusted proxy the header is ignored, so rotating it doesn't
+	// buy a fresh lockout bucket.
+	for i := 0; i < 3; i++ {
+		if status := verify(fmt.Sprintf("203.0.113.%d", i)); status != http.StatusNotFound {
+			t.Fatalf("guess %d: got %d", i, status)
+		}
+	}
+	if status := verify("203.0.113.99"); status != http.StatusTooManyRequests {
+		t.Fatalf("after lockout: got %d", status)
+	}
+
+	// Behind a trusted proxy the right-most untrusted address counts, and
+	// whatever the client prepended is ignored.
+	cfg := config.Default()
+	cfg.SMS.Provider = "test"
+	cfg.Auth.OTPMaxPerIP = 3
+	cfg.HTTP.TrustedProxies = []string{"127.0.0.0/8", "10.0.0.1"}
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	config.Current = cfg
+	for i := 0; i < 3; i++ {
+		if status := verify(fmt.Sprintf("198.51.100.%d, 192.0.2.7, 10.0.0.1", i)); status != http.StatusNotFound {
+			t.Fatalf("proxied guess %d: got %d", i, status)
+		}
+	}
+	if status := verify("192.0.2.7"); status != http.StatusTooManyRequests {
+		t.Fatalf("proxied client after lockout: got %d", status)
+	}
+	if status := verify("192.0.2.8"); status != http.StatusNotFound {
+		t.Fatalf("another proxied client: got %d", status)
+	}
+}
+
+func TestResendCooldown(t *testing.T) {
+	env := newTestEnv(t)
+	config.Current.Auth.OTPResendCooldown = config.Default().Auth.OTPResendCooldown
+	env.signup("alice", "alice-pass", "customer", "+15550000001")
+	c := env.client()
+
+	c.expect("POST", "/request-otp", map[string]string{"phone_number": "+15550000001"}, http.StatusOK)
+	c.expect("POST", "/request-otp", map[string]string{"phone_number": "+15550000001"}, http.StatusTooManyRequests)
+
+	// Concurrent requests send one code between them.
+	env.signup("bob", "bob-pass", "customer", "+15550000003")
+	env.sms.Reset()
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			env.client().do("POST", "/request-otp", map[string]string{"phone_number": "+15550000003"})
+		}()
+	}
+	wg.Wait()
+	if n := len(env.sms.Messages()); n != 1 {
+		t.Fatalf("concurrent resends sent %d codes, want 1", n)
+	}
+}
+
+// failingAttempts is an attempt store that is down.
+type failingAttempts struct{ repository.AttemptRepository }
+
+func (failingAttempts) FindMany(ctx context.Context, keys ...string) ([]models.AttemptCounter, error) {
+	return nil, errors.New("attempt store unavailable")
+}
+
+func TestLockoutFailsClosed(t *testing.T) {
+	env := newTestEnv(t)
+	env.signup("alice", "alice-pass", "customer", "+15550000001")
+	handlers.Repos.Attempts = failingAttempts{handlers.Repos.Attempts}
+	c := env.client()
+
+	c.expect("POST", "/login", map[string]string{"username": "alice", "password": "alice-pass"}, http.StatusInternalServerError)
+	c.expect("POST", "/request-otp", map[string]string{"phone_number": "+15550000001"}, http.StatusOK)
+	c.expect("POST", "/verify-otp", map[string]string{"phone_number": "+15550000001", "otp": env.lastOTP("+15550000001")}, http.StatusInternalServerError)
+}
+
+func TestRoleChecks(t *testing.T) {
+	env := newTestEnv(t)
+	aliceID := env.signup("alice", "alice-pass", "customer", "+15550000001")
+	env.signup("bob", "bob-pass", "customer", "+15550000003")
+	env.signup("vic", "vic-pass", "vendor", "+15550000002")
+
+	alice := env.loginWithPassword("alice", "alice-pass")
+	bob := env.loginWithPassword("bob", "bob-pass")
+	vic := env.loginWithPassword("vic", "vic-pass")
+
+	alice.expect("GET", "/all-orders", nil, http.StatusForbidden)
+	alice.expect("POST", "/broadcast", map[string]string{"content": "hi"}, http.StatusForbidden)
+	vic.expect("POST", "/broadcast", map[string]string{"content": "hi"}, http.StatusOK)
+
+	var created struct {
+		InsertedID string `json:"InsertedID"`
+	}
+	alice.expect("POST", "/order", map[string]interface{}{"product_id": env.product("TEA-1", "Tea", "2.50"), "quantity": 1}, http.StatusCreated).decode(t, &created)
+
+	// Customers can't read or cancel someone else's orders, even by asking.
+	var orders []order
+	bob.expect("GET", "/orders?user_id="+aliceID, nil, http.StatusOK).decode(t, &orders)
+	if len(orders) != 0 {
+		t.Fatalf("bob sees alice's orders: %+v", orders)
+	}
+	bob.expect("PUT", "/cancel-order?order_id="+created.InsertedID, nil, http.StatusNotFound)
+
+	// Vendors can.
+	vic.expect("GET", "/orders?user_id="+aliceID, nil, http.StatusOK).decode(t, &orders)
+	if len(orders) != 1 {
+		t.Fatalf("vendor view of alice's orders = %+v", orders)
+	}
+
+	// Customers only reach their own customer record and can't touch stores.
+	var aliceRecord, vicRecord, store struct {
+		InsertedID string `json:"InsertedID"`
+	}
+	alice.expect("POST", "/customer", map[string]string{"user_id": "000000000000000000000001", "first_name": "Alice", "last_name": "Adams"}, http.StatusCreated).decode(t, &aliceRecord)
+	var record struct {
+		UserID string `json:"user_id"`
+	}
+	alice.expect("GET", "/customer?id="+aliceRecord.InsertedID, nil, http.StatusOK).decode(t, &record)
+	if record.UserID != aliceID {
+		t.Fatalf("alice's customer record belongs to %q", record.UserID)
+	}
+	vic.expect("POST", "/customer", map[string]string{"first_name": "Walk", "last_name": "In"}, http.StatusCreated).decode(t, &vicRecord)
+	bob.expect("GET", "/customer?id="+aliceRecord.InsertedID, nil, http.StatusNotFound)
+	bob.expect("GET", "/customers", nil, http.StatusForbidden)
+	bob.expect("PUT", "/customer?id="+aliceRecord.InsertedID, map[string]string{"first_name": "B", "last_name": "B"}, http.StatusForbidden)
+	bob.expect("DELETE", "/customer?id="+vicRecord.InsertedID, nil, http.StatusForbidden)
+	vic.expect("GET", "/customer?id="+aliceRecord.InsertedID, nil, http.StatusOK)
+	vic.expect("GET", "/customers", nil, http.StatusOK)
+
+	bob.expect("POST", "/store", map[string]string{"name": "Rogue"}, http.StatusForbidden)
+	vic.expect("POST", "/store", map[string]string{"name": "Downtown"}, http.StatusCreated).decode(t, &store)
+	bob.expect("GET", "/store?id="+store.InsertedID, nil, http.StatusOK)
+	bob.expect("PUT", "/store?id="+store.InsertedID, map[string]string{"name": "Mine"}, http.StatusForbidden)
+	bob.expect("DELETE", "/store?id="+store.InsertedID, nil, http.StatusForbidden)
+}
+
+func TestSignupRole(t *testing.T) {
+	env := newTestEnv(t)
+	env.signup("root", "root-pass", "admin", "+15550000009")
+
+	// The role in a signup body is ignored: everyone signs up as a customer.
+	var created struct {
+		InsertedID string `json:"InsertedID"`
+	}
+	env.client().expect("POST", "/signup", map[string]interface{}{
+		"username": "mallory", "password": "mallory-pass", "role": "admin", "phone_number": "+15550000004",
+	}, http.StatusCreated).decode(t, &created)
+	mallory := env.loginWithPassword("mallory", "mallory-pass")
+
+	// Usernames and phone numbers each name one account.
+	env.client().expect("POST", "/signup", map[string]interface{}{
+		"username": "mallory", "password": "other-pass", "phone_number": "+15550000005",
+	}, http.StatusConflict)
+	env.client().expect("POST", "/signup", map[string]interface{}{
+		"username": "eve", "password": "other-pass", "phone_number": "+15550000004",
+	}, http.StatusConflict)
+	mallory.expect("GET", "/all-orders", nil, http.StatusForbidden)
+	mallory.expect("PUT", "/user/role?id="+created.InsertedID, map[string]string{"role": "admin"}, http.StatusForbidden)
+
+	root := env.loginWithPassword("root", "root-pass")
+	root.expect("PUT", "/user/role?id="+created.InsertedID, map[string]string{"role": "owner"}, http.StatusUnprocessableEntity)
+	var user struct {
+		Role     string `json:"role"`
+		Password string `json:"password"`
+	}
+	root.expect("PUT", "/user/role?id="+created.InsertedID, map[string]string{"role": "vendor"}, http.StatusOK).decode(t, &user)
+	if user.Role != "vendor" || user.Password != "" {
+		t.Fatalf("updated user = %+v", user)
+	}
+
+	// The old session carried the customer role, so it is signed out.
+	mallory.expect("GET", "/orders", nil, http.StatusUnauthorized)
+	env.loginWithPassword("mallory", "mallory-pass").expect("GET", "/all-orders", nil, http.StatusOK)
+}
+
+func TestVendorTwoFactorLogin(t *testing.T) {
+	env := newTestEnv(t)
+	env.seedUser(models.User{Username: "vic", Password: "vic-pass", Role: "vendor", PhoneNumber: "+15550000002", TwoFactor: true})
+
+	c := env.client()
+	c.expect("POST", "/login", map[string]string{"username": "vic", "password": "vic-pass"}, http.StatusAccepted)
+	c.expect("GET", "/all-orders", nil, http.StatusUnauthorized)
+	c.expect("POST", "/login", map[string]string{"username": "vic", "password": "vic-pass", "otp": env.lastOTP("+15550000002")}, http.StatusOK)
+	c.expect("GET", "/all-orders", nil, http.StatusOK)
+}
+
+func TestRefreshRotationAndReuse(t *testing.T) {
+	env := newTestEnv(t)
+	env.signup("alice", "alice-pass", "customer", "+15550000001")
+	alice := env.loginWithPassword("alice", "alice-pass")
+
+	u := env.server.URL + "/"
+	first := cookieValue(t, alice, u, "refresh_token")
+
+	alice.expect("POST", "/refresh", nil, http.StatusOK)
+	if cookieValue(t, alice, u, "refresh_token") == first {
+		t.Fatal("refresh token was not rotated")
+	}
+	alice.expect("GET", "/orders", nil, http.StatusOK)
+
+	// Replaying the first token kills the whole session.
+	thief := env.client()
+	setCookie(t, thief, u, "refresh_token", first)
+	thief.expect("POST", "/refresh", nil, http.StatusUnauthorized)
+	alice.expect("GET", "/orders", nil, http.StatusUnauthorized)
+	alice.expect("POST", "/refresh", nil, http.StatusUnauthorized)
+}
+
+func TestLogoutAndSessionRevocation(t *testing.T) {
+	env := newTestEnv(t)
+	aliceID := env.signup("alice", "alice-pass", "customer", "+15550000001")
+	env.signup("root", "root-pass", "admin", "+15550000009")
+
+	phone := env.loginWithPassword("alice", "alice-pass")
+	laptop := env.loginWithPassword("alice", "alice-pass")
+
+	var sessions []struct {
+		ID        string `json:"id"`
+		RevokedAt int64  `json:"revoked_at"`
+	}
+	laptop.expect("GET", "/sessions", nil, http.StatusOK).decode(t, &sessions)
+	if len(sessions) != 2 {
+		t.Fatalf("sessions = %+v", sessions)
+	}
+
+	phone.expect("POST", "/logout", nil, http.StatusOK)
+	phone.expect("GET", "/orders", nil, http.StatusUnauthorized)
+	laptop.expect("GET", "/orders", nil, http.StatusOK)
+
+	// Only admins may look at or revoke other users' sessions.
+	env.signup("bob", "bob-pass", "customer", "+15550000003")
+	bob := env.loginWithPassword("bob", "bob-pass")
+	bob.expect("GET", "/sessions?user_id="+aliceID, nil, http.StatusForbidden)
+
+	root := env.loginWithPassword("root", "root-pass")
+	root.expect("DELETE", "/sessions?user_id="+aliceID, nil, http.StatusOK)
+	laptop.expect("GET", "/orders", nil, http.StatusUnauthorized)
+}
+
+func cookieValue(t *testing.T, c *client, rawURL, name string) string {
+	t.Helper()
+	req, _ := http.NewRequest("GET", rawURL, nil)
+	for _, cookie := range c.http.Jar.Cookies(req.URL) {
+		if cookie.Name == name {
+			return cookie.Value
+		}
+	}
+	t.Fatalf("no %s cookie", name)
+	return ""
+}
+
+func setCookie(t *testing.T, c *client, rawURL, name, value string) {
+	t.Helper()
+	req, _ := http.NewRequest("GET", rawURL, nil)
+	c.http.Jar.SetCookies(req.URL, []*http.Cookie{{Name: name, Value: value, Path: "/"}})
+}
+
+func TestHealthProbes(t *testing.T) {
+	env := newTestEnv(t)
+	anon := env.client()
+	anon.expect("GET", "/healthz", nil, http.StatusOK)
+	anon.expect("GET", "/readyz", nil, http.StatusOK)
+}
+
+type errorEnvelope struct {
+	Error struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+		Fields  []struct {
+			Field   string `json:"field"`
+			Message string `json:"message"`
+		} `json:"fields"`
+		RequestID string `json:"request_id"`
+	} `json:"error"`
+}
+
+func TestValidationErrors(t *testing.T) {
+	env := newTestEnv(t)
+	env.signup("alice", "alice-pass", "customer", "+15550000001")
+	alice := env.loginWithOTP("+15550000001")
+
+	var body errorEnvelope
+	alice.expect("POST", "/order", map[string]interface{}{"quantity": 0}, http.StatusUnprocessableEntity).decode(t, &body)
+	if body.Error.Code != "validation_failed" || len(body.Error.Fields) != 1 || body.Error.Fields[0].Field != "lines" {
+		t.Fatalf("order validation = %+v", body)
+	}
+	if body.Error.RequestID == "" {
+		t.Fatal("error body has no request_id")
+	}
+
+	body = errorEnvelope{}
+	env.client().expect("POST", "/signup", map[string]string{
+		"username": "bob", "password": "bob-pass", "phone_number": "555-0100",
+	}, http.StatusUnprocessableEntity).decode(t, &body)
+	if len(body.Error.Fields) != 1 || body.Error.Fields[0].Field != "phone_number" {
+		t.Fatalf("signup validation = %+v", body)
+	}
+
+	body = errorEnvelope{}
+	alice.expect("POST", "/customer", "not an object", http.StatusBadRequest).decode(t, &body)
+	if body.Error.Code != "bad_request" {
+		t.Fatalf("malformed body = %+v", body)
+	}
+	body = errorEnvelope{}
+	alice.expect("POST", "/customer", map[string]int{"first_name": 7}, http.StatusBadRequest).decode(t, &body)
+	if body.Error.Message != "Invalid request body" {
+		t.Fatalf("mistyped body = %+v", body)
+	}
+
+	// A well-formed incoming request ID is echoed back and reported in errors.
+	req, _ := http.NewRequest("GET", env.server.URL+"/orders", nil)
+	req.Header.Set("X-Request-ID", "trace-123")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body = errorEnvelope{}
+	json.NewDecoder(resp.Body).Decode(&body)
+	if resp.Header.Get("X-Request-ID") != "trace-123" || body.Error.RequestID != "trace-123" || body.Error.Code != "unauthorized" {
+		t.Fatalf("request id = %q, body = %+v", resp.Header.Get("X-Request-ID"), body)
+	}
+}
+
+func TestResourceStatusCodes(t *testing.T) {
+	env := newTestEnv(t)
+	env.signup("vic", "vic-pass", "vendor", "+15550000002")
+	vic := env.loginWithPassword("vic", "vic-pass")
+
+	resp, err := vic.http.Post(env.server.URL+"/customer", "application/json", strings.NewReader(`{"first_name":"Ada","last_name":"Lovelace"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	location := resp.Header.Get("Location")
+	if resp.StatusCode != http.StatusCreated || !strings.HasPrefix(location, "/customer?id=") {
+		t.Fatalf("create customer: %d, Location %q", resp.StatusCode, location)
+	}
+
+	var customer struct {
+		ID        string `json:"id"`
+		FirstName string `json:"first_name"`
+		LastName  string `json:"last_name"`
+	}
+	vic.expect("PUT", location, map[string]string{"first_name": "Ada", "last_name": "King"}, http.StatusOK).decode(t, &customer)
+	if customer.LastName != "King" || "/customer?id="+customer.ID != location {
+		t.Fatalf("updated customer = %+v", customer)
+	}
+
+	missing := "/customer?id=000000000000000000000000"
+	vic.expect("PUT", missing, map[string]string{"first_name": "A", "last_name": "B"}, http.StatusNotFound)
+	vic.expect("DELETE", missing, nil, http.StatusNotFound)
+	vic.expect("DELETE", "/store?id=000000000000000000000000", nil, http.StatusNotFound)
+	vic.expect("GET", "/customer?id=not-hex", nil, http.StatusBadRequest)
+	vic.expect("DELETE", "/customer?id=", nil, http.StatusBadRequest)
+	vic.expect("PUT", "/cancel-order?order_id=zzz", nil, http.StatusBadRequest)
+	vic.expect("PUT", "/cancel-order?order_id=000000000000000000000000", nil, http.StatusNotFound)
+
+	if body := vic.expect("DELETE", location, nil, http.StatusNoContent).body; len(body) != 0 {
+		t.Fatalf("delete body = %q", body)
+	}
+	vic.expect("GET", location, nil, http.StatusNotFound)
+}
+
+func TestOrderPagination(t *testing.T) {
+	env := newTestEnv(t)
+	env.signup("alice", "alice-pass", "customer", "+15550000001")
+	env.signup("vic", "vic-pass", "vendor", "+15550000002")
+	alice := env.loginWithOTP("+15550000001")
+
+	tea := env.product("TEA-1", "Tea", "2.50")
+	var created []string
+	for i := 1; i <= 5; i++ {
+		var out struct {
+			InsertedID string `json:"InsertedID"`
+		}
+		alice.expect("POST", "/order", map[string]interface{}{"product_id": tea, "quantity": i}, http.StatusCreated).decode(t, &out)
+		created = append(created, out.InsertedID)
+	}
+	alice.expect("PUT", "/cancel-order?order_id="+created[0], nil, http.StatusOK)
+
+	vic := env.loginWithPassword("vic", "vic-pass")
+
+	// Walk every page by total, following the cursor.
+	var quantities []int
+	path := "/all-orders?sort=grand_total&limit=2&include_total=true"
+	for pages := 0; path != ""; pages++ {
+		if pages > 5 {
+			t.Fatal("cursor never ran out")
+		}
+		var orders []order
+		resp := vic.expect("GET", path, nil, http.StatusOK)
+		resp.decode(t, &orders)
+		if total := resp.header.Get("X-Total-Count"); total != "5" {
+			t.Fatalf("X-Total-Count = %q", total)
+		}
+		for _, o := range orders {
+			quantities = append(quantities, o.Lines[0].Quantity)
+		}
+		path = ""
+		if next := resp.header.Get("X-Next-Cursor"); next != "" {
+			path = "/all-orders?sort=grand_total&limit=2&include_total=true&cursor=" + next
+		}
+	}
+	if fmt.Sprint(quantities) != "[1 2 3 4 5]" {
+		t.Fatalf("paged quantities = %v", quantities)
+	}
+
+	var orders []order
+	alice.expect("GET", "/orders?order_status=Placed&sort=-grand_total", nil, http.StatusOK).decode(t, &orders)
+	if len(orders) != 4 || orders[0].Lines[0].Quantity != 5 || orders[3].Lines[0].Quantity != 2 {
+		t.Fatalf("filtered orders = %+v", orders)
+	}
+	alice.expect("GET", "/orders?created_to=2000-01-01", nil, http.StatusOK).decode(t, &orders)
+	if len(orders) != 0 {
+		t.Fatalf("orders created before 2000 = %+v", orders)
+	}
+
+	alice.expect("GET", "/orders?limit=0", nil, http.StatusBadRequest)
+	alice.expect("GET", "/orders?sort=password", nil, http.StatusBadRequest)
+	alice.expect("GET", "/orders?cursor=bogus", nil, http.StatusBadRequest)
+	alice.expect("GET", "/orders?created_from=yesterday", nil, http.StatusBadRequest)
+}
+
+// TestPagingPastMissingSortField pages through lots sorted by expiry when
+// some have none. Missing values sort first, as MongoDB sorts them, and a
+// page can end on one.
+func TestPagingPastMissingSortField(t *testing.T) {
+	newTestEnv(t)
+	ctx := context.Background()
+	store, product := primitive.NewObjectID(), primitive.NewObjectID()
+	for i, expires := range []int64{0, 2000, 0, 1000, 0} {
+		err := handlers.Repos.Stock.Post(ctx, []models.StockMovement{{
+			StoreID: store, ProductID: product, Kind: models.MoveReceipt, Quantity: 1,
+			Lot: fmt.Sprintf("L%d", i), ExpiresAt: expires, CreatedAt: int64(i),
+		}})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for sort, want := range map[string][]int64{
+		"expires_at":  {0, 0, 0, 1000, 2000},
+		"-expires_at": {2000, 1000, 0, 0, 0},
+	} {
+		var got []int64
+		seen := map[string]bool{}
+		q := repository.Query{Sort: sort, Limit: 2}
+		for {
+			page, err := handlers.Repos.Stock.Lots(ctx, q)
+			if err != nil {
+				t.Fatalf("%s: %v", sort, err)
+			}
+			for _, lot := range page.Items {
+				if seen[lot.Lot] {
+					t.Fatalf("%s: lot %s listed twice", sort, lot.Lot)
+				}
+				seen[lot.Lot] = true
+				got = append(got, lot.ExpiresAt)
+			}
+			if page.NextCursor == "" {
+				break
+			}
+			q.Cursor = page.NextCursor
+		}
+		if fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Fatalf("%s: expiries %v, want %v", sort, got, want)
+		}
+	}
+}
+
+func TestProductCatalog(t *testing.T) {
+	env := newTestEnv(t)
+	env.signup("alice", "alice-pass", "customer", "+15550000001")
+	env.signup("vic", "vic-pass", "vendor", "+15550000002")
+	alice := env.loginWithOTP("+15550000001")
+	vic := env.loginWithPassword("vic", "vic-pass")
+
+	var store struct {
+		InsertedID string `json:"InsertedID"`
+	}
+	vic.expect("POST", "/store", map[string]string{"name": "Downtown"}, http.StatusCreated).decode(t, &store)
+
+	coffee := map[string]interface{}{
+		"sku": "COF-250", "name": "Coffee beans", "unit": "250g bag", "price": "7.95",
+		"tax_category": "standard", "active": true,
+	}
+	alice.expect("POST", "/product", coffee, http.StatusForbidden)
+	resp := vic.expect("POST", "/product", coffee, http.StatusCreated)
+	location := resp.header.Get("Location")
+	vic.expect("POST", "/product", coffee, http.StatusConflict)
+	vic.expect("POST", "/product", map[string]interface{}{"sku": "X", "name": "X", "unit": "each", "tax_category": "standard", "price": "1.999"}, http.StatusBadRequest)
+
+	var product struct {
+		ID    string `json:"id"`
+		Price string `json:"price"`
+	}
+	alice.expect("GET", location, nil, http.StatusOK).decode(t, &product)
+	if product.Price != "7.95" {
+		t.Fatalf("price = %q, want 7.95", product.Price)
+	}
+
+	// Restricting the product to another store makes it unorderable here.
+	coffee["price"] = 8.5
+	coffee["availability"] = []map[string]interface{}{{"store_id": "000000000000000000000001", "available": true}}
+	vic.expect("PUT", location, coffee, http.StatusOK).decode(t, &product)
+	if product.Price != "8.50" {
+		t.Fatalf("updated price = %q, want 8.50", product.Price)
+	}
+	order := map[string]interface{}{"product_id": product.ID, "quantity": 1, "store_id": store.InsertedID}
+	alice.expect("POST", "/order", order, http.StatusUnprocessableEntity)
+
+	coffee["availability"] = []map[string]interface{}{{"store_id": store.InsertedID, "available": true}}
+	vic.expect("PUT", location, coffee, http.StatusOK)
+	vic.expect("POST", "/stock/movements", map[string]interface{}{
+		"store_id": store.InsertedID, "product_id": product.ID, "kind": "receipt", "quantity": 5,
+	}, http.StatusCreated)
+	alice.expect("POST", "/order", order, http.StatusCreated)
+
+	var products []struct {
+		SKU string `json:"sku"`
+	}
+	alice.expect("GET", "/products?active=true", nil, http.StatusOK).decode(t, &products)
+	if len(products) != 1 || products[0].SKU != "COF-250" {
+		t.Fatalf("active products = %+v", products)
+	}
+	alice.expect("POST", "/order", map[string]interface{}{"product_id": "000000000000000000000000", "quantity": 1}, http.StatusUnprocessableEntity)
+}
+
+func TestOrderLinesAndTotals(t *testing.T) {
+	env := newTestEnv(t)
+	config.Current.Tax.Rates = map[string]string{"standard": "20", "reduced": "5"}
+	env.signup("alice", "alice-pass", "customer", "+15550000001")
+	env.signup("vic", "vic-pass", "vendor", "+15550000002")
+	alice := env.loginWithOTP("+15550000001")
+	vic := env.loginWithPassword("vic", "vic-pass")
+
+	tea := env.product("TEA-1", "Tea", "2.49")
+	bread := env.product("BRD-1", "Bread", "3.33")
+	vic.expect("PUT", "/product?id="+bread, map[string]interface{}{
+		"sku": "BRD-1", "name": "Bread", "unit": "loaf", "price": "3.33", "tax_category": "reduced", "active": true,
+	}, http.StatusOK)
+
+	// Customers can't discount their own orders.
+	lines := []map[string]interface{}{
+		{"product_id": tea, "quantity": 3},
+		{"product_id": bread, "quantity": 2, "discount": "1.00"},
+	}
+	alice.expect("POST", "/order", map[string]interface{}{"lines": lines}, http.StatusUnprocessableEntity)
+
+	var created struct {
+		InsertedID string `json:"InsertedID"`
+	}
+	vic.expect("POST", "/order", map[string]interface{}{"lines": lines}, http.StatusCreated).decode(t, &created)
+
+	var got order
+	vic.expect("GET", "/order?id="+created.InsertedID, nil, http.StatusOK).decode(t, &got)
+	// Tea: 3 × 2.49 = 7.47, 20% tax 1.494 → 1.49. Bread: 2 × 3.33 = 6.66,
+	// less 1.00 = 5.66, 5% tax 0.283 → 0.28.
+	if len(got.Lines) != 2 || got.Lines[0].Tax != "1.49" || got.Lines[1].Tax != "0.28" || got.Lines[1].Total != "5.94" ||
+		got.Subtotal != "14.13" || got.DiscountTotal != "1.00" || got.TaxTotal != "1.77" || got.GrandTotal != "14.90" {
+		t.Fatalf("priced order = %+v", got)
+	}
+
+	// The price on the order doesn't follow later catalog changes.
+	vic.expect("PUT", "/product?id="+tea, map[string]interface{}{
+		"sku": "TEA-1", "name": "Tea", "unit": "each", "price": "9.99", "tax_category": "standard", "active": true,
+	}, http.StatusOK)
+	vic.expect("GET", "/order?id="+created.InsertedID, nil, http.StatusOK).decode(t, &got)
+	if got.Lines[0].UnitPrice != "2.49" {
+		t.Fatalf("unit price after catalog change = %s", got.Lines[0].UnitPrice)
+	}
+
+	vic.expect("POST", "/order", map[string]interface{}{"lines": []map[string]interface{}{
+		{"product_id": tea, "quantity": 1, "discount": "10.00"},
+	}}, http.StatusUnprocessableEntity)
+	vic.expect("POST", "/product", map[string]interface{}{
+		"sku": "X-1", "name": "X", "unit": "each", "price": "1", "tax_category": "luxury", "active": true,
+	}, http.StatusUnprocessableEntity)
+}
+
+func TestOrderLifecycle(t *testing.T) {
+	env := newTestEnv(t)
+	aliceID := env.signup("alice", "alice-pass", "customer", "+15550000001")
+	vicID := env.signup("vic", "vic-pass", "vendor", "+15550000002")
+	alice := env.loginWithOTP("+15550000001")
+	vic := env.loginWithPassword("vic", "vic-pass")
+	tea := env.product("TEA-1", "Tea", "2.50")
+
+	var created struct {
+		InsertedID string `json:"InsertedID"`
+	}
+	alice.expect("POST", "/order", map[string]interface{}{"product_id": tea, "quantity": 1, "draft": true}, http.StatusCreated).decode(t, &created)
+	path := "/order/transition?id=" + created.InsertedID
+
+	var got order
+	alice.expect("POST", path, map[string]string{"to": "Placed"}, http.StatusOK).decode(t, &got)
+	if got.OrderStatus != "Placed" {
+		t.Fatalf("status after placing = %s", got.OrderStatus)
+	}
+	alice.expect("POST", path, map[string]string{"to": "Confirmed"}, http.StatusForbidden)
+	alice.expect("POST", path, map[string]string{"to": "Lost"}, http.StatusUnprocessableEntity)
+	vic.expect("POST", path, map[string]string{"to": "Shipped"}, http.StatusConflict)
+
+	for _, to := range []string{"Confirmed", "Picking", "Shipped", "Delivered"} {
+		vic.expect("POST", path, map[string]string{"to": to}, http.StatusOK)
+	}
+	resp := alice.expect("PUT", "/cancel-order?order_id="+created.InsertedID, nil, http.StatusConflict)
+	var envelope errorEnvelope
+	resp.decode(t, &envelope)
+	if envelope.Error.Code != "invalid_transition" {
+		t.Fatalf("cancelling a delivered order = %+v", envelope)
+	}
+	vic.expect("POST", path, map[string]string{"to": "Returned", "note": "damaged"}, http.StatusOK).decode(t, &got)
+
+	want := []string{"Draft", "Placed", "Confirmed", "Picking", "Shipped", "Delivered", "Returned"}
+	if len(got.StatusHistory) != len(want) {
+		t.Fatalf("history = %+v", got.StatusHistory)
+	}
+	for i, change := range got.StatusHistory {
+		if change.To != want[i] || change.At == 0 || (i > 0 && change.From != want[i-1]) {
+			t.Fatalf("history[%d] = %+v", i, change)
+		}
+	}
+	if first := got.StatusHistory[0]; first.Actor != aliceID || first.Role != "customer" {
+		t.Fatalf("history[0] = %+v", first)
+	}
+	if last := got.StatusHistory[len(want)-1]; last.Actor != vicID || last.Role != "vendor" || last.Note != "damaged" {
+		t.Fatalf("last history entry = %+v", last)
+	}
+}
+
+func TestStockReservation(t *testing.T) {
+	env := newTestEnv(t)
+	env.signup("alice", "alice-pass", "customer", "+15550000001")
+	env.signup("bob", "bob-pass", "customer", "+15550000003")
+	env.signup("vic", "vic-pass", "vendor", "+15550000002")
+	alice := env.loginWithOTP("+15550000001")
+	bob := env.loginWithOTP("+15550000003")
+	vic := env.loginWithPassword("vic", "vic-pass")
+
+	var store struct {
+		InsertedID string `json:"InsertedID"`
+	}
+	vic.expect("POST", "/store", map[string]string{"name": "Downtown"}, http.StatusCreated).decode(t, &store)
+	tea := env.product("TEA-1", "Tea", "2.50")
+	receive := func(kind string, quantity int, status int) {
+		t.Helper()
+		vic.expect("POST", "/stock/movements", map[string]interface{}{
+			"store_id": store.InsertedID, "product_id": tea, "kind": kind, "quantity": quantity,
+		}, status)
+	}
+	receive("sale", 1, http.StatusUnprocessableEntity)
+	receive("receipt", -1, http.StatusUnprocessableEntity)
+	alice.expect("POST", "/stock/movements", map[string]interface{}{
+		"store_id": store.InsertedID, "product_id": tea, "kind": "receipt", "quantity": 1,
+	}, http.StatusForbidden)
+	receive("receipt", 3, http.StatusCreated)
+	receive("adjustment", -1, http.StatusCreated)
+	receive("adjustment", -5, http.StatusConflict)
+
+	order := func(c *client, quantity int) string {
+		t.Helper()
+		var created struct {
+			InsertedID string `json:"InsertedID"`
+		}
+		c.expect("POST", "/order", map[string]interface{}{"product_id": tea, "quantity": quantity, "store_id": store.InsertedID}, http.StatusCreated).decode(t, &created)
+		return created.InsertedID
+	}
+	alice.expect("POST", "/order", map[string]interface{}{"product_id": tea, "quantity": 3, "store_id": store.InsertedID}, http.StatusUnprocessableEntity)
+
+	// Both orders fit what is on hand, but only one can be confirmed.
+	first, second := order(alice, 2), order(bob, 1)
+	confirm := map[string]string{"to": "Confirmed"}
+	vic.expect("POST", "/order/transition?id="+first, confirm, http.StatusOK)
+	resp := vic.expect("POST", "/order/transition?id="+second, confirm, http.StatusConflict)
+	var envelope errorEnvelope
+	resp.decode(t, &envelope)
+	if envelope.Error.Code != "insufficient_stock" {
+		t.Fatalf("confirming past stock = %+v", envelope)
+	}
+
+	level := func() (onHand, reserved, available int) {
+		t.Helper()
+		var levels []struct {
+			OnHand    int `json:"on_hand"`
+			Reserved  int `json:"reserved"`
+			Available int `json:"available"`
+		}
+		vic.expect("GET", "/stock?store_id="+store.InsertedID+"&product_id="+tea, nil, http.StatusOK).decode(t, &levels)
+		if len(levels) != 1 {
+			t.Fatalf("levels = %+v", levels)
+		}
+		return levels[0].OnHand, levels[0].Reserved, levels[0].Available
+	}
+	if onHand, reserved, available := level(); onHand != 2 || reserved != 2 || available != 0 {
+		t.Fatalf("after confirming: on hand %d, reserved %d, available %d", onHand, reserved, available)
+	}
+
+	// Cancelling releases the reservation, so the other order can go ahead.
+	alice.expect("PUT", "/cancel-order?order_id="+first, nil, http.StatusForbidden)
+	vic.expect("PUT", "/cancel-order?order_id="+first, nil, http.StatusOK)
+	vic.expect("POST", "/order/transition?id="+second, confirm, http.StatusOK)
+	for _, to := range []string{"Picking", "Shipped"} {
+		vic.expect("POST", "/order/transition?id="+second, map[string]string{"to": to}, http.StatusOK)
+	}
+	if onHand, reserved, available := level(); onHand != 1 || reserved != 0 || available != 1 {
+		t.Fatalf("after shipping: on hand %d, reserved %d, available %d", onHand, reserved, available)
+	}
+
+	var movements []struct {
+		Kind     string `json:"kind"`
+		Quantity int    `json:"quantity"`
+	}
+	vic.expect("GET", "/stock/movements?source_id="+second+"&sort=created_at", nil, http.StatusOK).decode(t, &movements)
+	if len(movements) != 3 || movements[0].Kind != "reserve" || movements[1].Kind != "release" || movements[2].Kind != "sale" || movements[2].Quantity != -1 {
+		t.Fatalf("movements for shipped order = %+v", movements)
+	}
+}
+
+func TestLowStockAlerts(t *testing.T) {
+	env := newTestEnv(t)
+	vicID := env.signup("vic", "vic-pass", "vendor", "+15550000002")
+	vic := env.loginWithPassword("vic", "vic-pass")
+
+	vic.expect("POST", "/store", map[string]string{"name": "Uptown", "phone_number": "555"}, http.StatusUnprocessableEntity)
+	var store struct {
+		InsertedID string `json:"InsertedID"`
+	}
+	vic.expect("POST", "/store", map[string]string{"name": "Downtown", "phone_number": "+15550000009"}, http.StatusCreated).decode(t, &store)
+	tea := env.product("TEA-1", "Tea", "2.50")
+	item := func(extra map[string]interface{}) map[string]interface{} {
+		body := map[string]interface{}{"store_id": store.InsertedID, "product_id": tea}
+		for k, v := range extra {
+			body[k] = v
+		}
+		return body
+	}
+	vic.expect("POST", "/stock/movements", item(map[string]interface{}{"kind": "receipt", "quantity": 5}), http.StatusCreated)
+	vic.expect("PUT", "/stock/reorder-point", item(map[string]interface{}{"reorder_point": -1}), http.StatusUnprocessableEntity)
+	vic.expect("PUT", "/stock/reorder-point", item(map[string]interface{}{"reorder_point": 3}), http.StatusOK)
+
+	check := func(want int) {
+		t.Helper()
+		raised, err := inventory.CheckReorderPoints(context.Background(), handlers.Repos, env.sms)
+		if err != nil || raised != want {
+			t.Fatalf("CheckReorderPoints = %d, %v; want %d", raised, err, want)
+		}
+	}
+	check(0)
+
+	var created struct {
+		InsertedID string `json:"InsertedID"`
+	}
+	vic.expect("POST", "/order", map[string]interface{}{"product_id": tea, "quantity": 3, "store_id": store.InsertedID}, http.StatusCreated).decode(t, &created)
+	vic.expect("POST", "/order/transition?id="+created.InsertedID, map[string]string{"to": "Confirmed"}, http.StatusOK)
+	check(1)
+	check(0) // still open, so not raised again
+	if msg, ok := env.sms.Last("+15550000009"); !ok || !strings.Contains(msg.Body, "Tea (TEA-1) has 2 available") {
+		t.Fatalf("store alert SMS = %+v", msg)
+	}
+
+	var alerts []struct {
+		ID             string `json:"id"`
+		Open           bool   `json:"open"`
+		NotifiedAt     int64  `json:"notified_at"`
+		AcknowledgedBy string `json:"acknowledged_by"`
+	}
+	vic.expect("GET", "/stock/alerts?open=true", nil, http.StatusOK).decode(t, &alerts)
+	if len(alerts) != 1 || alerts[0].NotifiedAt == 0 {
+		t.Fatalf("open alerts = %+v", alerts)
+	}
+	var acked struct {
+		AcknowledgedBy string `json:"acknowledged_by"`
+		Open           bool   `json:"open"`
+	}
+	vic.expect("POST", "/stock/alert/acknowledge?id="+alerts[0].ID, nil, http.StatusOK).decode(t, &acked)
+	if acked.AcknowledgedBy != vicID || !acked.Open {
+		t.Fatalf("acknowledged alert = %+v", acked)
+	}
+	vic.expect("POST", "/stock/alert/acknowledge?id=000000000000000000000000", nil, http.StatusNotFound)
+
+	// Restocking resolves the alert.
+	vic.expect("POST", "/stock/movements", item(map[string]interface{}{"kind": "receipt", "quantity": 10}), http.StatusCreated)
+	check(0)
+	vic.expect("GET", "/stock/alerts?open=true", nil, http.StatusOK).decode(t, &alerts)
+	if len(alerts) != 0 {
+		t.Fatalf("open alerts after restocking = %+v", alerts)
+	}
+}
+
+func TestPurchaseOrders(t *testing.T) {
+	env := newTestEnv(t)
+	env.signup("vic", "vic-pass", "vendor", "+15550000002")
+	env.signup("alice", "alice-pass", "customer", "+15550000001")
+	vic := env.loginWithPassword("vic", "vic-pass")
+	alice := env.loginWithOTP("+15550000001")
+
+	var store, supplier, created struct {
+		InsertedID string `json:"InsertedID"`
+	}
+	vic.expect("POST", "/store", map[string]string{"name": "Downtown"}, http.StatusCreated).decode(t, &store)
+	alice.expect("POST", "/supplier", map[string]string{"name": "Leaf & Co"}, http.StatusForbidden)
+	vic.expect("POST", "/supplier", map[string]string{"name": "Leaf & Co", "phone_number": "+15550000100"}, http.StatusCreated).decode(t, &supplier)
+	tea := env.product("TEA-1", "Tea", "2.50")
+	mugs := env.product("MUG-1", "Mug", "6.00")
+
+	po := map[string]interface{}{
+		"supplier_id": supplier.InsertedID,
+		"store_id":    store.InsertedID,
+		"lines": []map[string]interface{}{
+			{"product_id": tea, "quantity": 10, "unit_cost": "1.20"},
+			{"product_id": tea, "quantity": 1, "unit_cost": "1.20"},
+		},
+	}
+	vic.expect("POST", "/purchase-order", po, http.StatusUnprocessableEntity)
+	po["lines"] = []map[string]interface{}{
+		{"product_id": tea, "quantity": 10, "unit_cost": "1.20"},
+		{"product_id": mugs, "quantity": 4, "unit_cost": "3.00"},
+	}
+	vic.expect("POST", "/purchase-order", po, http.StatusCreated).decode(t, &created)
+	path := "?id=" + created.InsertedID
+
+	type purchaseOrder struct {
+		Status      string `json:"status"`
+		Total       string `json:"total"`
+		Outstanding int    `json:"outstanding"`
+		Lines       []struct {
+			Received    int `json:"received"`
+			Outstanding int `json:"outstanding"`
+		} `json:"lines"`
+	}
+	receive := func(status int, lines ...map[string]interface{}) purchaseOrder {
+		t.Helper()
+		var got purchaseOrder
+		resp := vic.expect("POST", "/purchase-order/receive"+path, map[string]interface{}{"lines": lines}, status)
+		if status == http.StatusOK {
+			resp.decode(t, &got)
+		}
+		return got
+	}
+	receive(http.StatusConflict, map[string]interface{}{"product_id": tea, "quantity": 1})
+
+	var got purchaseOrder
+	vic.expect("POST", "/purchase-order/approve"+path, nil, http.StatusOK).decode(t, &got)
+	if got.Status != "approved" || got.Total != "24.00" || got.Outstanding != 14 {
+		t.Fatalf("approved purchase order = %+v", got)
+	}
+	vic.expect("PUT", "/purchase-order"+path, po, http.StatusConflict)
+
+	receive(http.StatusUnprocessableEntity, map[string]interface{}{"product_id": tea, "quantity": 11})
+	got = receive(http.StatusOK, map[string]interface{}{"product_id": tea, "quantity": 6})
+	if got.Status != "partially_received" || got.Outstanding != 8 || got.Lines[0].Received != 6 || got.Lines[0].Outstanding != 4 {
+		t.Fatalf("after a partial delivery = %+v", got)
+	}
+
+	var outstanding []purchaseOrder
+	vic.expect("GET", "/purchase-orders?outstanding=true&supplier_id="+supplier.InsertedID, nil, http.StatusOK).decode(t, &outstanding)
+	if len(outstanding) != 1 {
+		t.Fatalf("outstanding purchase orders = %+v", outstanding)
+	}
+
+	got = receive(http.StatusOK, map[string]interface{}{"product_id": tea, "quantity": 4}, map[string]interface{}{"product_id": mugs, "quantity": 4})
+	if got.Status != "received" || got.Outstanding != 0 {
+		t.Fatalf("after the final delivery = %+v", got)
+	}
+	vic.expect("GET", "/purchase-orders?outstanding=true", nil, http.StatusOK).decode(t, &outstanding)
+	if len(outstanding) != 0 {
+		t.Fatalf("outstanding after receiving everything = %+v", outstanding)
+	}
+	vic.expect("POST", "/purchase-order/cancel"+path, nil, http.StatusConflict)
+
+	var levels []struct {
+		OnHand int `json:"on_hand"`
+	}
+	vic.expect("GET", "/stock?store_id="+store.InsertedID+"&product_id="+tea, nil, http.StatusOK).decode(t, &levels)
+	if len(levels) != 1 || levels[0].OnHand != 10 {
+		t.Fatalf("tea stock after receiving = %+v", levels)
+	}
+}
+
+func TestStockTransfers(t *testing.T) {
+	env := newTestEnv(t)
+	env.signup("vic", "vic-pass", "vendor", "+15550000002")
+	vic := env.loginWithPassword("vic", "vic-pass")
+
+	var north, south, created struct {
+		InsertedID string `json:"InsertedID"`
+	}
+	vic.expect("POST", "/store", map[string]string{"name": "North"}, http.StatusCreated).decode(t, &north)
+	vic.expect("POST", "/store", map[string]string{"name": "South"}, http.StatusCreated).decode(t, &south)
+	tea := env.product("TEA-1", "Tea", "2.50")
+	vic.expect("POST", "/stock/movements", map[string]interface{}{
+		"store_id": north.InsertedID, "product_id": tea, "kind": "receipt", "quantity": 10,
+	}, http.StatusCreated)
+	onHand := func(store string) int {
+		t.Helper()
+		var levels []struct {
+			OnHand int `json:"on_hand"`
+		}
+		vic.expect("GET", "/stock?store_id="+store+"&product_id="+tea, nil, http.StatusOK).decode(t, &levels)
+		if len(levels) == 0 {
+			return 0
+		}
+		return levels[0].OnHand
+	}
+
+	transfer := map[string]interface{}{
+		"from_store_id": north.InsertedID,
+		"to_store_id":   north.InsertedID,
+		"lines":         []map[string]interface{}{{"product_id": tea, "quantity": 12}},
+	}
+	vic.expect("POST", "/transfer", transfer, http.StatusUnprocessableEntity)
+	transfer["to_store_id"] = south.InsertedID
+	vic.expect("POST", "/transfer", transfer, http.StatusCreated).decode(t, &created)
+	path := "?id=" + created.InsertedID
+
+	// North only has 10, so 12 can't leave.
+	vic.expect("POST", "/transfer/dispatch"+path, nil, http.StatusConflict)
+	transfer["lines"] = []map[string]interface{}{{"product_id": tea, "quantity": 6}}
+	vic.expect("PUT", "/transfer"+path, transfer, http.StatusOK)
+
+	type transferDoc struct {
+		Status         string `json:"status"`
+		InTransit      int    `json:"in_transit"`
+		HasDiscrepancy bool   `json:"has_discrepancy"`
+		Lines          []struct {
+			Received        int    `json:"received"`
+			Discrepancy     int    `json:"discrepancy"`
+			DiscrepancyNote string `json:"discrepancy_note"`
+		} `json:"lines"`
+	}
+	var got transferDoc
+	vic.expect("POST", "/transfer/dispatch"+path, nil, http.StatusOK).decode(t, &got)
+	if got.Status != "dispatched" || got.InTransit != 6 || onHand(north.InsertedID) != 4 {
+		t.Fatalf("dispatched transfer = %+v, north on hand %d", got, onHand(north.InsertedID))
+	}
+	var incoming []transferDoc
+	vic.expect("GET", "/transfers?status=dispatched&to_store_id="+south.InsertedID, nil, http.StatusOK).decode(t, &incoming)
+	if len(incoming) != 1 || incoming[0].InTransit != 6 {
+		t.Fatalf("in transit to south = %+v", incoming)
+	}
+	vic.expect("POST", "/transfer/cancel"+path, nil, http.StatusConflict)
+
+	vic.expect("POST", "/transfer/receive"+path, map[string]interface{}{
+		"lines": []map[string]interface{}{{"product_id": tea, "quantity": 5, "note": "one box crushed"}},
+	}, http.StatusOK).decode(t, &got)
+	if got.Status != "received" || got.InTransit != 0 || !got.HasDiscrepancy ||
+		got.Lines[0].Received != 5 || got.Lines[0].Discrepancy != -1 || got.Lines[0].DiscrepancyNote != "one box crushed" {
+		t.Fatalf("received transfer = %+v", got)
+	}
+	if onHand(south.InsertedID) != 5 {
+		t.Fatalf("south on hand = %d, want 5", onHand(south.InsertedID))
+	}
+	vic.expect("GET", "/transfers?has_discrepancy=true", nil, http.StatusOK).decode(t, &incoming)
+	if len(incoming) != 1 {
+		t.Fatalf("transfers with discrepancies = %+v", incoming)
+	}
+}
+
+func TestStocktake(t *testing.T) {
+	env := newTestEnv(t)
+	env.signup("vic", "vic-pass", "vendor", "+15550000002")
+	vic := env.loginWithPassword("vic", "vic-pass")
+
+	var store, created struct {
+		InsertedID string `json:"InsertedID"`
+	}
+	vic.expect("POST", "/store", map[string]string{"name": "North"}, http.StatusCreated).decode(t, &store)
+	tea := env.product("TEA-1", "Tea", "2.50")
+	jam := env.product("JAM-1", "Jam", "4.00")
+	vic.expect("POST", "/stock/movements", map[string]interface{}{
+		"store_id": store.InsertedID, "product_id": tea, "kind": "receipt", "quantity": 10,
+	}, http.StatusCreated)
+
+	vic.expect("POST", "/stocktake", map[string]interface{}{"store_id": store.InsertedID}, http.StatusCreated).decode(t, &created)
+	path := "?id=" + created.InsertedID
+
+	type stocktakeDoc struct {
+		Status        string `json:"status"`
+		Uncounted     int    `json:"uncounted"`
+		Discrepancies int    `json:"discrepancies"`
+		Lines         []struct {
+			ProductID string `json:"product_id"`
+			Expected  int    `json:"expected"`
+			Counted   int    `json:"counted"`
+			Variance  int    `json:"variance"`
+		} `json:"lines"`
+	}
+	var got stocktakeDoc
+	vic.expect("GET", "/stocktake"+path, nil, http.StatusOK).decode(t, &got)
+	if got.Status != "open" || len(got.Lines) != 1 || got.Lines[0].Expected != 10 || got.Uncounted != 1 {
+		t.Fatalf("opened stocktake = %+v", got)
+	}
+
+	// Three teas sell while the count is open.
+	vic.expect("POST", "/stock/movements", map[string]interface{}{
+		"store_id": store.InsertedID, "product_id": tea, "kind": "adjustment", "quantity": -3,
+	}, http.StatusCreated)
+
+	count := func(device string, lines ...map[string]interface{}) result {
+		return vic.do("POST", "/stocktake/count"+path, map[string]interface{}{"device": device, "counts": lines})
+	}
+	line := func(product string, quantity int) map[string]interface{} {
+		return map[string]interface{}{"product_id": product, "quantity": quantity}
+	}
+	if res := count("scanner-1", line("000000000000000000000000", 1)); res.status != http.StatusUnprocessableEntity {
+		t.Fatalf("count of unknown product: status %d", res.status)
+	}
+	// Two devices count the same shelf; the first corrects itself.
+	count("scanner-1", line(tea, 3))
+	count("scanner-2", line(tea, 4), line(jam, 2))
+	res := count("scanner-1", line(tea, 5))
+	if res.status != http.StatusOK {
+		t.Fatalf("count: status %d", res.status)
+	}
+	res.decode(t, &got)
+	if len(got.Lines) != 2 || got.Lines[0].Counted != 9 || got.Lines[0].Variance != -1 ||
+		got.Lines[1].Expected != 0 || got.Lines[1].Variance != 2 || got.Discrepancies != 2 {
+		t.Fatalf("counted stocktake = %+v", got)
+	}
+
+	vic.expect("POST", "/stocktake/approve"+path, nil, http.StatusOK).decode(t, &got)
+	if got.Status != "approved" {
+		t.Fatalf("approved stocktake = %+v", got)
+	}
+	vic.expect("POST", "/stocktake/approve"+path, nil, http.StatusConflict)
+	if res := count("scanner-1", line(tea, 1)); res.status != http.StatusConflict {
+		t.Fatalf("count after approval: status %d", res.status)
+	}
+
+	var levels []struct {
+		ProductID string `json:"product_id"`
+		OnHand    int    `json:"on_hand"`
+	}
+	vic.expect("GET", "/stock?store_id="+store.InsertedID+"&sort=on_hand", nil, http.StatusOK).decode(t, &levels)
+	if len(levels) != 2 || levels[0].ProductID != jam || levels[0].OnHand != 2 || levels[1].OnHand != 9 {
+		t.Fatalf("levels after stocktake = %+v", levels)
+	}
+	var movements []struct {
+		Kind     string `json:"kind"`
+		Quantity int    `json:"quantity"`
+	}
+	vic.expect("GET", "/stock/movements?source_id="+created.InsertedID+"&product_id="+tea, nil, http.StatusOK).decode(t, &movements)
+	// The adjustment is from the 7 on hand at approval to the 9 counted, not
+	// the variance from the 10 expected when the count opened.
+	if len(movements) != 1 || movements[0].Kind != "count_adjustment" || movements[0].Quantity != 2 {
+		t.Fatalf("stocktake movements = %+v", movements)
+	}
+}
+
+func TestLotTracking(t *testing.T) {
+	env := newTestEnv(t)
+	env.signup("vic", "vic-pass", "vendor", "+15550000002")
+	env.signup("alice", "alice-pass", "customer", "+15550000001")
+	vic := env.loginWithPassword("vic", "vic-pass")
+	alice := env.loginWithOTP("+15550000001")
+
+	var store, supplier, po, order struct {
+		InsertedID string `json:"InsertedID"`
+	}
+	vic.expect("POST", "/store", map[string]string{"name": "Downtown"}, http.StatusCreated).decode(t, &store)
+	vic.expect("POST", "/supplier", map[string]string{"name": "Dairy Farm"}, http.StatusCreated).decode(t, &supplier)
+	milk := env.product("MILK-1", "Milk", "1.10")
+
+	vic.expect("POST", "/stock/movements", map[string]interface{}{
+		"store_id": store.InsertedID, "product_id": milk, "kind": "adjustment", "quantity": 1, "lot": "L1",
+	}, http.StatusUnprocessableEntity)
+	vic.expect("POST", "/purchase-order", map[string]interface{}{
+		"supplier_id": supplier.InsertedID,
+		"store_id":    store.InsertedID,
+		"lines":       []map[string]interface{}{{"product_id": milk, "quantity": 8, "unit_cost": "0.60"}},
+	}, http.StatusCreated).decode(t, &po)
+	vic.expect("POST", "/purchase-order/approve?id="+po.InsertedID, nil, http.StatusOK)
+
+	// The later delivery expires first, so it is sold first.
+	day := int64(24 * 60 * 60)
+	now := time.Now().Unix()
+	vic.expect("POST", "/purchase-order/receive?id="+po.InsertedID, map[string]interface{}{
+		"lines": []map[string]interface{}{{"product_id": milk, "quantity": 4, "lot": "A", "expires_at": now + 60*day}},
+	}, http.StatusOK)
+	vic.expect("POST", "/purchase-order/receive?id="+po.InsertedID, map[string]interface{}{
+		"lines": []map[string]interface{}{{"product_id": milk, "quantity": 4, "lot": "B", "expires_at": now + 10*day}},
+	}, http.StatusOK)
+
+	type lot struct {
+		ID        string `json:"id"`
+		Lot       string `json:"lot"`
+		Remaining int    `json:"remaining"`
+	}
+	var lots []lot
+	vic.expect("GET", "/stock/lots?store_id="+store.InsertedID+"&expires_within=30&in_stock=true", nil, http.StatusOK).decode(t, &lots)
+	if len(lots) != 1 || lots[0].Lot != "B" || lots[0].Remaining != 4 {
+		t.Fatalf("lots expiring within 30 days = %+v", lots)
+	}
+
+	alice.expect("POST", "/order", map[string]interface{}{"product_id": milk, "quantity": 6, "store_id": store.InsertedID}, http.StatusCreated).decode(t, &order)
+	for _, to := range []string{"Confirmed", "Picking", "Shipped"} {
+		vic.expect("POST", "/order/transition?id="+order.InsertedID, map[string]string{"to": to}, http.StatusOK)
+	}
+	vic.expect("GET", "/stock/lots?store_id="+store.InsertedID+"&in_stock=true", nil, http.StatusOK).decode(t, &lots)
+	if len(lots) != 1 || lots[0].Lot != "A" || lots[0].Remaining != 2 {
+		t.Fatalf("lots left after shipping = %+v", lots)
+	}
+
+	alice.expect("GET", "/order/trace?id="+order.InsertedID, nil, http.StatusForbidden)
+	var traces []struct {
+		Quantity        int    `json:"quantity"`
+		Lot             lot    `json:"lot"`
+		PurchaseOrderID string `json:"purchase_order_id"`
+		SupplierName    string `json:"supplier_name"`
+	}
+	vic.expect("GET", "/order/trace?id="+order.InsertedID, nil, http.StatusOK).decode(t, &traces)
+	if len(traces) != 2 || traces[0].Lot.Lot != "B" || traces[0].Quantity != 4 || traces[1].Lot.Lot != "A" || traces[1].Quantity != 2 ||
+		traces[0].PurchaseOrderID != po.InsertedID || traces[0].SupplierName != "Dairy Farm" {
+		t.Fatalf("order trace = %+v", traces)
+	}
+
+	var movements []struct {
+		SourceID string `json:"source_id"`
+		Quantity int    `json:"quantity"`
+	}
+	vic.expect("GET", "/stock/movements?kind=sale&lot_id="+traces[1].Lot.ID, nil, http.StatusOK).decode(t, &movements)
+	if len(movements) != 1 || movements[0].SourceID != order.InsertedID || movements[0].Quantity != -2 {
+		t.Fatalf("sales from lot A = %+v", movements)
+	}
+
+	// What is left of lot A moves to another store and stays lot A there.
+	var uptown, transfer, order2 struct {
+		InsertedID string `json:"InsertedID"`
+	}
+	vic.expect("POST", "/store", map[string]string{"name": "Uptown"}, http.StatusCreated).decode(t, &uptown)
+	vic.expect("POST", "/transfer", map[string]interface{}{
+		"from_store_id": store.InsertedID, "to_store_id": uptown.InsertedID,
+		"lines": []map[string]interface{}{{"product_id": milk, "quantity": 2}},
+	}, http.StatusCreated).decode(t, &transfer)
+	var dispatched struct {
+		Lines []struct {
+			Lots []struct {
+				LotID      string `json:"lot_id"`
+				Lot        string `json:"lot"`
+				Dispatched int    `json:"dispatched"`
+				Received   int    `json:"received"`
+			} `json:"lots"`
+		} `json:"lines"`
+	}
+	vic.expect("POST", "/transfer/dispatch?id="+transfer.InsertedID, nil, http.StatusOK).decode(t, &dispatched)
+	if lots := dispatched.Lines[0].Lots; len(lots) != 1 || lots[0].LotID != traces[1].Lot.ID || lots[0].Dispatched != 2 {
+		t.Fatalf("dispatched lots = %+v", dispatched.Lines[0].Lots)
+	}
+	vic.expect("POST", "/transfer/receive?id="+transfer.InsertedID, map[string]interface{}{}, http.StatusOK).decode(t, &dispatched)
+	if lots := dispatched.Lines[0].Lots; len(lots) != 1 || lots[0].Received != 2 {
+		t.Fatalf("received lots = %+v", dispatched.Lines[0].Lots)
+	}
+	vic.expect("GET", "/stock/lots?store_id="+uptown.InsertedID+"&in_stock=true", nil, http.StatusOK).decode(t, &lots)
+	if len(lots) != 1 || lots[0].Lot != "A" || lots[0].Remaining != 2 || lots[0].ID == traces[1].Lot.ID {
+		t.Fatalf("lots at the receiving store = %+v", lots)
+	}
+
+	alice.expect("POST", "/order", map[string]interface{}{"product_id": milk, "quantity": 1, "store_id": uptown.InsertedID}, http.StatusCreated).decode(t, &order2)
+	for _, to := range []string{"Confirmed", "Picking", "Shipped"} {
+		vic.expect("POST", "/order/transition?id="+order2.InsertedID, map[string]string{"to": to}, http.StatusOK)
+	}
+	var transferred []struct {
+		Quantity        int    `json:"quantity"`
+		Lot             lot    `json:"lot"`
+		Origin          lot    `json:"origin"`
+		PurchaseOrderID string `json:"purchase_order_id"`
+		SupplierName    string `json:"supplier_name"`
+	}
+	vic.expect("GET", "/order/trace?id="+order2.InsertedID, nil, http.StatusOK).decode(t, &transferred)
+	if len(transferred) != 1 || transferred[0].Lot.ID != lots[0].ID || transferred[0].Lot.Remaining != 1 ||
+		transferred[0].Origin.ID != traces[1].Lot.ID || transferred[0].PurchaseOrderID != po.InsertedID || transferred[0].SupplierName != "Dairy Farm" {
+		t.Fatalf("trace through transfer = %+v", transferred)
+	}
+
+	// Lots that never expire are left out of the expiry listing, which
+	// pages cleanly, and are still listed by receipt.
+	cheese := env.product("CHEESE-1", "Cheese", "3.00")
+	for _, receipt := range []struct {
+		lot     string
+		expires int64
+	}{{"C1", 0}, {"C2", now + 20*day}, {"C3", 0}, {"C4", now + 5*day}} {
+		vic.expect("POST", "/stock/movements", map[string]interface{}{
+			"store_id": store.InsertedID, "product_id": cheese, "kind": "receipt", "quantity": 1, "lot": receipt.lot, "expires_at": receipt.expires,
+		}, http.StatusCreated)
+	}
+	listLots := func(query string) []string {
+		t.Helper()
+		var names []string
+		path := "/stock/lots?product_id=" + cheese + "&limit=1" + query
+		for {
+			res := vic.expect("GET", path, nil, http.StatusOK)
+			res.decode(t, &lots)
+			for _, l := range lots {
+				names = append(names, l.Lot)
+			}
+			next := res.header.Get("X-Next-Cursor")
+			if next == "" {
+				return names
+			}
+			path = "/stock/lots?product_id=" + cheese + "&limit=1" + query + "&cursor=" + next
+		}
+	}
+	if got := listLots(""); fmt.Sprint(got) != "[C4 C2]" {
+		t.Fatalf("lots by expiry = %v", got)
+	}
+	if got := listLots("&sort=received_at"); len(got) != 4 {
+		t.Fatalf("lots by receipt = %v", got)
+	}
+}
+
+func TestBarcodeLookup(t *testing.T) {
+	env := newTestEnv(t)
+	env.signup("alice", "alice-pass", "customer", "+15550000001")
+	env.signup("vic", "vic-pass", "vendor", "+15550000002")
+	alice := env.loginWithOTP("+15550000001")
+	vic := env.loginWithPassword("vic", "vic-pass")
+
+	var store, chocolate struct {
+		InsertedID string `json:"InsertedID"`
+	}
+	vic.expect("POST", "/store", map[string]string{"name": "Downtown"}, http.StatusCreated).decode(t, &store)
+	product := func(sku string, barcodes ...map[string]string) map[string]interface{} {
+		return map[string]interface{}{
+			"sku": sku, "name": sku, "unit": "each", "price": "1.00", "tax_category": "standard", "active": true,
+			"barcodes": barcodes,
+		}
+	}
+	barcode := func(kind, code string) map[string]string { return map[string]string{"kind": kind, "code": code} }
+
+	for _, bad := range []map[string]string{
+		barcode("ean13", "4006381333932"), // wrong check digit
+		barcode("upca", "03600029145"),    // too short
+		barcode("internal", "SHELF 7"),
+		barcode("isbn", "9780306406157"),
+	} {
+		vic.expect("POST", "/product", product("BAD-1", bad), http.StatusUnprocessableEntity)
+	}
+	vic.expect("POST", "/product", product("CHOC-1", barcode("ean13", "4006381333931"), barcode("internal", "SHELF-7")), http.StatusCreated).decode(t, &chocolate)
+	vic.expect("POST", "/product", product("SODA-1", barcode("ean13", "0036000291452")), http.StatusCreated)
+	// The same GTIN written as a UPC-A, and a reused internal code.
+	vic.expect("POST", "/product", product("SODA-2", barcode("upca", "036000291452")), http.StatusUnprocessableEntity)
+	vic.expect("POST", "/product", product("CHOC-2", barcode("internal", "SHELF-7")), http.StatusUnprocessableEntity)
+	vic.expect("PUT", "/product?id="+chocolate.InsertedID, product("CHOC-1", barcode("ean13", "4006381333931")), http.StatusOK)
+
+	vic.expect("POST", "/stock/movements", map[string]interface{}{
+		"store_id": store.InsertedID, "product_id": chocolate.InsertedID, "kind": "receipt", "quantity": 5,
+	}, http.StatusCreated)
+
+	type lookup struct {
+		Product struct {
+			ID  string `json:"id"`
+			SKU string `json:"sku"`
+		} `json:"product"`
+		MatchedBy string `json:"matched_by"`
+		Stock     *struct {
+			OnHand    int `json:"on_hand"`
+			Available int `json:"available"`
+		} `json:"stock"`
+	}
+	var got lookup
+	vic.expect("GET", "/product/lookup?code=4006381333931&store_id="+store.InsertedID, nil, http.StatusOK).decode(t, &got)
+	if got.Product.ID != chocolate.InsertedID || got.MatchedBy != "barcode" || got.Stock == nil || got.Stock.Available != 5 {
+		t.Fatalf("lookup by EAN-13 = %+v", got)
+	}
+	got = lookup{}
+	vic.expect("GET", "/product/lookup?code=036000291452", nil, http.StatusOK).decode(t, &got)
+	if got.Product.SKU != "SODA-1" || got.Stock != nil {
+		t.Fatalf("lookup by UPC-A = %+v", got)
+	}
+	vic.expect("GET", "/product/lookup?code=CHOC-1", nil, http.StatusOK).decode(t, &got)
+	if got.Product.SKU != "CHOC-1" || got.MatchedBy != "sku" {
+		t.Fatalf("lookup by SKU = %+v", got)
+	}
+	vic.expect("GET", "/product/lookup?code=SHELF-7", nil, http.StatusNotFound)
+	vic.expect("GET", "/product/lookup", nil, http.StatusUnprocessableEntity)
+	alice.expect("GET", "/product/lookup?code=CHOC-1", nil, http.StatusForbidden)
+}
+
+func TestUnitsOfMeasure(t *testing.T) {
+	env := newTestEnv(t)
+	env.signup("alice", "alice-pass", "customer", "+15550000001")
+	env.signup("vic", "vic-pass", "vendor", "+15550000002")
+	alice := env.loginWithOTP("+15550000001")
+	vic := env.loginWithPassword("vic", "vic-pass")
+
+	var store, supplier, soda, po, placed struct {
+		InsertedID string `json:"InsertedID"`
+	}
+	vic.expect("POST", "/store", map[string]string{"name": "Downtown"}, http.StatusCreated).decode(t, &store)
+	vic.expect("POST", "/supplier", map[string]string{"name": "Fizz Ltd"}, http.StatusCreated).decode(t, &supplier)
+	product := map[string]interface{}{
+		"sku": "SODA-1", "name": "Soda", "unit": "each", "price": "1.00", "tax_category": "standard", "active": true,
+		"units": []map[string]interface{}{{"name": "case", "factor": 24}, {"name": "each", "factor": 1}},
+	}
+	vic.expect("POST", "/product", product, http.StatusUnprocessableEntity)
+	product["units"] = []map[string]interface{}{{"name": "case", "factor": 24}, {"name": "six-pack", "factor": 6, "price": "5.00"}}
+	vic.expect("POST", "/product", product, http.StatusCreated).decode(t, &soda)
+
+	// Bought by the case, stocked by the can.
+	vic.expect("POST", "/purchase-order", map[string]interface{}{
+		"supplier_id": supplier.InsertedID,
+		"store_id":    store.InsertedID,
+		"lines":       []map[string]interface{}{{"product_id": soda.InsertedID, "quantity": 2, "unit": "case", "unit_cost": "18.00"}},
+	}, http.StatusCreated).decode(t, &po)
+	vic.expect("POST", "/purchase-order/approve?id="+po.InsertedID, nil, http.StatusOK)
+	var received struct {
+		Outstanding int `json:"outstanding"`
+	}
+	vic.expect("POST", "/purchase-order/receive?id="+po.InsertedID, map[string]interface{}{
+		"lines": []map[string]interface{}{{"product_id": soda.InsertedID, "quantity": 1}},
+	}, http.StatusOK).decode(t, &received)
+	if received.Outstanding != 24 {
+		t.Fatalf("outstanding = %d, want one case of 24", received.Outstanding)
+	}
+	movement := func(kind string, quantity int, unit string, status int) {
+		t.Helper()
+		vic.expect("POST", "/stock/movements", map[string]interface{}{
+			"store_id": store.InsertedID, "product_id": soda.InsertedID, "kind": kind, "quantity": quantity, "unit": unit,
+		}, status)
+	}
+	movement("receipt", 1, "pallet", http.StatusUnprocessableEntity)
+	movement("receipt", 6, "", http.StatusCreated)
+	movement("adjustment", -1, "six-pack", http.StatusCreated)
+
+	var movements []struct {
+		Kind         string `json:"kind"`
+		Quantity     int    `json:"quantity"`
+		Unit         string `json:"unit"`
+		UnitQuantity int    `json:"unit_quantity"`
+	}
+	vic.expect("GET", "/stock/movements?product_id="+soda.InsertedID+"&sort=created_at", nil, http.StatusOK).decode(t, &movements)
+	if len(movements) != 3 || movements[0].Quantity != 24 || movements[0].Unit != "case" || movements[0].UnitQuantity != 1 ||
+		movements[1].Quantity != 6 || movements[1].Unit != "" || movements[2].Quantity != -6 || movements[2].UnitQuantity != -1 {
+		t.Fatalf("movements = %+v", movements)
+	}
+
+	line := func(quantity int, unit string) map[string]interface{} {
+		return map[string]interface{}{"store_id": store.InsertedID, "lines": []map[string]interface{}{
+			{"product_id": soda.InsertedID, "quantity": quantity, "unit": unit},
+		}}
+	}
+	alice.expect("POST", "/order", line(1, "pallet"), http.StatusUnprocessableEntity)
+	alice.expect("POST", "/order", line(2, "case"), http.StatusUnprocessableEntity) // 48 cans, 24 in stock
+	var got order
+	alice.expect("POST", "/order", line(3, "six-pack"), http.StatusCreated).decode(t, &placed)
+	alice.expect("GET", "/order?id="+placed.InsertedID, nil, http.StatusOK).decode(t, &got)
+	if got.Lines[0].UnitPrice != "5.00" || got.Lines[0].Total != "15.00" {
+		t.Fatalf("six-pack line = %+v", got.Lines[0])
+	}
+	vic.expect("POST", "/order/transition?id="+placed.InsertedID, map[string]string{"to": "Confirmed"}, http.StatusOK)
+
+	var levels []struct {
+		OnHand    int `json:"on_hand"`
+		Reserved  int `json:"reserved"`
+		Available int `json:"available"`
+	}
+	vic.expect("GET", "/stock?product_id="+soda.InsertedID, nil, http.StatusOK).decode(t, &levels)
+	if len(levels) != 1 || levels[0].OnHand != 24 || levels[0].Reserved != 18 || levels[0].Available != 6 {
+		t.Fatalf("levels = %+v", levels)
+	}
+
+	// Transfers and counts can be given in a pack unit too.
+	var uptown, transfer, stocktake struct {
+		InsertedID string `json:"InsertedID"`
+	}
+	vic.expect("POST", "/store", map[string]string{"name": "Uptown"}, http.StatusCreated).decode(t, &uptown)
+	moveSoda := func(unit string) map[string]interface{} {
+		return map[string]interface{}{
+			"from_store_id": store.InsertedID, "to_store_id": uptown.InsertedID,
+			"lines": []map[string]interface{}{{"product_id": soda.InsertedID, "quantity": 1, "unit": unit}},
+		}
+	}
+	vic.expect("POST", "/transfer", moveSoda("pallet"), http.StatusUnprocessableEntity)
+	vic.expect("POST", "/transfer", moveSoda("six-pack"), http.StatusCreated).decode(t, &transfer)
+	vic.expect("POST", "/transfer/dispatch?id="+transfer.InsertedID, nil, http.StatusOK)
+	vic.expect("POST", "/transfer/receive?id="+transfer.InsertedID, map[string]interface{}{}, http.StatusOK)
+	var moved struct {
+		Lines []struct {
+			Quantity     int    `json:"quantity"`
+			Unit         string `json:"unit"`
+			UnitQuantity int    `json:"unit_quantity"`
+			Received     int    `json:"received"`
+		} `json:"lines"`
+	}
+	vic.expect("GET", "/transfer?id="+transfer.InsertedID, nil, http.StatusOK).decode(t, &moved)
+	if l := moved.Lines[0]; l.Quantity != 6 || l.Unit != "six-pack" || l.UnitQuantity != 1 || l.Received != 6 {
+		t.Fatalf("transfer line = %+v", l)
+	}
+
+	vic.expect("POST", "/stocktake", map[string]interface{}{"store_id": uptown.InsertedID}, http.StatusCreated).decode(t, &stocktake)
+	countSoda := func(quantity int, unit string, status int) {
+		t.Helper()
+		vic.expect("POST", "/stocktake/count?id="+stocktake.InsertedID, map[string]interface{}{
+			"device": "scanner-1", "counts": []map[string]interface{}{{"product_id": soda.InsertedID, "quantity": quantity, "unit": unit}},
+		}, status)
+	}
+	countSoda(1, "pallet", http.StatusUnprocessableEntity)
+	countSoda(2, "six-pack", http.StatusOK)
+	var counted struct {
+		Lines []struct {
+			Expected int `json:"expected"`
+			Counted  int `json:"counted"`
+			Variance int `json:"variance"`
+		} `json:"lines"`
+	}
+	vic.expect("GET", "/stocktake?id="+stocktake.InsertedID, nil, http.StatusOK).decode(t, &counted)
+	if l := counted.Lines[0]; l.Expected != 6 || l.Counted != 12 || l.Variance != 6 {
+		t.Fatalf("stocktake line = %+v", l)
+	}
+
+	// Pack sizes are fixed while stock counted in them is on hand or on order.
+	product["units"] = []map[string]interface{}{{"name": "case", "factor": 12}, {"name": "six-pack", "factor": 6, "price": "5.00"}}
+	vic.expect("PUT", "/product?id="+soda.InsertedID, product, http.StatusConflict)
+	product["units"] = []map[string]interface{}{{"name": "case", "factor": 24}, {"name": "six-pack", "factor": 6, "price": "4.50"}}
+	vic.expect("PUT", "/product?id="+soda.InsertedID, product, http.StatusOK)
+
+	juice := map[string]interface{}{
+		"sku": "JUICE-1", "name": "Juice", "unit": "each", "price": "2.00", "tax_category": "standard", "active": true,
+		"units": []map[string]interface{}{{"name": "case", "factor": 12}},
+	}
+	var juiceID, draft struct {
+		InsertedID string `json:"InsertedID"`
+	}
+	vic.expect("POST", "/product", juice, http.StatusCreated).decode(t, &juiceID)
+	vic.expect("POST", "/purchase-order", map[string]interface{}{
+		"supplier_id": supplier.InsertedID,
+		"store_id":    store.InsertedID,
+		"lines":       []map[string]interface{}{{"product_id": juiceID.InsertedID, "quantity": 1, "unit": "case", "unit_cost": "15.00"}},
+	}, http.StatusCreated).decode(t, &draft)
+	juice["unit"] = "bottle"
+	vic.expect("PUT", "/product?id="+juiceID.InsertedID, juice, http.StatusConflict)
+	vic.expect("POST", "/purchase-order/cancel?id="+draft.InsertedID, nil, http.StatusOK)
+	vic.expect("PUT", "/product?id="+juiceID.InsertedID, juice, http.StatusOK)
+
+	// Deleting a product is refused on the same grounds.
+	vic.expect("DELETE", "/product?id="+soda.InsertedID, nil, http.StatusConflict)
+	vic.expect("DELETE", "/product?id="+juiceID.InsertedID, nil, http.StatusNoContent)
+}
+
+func TestInvoices(t *testing.T) {
+	env := newTestEnv(t)
+	aliceID := env.signup("alice", "alice-pass", "customer", "+15550000001")
+	env.signup("bob", "bob-pass", "customer", "+15550000003")
+	env.signup("vic", "vic-pass", "vendor", "+15550000002")
+	alice := env.loginWithOTP("+15550000001")
+	bob := env.loginWithOTP("+15550000003")
+	vic := env.loginWithPassword("vic", "vic-pass")
+
+	var north, south, customer struct {
+		InsertedID string `json:"InsertedID"`
+	}
+	vic.expect("POST", "/store", map[string]string{"name": "North"}, http.StatusCreated).decode(t, &north)
+	vic.expect("POST", "/store", map[string]string{"name": "South"}, http.StatusCreated).decode(t, &south)
+	alice.expect("POST", "/customer", map[string]string{"user_id": aliceID, "first_name": "Alice", "last_name": "Adams"}, http.StatusCreated).decode(t, &customer)
+	tea := env.product("TEA-1", "Tea", "2.50")
+
+	deliveredOrder := func(store string, deliver bool) string {
+		t.Helper()
+		vic.expect("POST", "/stock/movements", map[string]interface{}{
+			"store_id": store, "product_id": tea, "kind": "receipt", "quantity": 2,
+		}, http.StatusCreated)
+		var created struct {
+			InsertedID string `json:"InsertedID"`
+		}
+		alice.expect("POST", "/order", map[string]interface{}{"product_id": tea, "quantity": 2, "store_id": store}, http.StatusCreated).decode(t, &created)
+		if deliver {
+			for _, to := range []string{"Confirmed", "Picking", "Shipped", "Delivered"} {
+				vic.expect("POST", "/order/transition?id="+created.InsertedID, map[string]string{"to": to}, http.StatusOK)
+			}
+		}
+		return created.InsertedID
+	}
+	type invoice struct {
+		ID       string `json:"id"`
+		Number   int64  `json:"number"`
+		Customer struct {
+			Name string `json:"name"`
+		} `json:"customer"`
+		Store struct {
+			Name string `json:"name"`
+		} `json:"store"`
+		GrandTotal string `json:"grand_total"`
+	}
+	issue := func(order string, status int) invoice {
+		t.Helper()
+		var got invoice
+		resp := vic.expect("POST", "/invoice?order_id="+order, nil, status)
+		if status == http.StatusCreated {
+			vic.expect("GET", resp.header.Get("Location"), nil, http.StatusOK).decode(t, &got)
+		}
+		return got
+	}
+
+	issue(deliveredOrder(north.InsertedID, false), http.StatusConflict)
+	first := deliveredOrder(north.InsertedID, true)
+	alice.expect("POST", "/invoice?order_id="+first, nil, http.StatusForbidden)
+	one := issue(first, http.StatusCreated)
+	if one.Number != 1 || one.Customer.Name != "Alice Adams" || one.Store.Name != "North" || one.GrandTotal == "" {
+		t.Fatalf("first invoice = %+v", one)
+	}
+	// A second attempt uses up no number, and each store counts separately.
+	issue(first, http.StatusConflict)
+	if two := issue(deliveredOrder(north.InsertedID, true), http.StatusCreated); two.Number != 2 {
+		t.Fatalf("second invoice at north = %+v", two)
+	}
+	if other := issue(deliveredOrder(south.InsertedID, true), http.StatusCreated); other.Number != 1 {
+		t.Fatalf("first invoice at south = %+v", other)
+	}
+
+	// Later edits don't reach issued invoices.
+	vic.expect("PUT", "/store?id="+north.InsertedID, map[string]string{"name": "North Renamed"}, http.StatusOK)
+	vic.expect("PUT", "/customer?id="+customer.InsertedID, map[string]string{"user_id": aliceID, "first_name": "Alicia", "last_name": "Adams"}, http.StatusOK)
+	var got invoice
+	alice.expect("GET", "/invoice?id="+one.ID, nil, http.StatusOK).decode(t, &got)
+	if got != one {
+		t.Fatalf("invoice after edits = %+v, issued as %+v", got, one)
+	}
+	bob.expect("GET", "/invoice?id="+one.ID, nil, http.StatusNotFound)
+	bob.expect("GET", "/invoice/pdf?id="+one.ID, nil, http.StatusNotFound)
+
+	resp := alice.expect("GET", "/invoice/pdf?id="+one.ID, nil, http.StatusOK)
+	if resp.header.Get("Content-Type") != "application/pdf" || !bytes.HasPrefix(resp.body, []byte("%PDF-")) ||
+		!bytes.HasSuffix(resp.body, []byte("%%EOF\n")) || !bytes.Contains(resp.body, []byte("(Alice Adams)")) {
+		t.Fatalf("invoice PDF: %s %q", resp.header.Get("Content-Type"), resp.body)
+	}
+
+	var invoices []invoice
+	vic.expect("GET", "/invoices?store_id="+north.InsertedID+"&sort=number", nil, http.StatusOK).decode(t, &invoices)
+	if len(invoices) != 2 || invoices[0].Number != 1 || invoices[1].Number != 2 {
+		t.Fatalf("north invoices = %+v", invoices)
+	}
+}
@@ -2,9 +2,11 @@ package middleware
 
 import (
 	"adonai-api/handlers"
+	"adonai-api/response"
 	"context"
+	"errors"
 	"net/http"
-	"strings"
+	"time"
 
 	"github.com/dgrijalva/jwt-go"
 )
@@ -14,50 +16,59 @@ func JwtAuthMiddleware(next http.Handler) http.Handler {
 		cookie, err := r.Cookie("token")
 		if err != nil {
 			if err == http.ErrNoCookie {
-				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				response.Unauthorized(w, r, "Unauthorized")
 				return
 			}
-			http.Error(w, "Bad request", http.StatusBadRequest)
+			response.BadRequest(w, r, "Malformed token cookie")
 			return
 		}
 
 		tokenStr := cookie.Value
 		claims := &handlers.Claims{}
 		token, err := jwt.ParseWithClaims(tokenStr, claims, func(token *jwt.Token) (interface{}, error) {
-			return handlers.JwtKey, nil
+			return handlers.JwtKey(), nil
 		})
 
 		if err != nil {
-			if err == jwt.ErrSignatureInvalid {
-				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			// jwt-go wraps every failure in a ValidationError. An expired or
+			// badly signed token is a 401, which tells clients to refresh;
+			// anything else couldn't have come from us.
+			var invalid *jwt.ValidationError
+			if errors.As(err, &invalid) && invalid.Errors&(jwt.ValidationErrorExpired|jwt.ValidationErrorSignatureInvalid) != 0 {
+				response.Unauthorized(w, r, "Unauthorized")
 				return
 			}
-			http.Error(w, "Bad request", http.StatusBadRequest)
+			response.BadRequest(w, r, "Malformed token")
 			return
 		}
 
 		if !token.Valid {
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			response.Unauthorized(w, r, "Unauthorized")
 			return
 		}
 
-		ctx := context.WithValue(r.Context(), "user", claims)
-		next.ServeHTTP(w, r.WithContext(ctx))
+		sessionCtx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+		defer cancel()
+		if !handlers.SessionActive(sessionCtx, claims.SessionID) {
+			response.Unauthorized(w, r, "Unauthorized")
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(handlers.WithClaims(r.Context(), claims)))
 	})
 }
 
 func RoleMiddleware(requiredRole string) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			userCtxValue := r.Context().Value("user")
-			if userCtxValue == nil {
-				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			userClaims, ok := handlers.ClaimsFromContext(r.Context())
+			if !ok {
+				response.Unauthorized(w, r, "Unauthorized")
 				return
 			}
 
-			userClaims := userCtxValue.(*handlers.Claims)
-			if !strings.EqualFold(userClaims.Role, requiredRole) {
-				http.Error(w, "Forbidden", http.StatusForbidden)
+			if !userClaims.HasRole(requiredRole) {
+				response.Forbidden(w, r)
 				return
 			}
 
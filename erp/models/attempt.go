@@ -0,0 +1,10 @@
+package models
+
+// AttemptCounter tracks failed authentication attempts for one key, such as
+// a phone number or client IP.
+type AttemptCounter struct {
+	Key         string `bson:"_id" json:"key"`
+	Count       int    `bson:"count" json:"count"`
+	WindowStart int64  `bson:"window_start" json:"window_start"`
+	LockedUntil int64  `bson:"locked_until" json:"locked_until"`
+}
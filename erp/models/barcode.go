@@ -0,0 +1,75 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+)
+
+// Barcode kinds. EAN-13 and UPC-A are retail GTINs with a check digit;
+// internal codes are whatever a store prints on its own labels.
+const (
+	BarcodeEAN13    = "ean13"
+	BarcodeUPCA     = "upca"
+	BarcodeInternal = "internal"
+)
+
+// Barcode is one code a product can be scanned by.
+type Barcode struct {
+	Code string `bson:"code" json:"code" validate:"required,max=48"`
+	Kind string `bson:"kind" json:"kind" validate:"required,oneof=ean13 upca internal"`
+}
+
+var (
+	digits       = regexp.MustCompile(`^[0-9]+$`)
+	internalCode = regexp.MustCompile(`^[A-Za-z0-9._/-]+$`)
+)
+
+// Check returns why the code isn't a valid barcode of its kind, or nil.
+func (b Barcode) Check() error {
+	switch b.Kind {
+	case BarcodeEAN13:
+		return checkGTIN(b.Code, 13)
+	case BarcodeUPCA:
+		return checkGTIN(b.Code, 12)
+	case BarcodeInternal:
+		if !internalCode.MatchString(b.Code) {
+			return errors.New("may only contain letters, digits and . _ / -")
+		}
+	}
+	return nil
+}
+
+// checkGTIN checks a GTIN's length and its final check digit: weighting
+// the other digits 3 and 1 alternately from the right, the total including
+// the check digit is a multiple of 10.
+func checkGTIN(code string, length int) error {
+	if len(code) != length || !digits.MatchString(code) {
+		return fmt.Errorf("must be %d digits", length)
+	}
+	sum := 0
+	for i := 0; i < length; i++ {
+		d := int(code[length-1-i] - '0')
+		if i%2 == 1 {
+			d *= 3
+		}
+		sum += d
+	}
+	if sum%10 != 0 {
+		return errors.New("has the wrong check digit")
+	}
+	return nil
+}
+
+// BarcodeVariants returns the codes a scan could be stored under. A UPC-A
+// is the same GTIN as the EAN-13 with a leading zero, and scanners report
+// either, so each finds the other.
+func BarcodeVariants(code string) []string {
+	switch {
+	case len(code) == 12 && digits.MatchString(code):
+		return []string{code, "0" + code}
+	case len(code) == 13 && code[0] == '0' && digits.MatchString(code):
+		return []string{code, code[1:]}
+	}
+	return []string{code}
+}
@@ -10,7 +10,7 @@ type Message struct {
 	ID         primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
 	FromUserID primitive.ObjectID `bson:"from_user_id,omitempty" json:"from_user_id,omitempty"`
 	ToAdmin    bool               `bson:"to_admin,omitempty" json:"to_admin,omitempty"`
-	Content    string             `bson:"content" json:"content"`
+	Content    string             `bson:"content" json:"content" validate:"required"`
 	Timestamp  time.Time          `bson:"timestamp" json:"timestamp"`
 }
 
@@ -24,6 +24,6 @@ type Chat struct {
 type BroadcastMessage struct {
 	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
 	AdminID   primitive.ObjectID `bson:"admin_id,omitempty" json:"admin_id,omitempty"`
-	Content   string             `bson:"content" json:"content"`
+	Content   string             `bson:"content" json:"content" validate:"required"`
 	Timestamp time.Time          `bson:"timestamp" json:"timestamp"`
 }
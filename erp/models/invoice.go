@@ -0,0 +1,40 @@
+package models
+
+import "go.mongodb.org/mongo-driver/bson/primitive"
+
+// Invoice is the bill for a delivered order. Each store numbers its
+// invoices 1, 2, 3... with no gaps. Once issued an invoice never changes:
+// the customer, store and lines are copied onto it so later edits to them
+// don't alter it.
+type Invoice struct {
+	ID            primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
+	StoreID       primitive.ObjectID `bson:"store_id" json:"store_id"`
+	Number        int64              `bson:"number" json:"number"` // per store
+	OrderID       primitive.ObjectID `bson:"order_id" json:"order_id"`
+	Customer      InvoiceCustomer    `bson:"customer" json:"customer"`
+	Store         InvoiceStore       `bson:"store" json:"store"`
+	Lines         []OrderLine        `bson:"lines" json:"lines"`
+	Subtotal      Money              `bson:"subtotal" json:"subtotal"`
+	DiscountTotal Money              `bson:"discount_total" json:"discount_total"`
+	TaxTotal      Money              `bson:"tax_total" json:"tax_total"`
+	GrandTotal    Money              `bson:"grand_total" json:"grand_total"`
+	OrderedAt     int64              `bson:"ordered_at" json:"ordered_at"`
+	IssuedBy      primitive.ObjectID `bson:"issued_by" json:"issued_by"`
+	IssuedAt      int64              `bson:"issued_at" json:"issued_at"`
+}
+
+// InvoiceCustomer is who was billed, as they were when the invoice was
+// issued. CustomerID is empty if the user had no customer record.
+type InvoiceCustomer struct {
+	UserID      primitive.ObjectID `bson:"user_id" json:"user_id"`
+	CustomerID  primitive.ObjectID `bson:"customer_id,omitempty" json:"customer_id,omitempty"`
+	Name        string             `bson:"name" json:"name"`
+	PhoneNumber string             `bson:"phone_number,omitempty" json:"phone_number,omitempty"`
+}
+
+// InvoiceStore is the store that sold the order, as it was when the invoice
+// was issued.
+type InvoiceStore struct {
+	Name        string `bson:"name" json:"name"`
+	PhoneNumber string `bson:"phone_number,omitempty" json:"phone_number,omitempty"`
+}
@@ -0,0 +1,95 @@
+package models
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Money is an amount in minor units (cents), so sums and comparisons are
+// exact. It is stored in MongoDB as an integer and written in JSON as a
+// decimal string such as "12.50"; JSON input may be a string or a number.
+type Money int64
+
+const minorPerMajor = 100
+
+// ParseMoney reads a decimal amount with at most two fractional digits,
+// such as "12", "12.5" or "-0.99".
+func ParseMoney(s string) (Money, error) {
+	s = strings.TrimSpace(s)
+	negative := strings.HasPrefix(s, "-")
+	digits := strings.TrimPrefix(strings.TrimPrefix(s, "-"), "+")
+
+	whole, frac, hasFrac := strings.Cut(digits, ".")
+	if whole == "" || (hasFrac && frac == "") || len(frac) > 2 {
+		return 0, fmt.Errorf("invalid amount %q: use up to two decimal places", s)
+	}
+	for len(frac) < 2 {
+		frac += "0"
+	}
+	for _, part := range []string{whole, frac} {
+		for _, c := range part {
+			if c < '0' || c > '9' {
+				return 0, fmt.Errorf("invalid amount %q", s)
+			}
+		}
+	}
+
+	major, err := strconv.ParseInt(whole, 10, 64)
+	if err != nil || major > math.MaxInt64/minorPerMajor-1 {
+		return 0, fmt.Errorf("amount %q is out of range", s)
+	}
+	minor, _ := strconv.ParseInt(frac, 10, 64)
+	m := Money(major*minorPerMajor + minor)
+	if negative {
+		m = -m
+	}
+	return m, nil
+}
+
+// Times returns m multiplied by a quantity.
+func (m Money) Times(quantity int) Money {
+	return m * Money(quantity)
+}
+
+// Rate returns m multiplied by a rate in basis points (725 is 7.25%),
+// rounded half away from zero to the nearest minor unit.
+func (m Money) Rate(basisPoints int64) Money {
+	product := int64(m) * basisPoints
+	q, r := product/10000, product%10000
+	if r >= 5000 {
+		q++
+	} else if r <= -5000 {
+		q--
+	}
+	return Money(q)
+}
+
+func (m Money) String() string {
+	sign := ""
+	v := int64(m)
+	if v < 0 {
+		sign, v = "-", -v
+	}
+	return fmt.Sprintf("%s%d.%02d", sign, v/minorPerMajor, v%minorPerMajor)
+}
+
+func (m Money) MarshalJSON() ([]byte, error) {
+	return json.Marshal(m.String())
+}
+
+func (m *Money) UnmarshalJSON(data []byte) error {
+	s := string(bytes.Trim(data, `"`))
+	if s == "null" {
+		return nil
+	}
+	parsed, err := ParseMoney(s)
+	if err != nil {
+		return err
+	}
+	*m = parsed
+	return nil
+}
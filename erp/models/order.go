@@ -3,11 +3,67 @@ package models
 import "go.mongodb.org/mongo-driver/bson/primitive"
 
 type Order struct {
-	ID           primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
-	UserID       primitive.ObjectID `bson:"user_id,omitempty" json:"user_id,omitempty"`
-	StoreID      primitive.ObjectID `bson:"store_id,omitempty" json:"store_id,omitempty"`
-	Product      string             `bson:"product" json:"product"`
-	Quantity     int                `bson:"quantity" json:"quantity"`
-	OrderStatus  string             `bson:"order_status" json:"order_status"` // Pending, Delivered, Cancelled
-	CreationDate int64              `bson:"creation_date" json:"creation_date"`
+	ID            primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
+	UserID        primitive.ObjectID `bson:"user_id,omitempty" json:"user_id,omitempty"`
+	StoreID       primitive.ObjectID `bson:"store_id,omitempty" json:"store_id,omitempty"`
+	Lines         []OrderLine        `bson:"lines,omitempty" json:"lines,omitempty"`
+	Subtotal      Money              `bson:"subtotal" json:"subtotal"` // sum of quantity × unit price
+	DiscountTotal Money              `bson:"discount_total" json:"discount_total"`
+	TaxTotal      Money              `bson:"tax_total" json:"tax_total"`
+	GrandTotal    Money              `bson:"grand_total" json:"grand_total"`   // subtotal - discounts + tax
+	OrderStatus   string             `bson:"order_status" json:"order_status"` // one of the Status constants
+	StatusHistory []StatusChange     `bson:"status_history,omitempty" json:"status_history,omitempty"`
+	CreationDate  int64              `bson:"creation_date" json:"creation_date"`
+
+	// Orders placed before orders had lines name a single product.
+	ProductID primitive.ObjectID `bson:"product_id,omitempty" json:"product_id,omitempty"`
+	Product   string             `bson:"product,omitempty" json:"product,omitempty"`
+	Quantity  int                `bson:"quantity,omitempty" json:"quantity,omitempty"`
+}
+
+// OrderLine is one product on an order. Everything but the product,
+// quantity, unit and discount is copied from the catalog when the order is
+// placed, so later price, tax or pack size changes don't alter it.
+type OrderLine struct {
+	ProductID   primitive.ObjectID `bson:"product_id" json:"product_id"`
+	SKU         string             `bson:"sku" json:"sku"`
+	Name        string             `bson:"name" json:"name"`
+	Unit        string             `bson:"unit" json:"unit"`     // what the quantity counts, the base unit or a pack
+	Factor      int                `bson:"factor" json:"factor"` // base units per unit
+	Quantity    int                `bson:"quantity" json:"quantity"`
+	UnitPrice   Money              `bson:"unit_price" json:"unit_price"` // per unit
+	Discount    Money              `bson:"discount" json:"discount"`     // amount off the whole line, before tax
+	TaxCategory string             `bson:"tax_category" json:"tax_category"`
+	TaxRate     int64              `bson:"tax_rate" json:"tax_rate"` // basis points: 725 is 7.25%
+	Subtotal    Money              `bson:"subtotal" json:"subtotal"` // quantity × unit price
+	Tax         Money              `bson:"tax" json:"tax"`
+	Total       Money              `bson:"total" json:"total"` // subtotal - discount + tax
+}
+
+// BaseQuantity returns the line's quantity in the product's base unit,
+// which is what its stock movements count. Lines from before orders had
+// units have no factor and are already in the base unit.
+func (l *OrderLine) BaseQuantity() int {
+	return l.Quantity * max(l.Factor, 1)
+}
+
+// Price computes the line's subtotal, tax and total from its quantity, unit
+// price, discount and tax rate.
+func (l *OrderLine) Price() {
+	l.Subtotal = l.UnitPrice.Times(l.Quantity)
+	l.Tax = (l.Subtotal - l.Discount).Rate(l.TaxRate)
+	l.Total = l.Subtotal - l.Discount + l.Tax
+}
+
+// Recalculate prices every line and sums them into the order's totals.
+func (o *Order) Recalculate() {
+	o.Subtotal, o.DiscountTotal, o.TaxTotal, o.GrandTotal = 0, 0, 0, 0
+	for i := range o.Lines {
+		line := &o.Lines[i]
+		line.Price()
+		o.Subtotal += line.Subtotal
+		o.DiscountTotal += line.Discount
+		o.TaxTotal += line.Tax
+		o.GrandTotal += line.Total
+	}
 }
@@ -0,0 +1,82 @@
+package models
+
+import (
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Order statuses. An order moves forward through
+// Draft → Placed → Confirmed → Picking → Shipped → Delivered, can be
+// Cancelled until it ships and Returned once delivered.
+const (
+	StatusDraft     = "Draft"
+	StatusPlaced    = "Placed"
+	StatusConfirmed = "Confirmed"
+	StatusPicking   = "Picking"
+	StatusShipped   = "Shipped"
+	StatusDelivered = "Delivered"
+	StatusCancelled = "Cancelled"
+	StatusReturned  = "Returned"
+
+	// statusPending is what orders were created with before the lifecycle
+	// existed; it means Placed.
+	statusPending = "Pending"
+)
+
+// StatusChange is one entry in an order's status history.
+type StatusChange struct {
+	From  string             `bson:"from,omitempty" json:"from,omitempty"` // empty when the order was created
+	To    string             `bson:"to" json:"to"`
+	Actor primitive.ObjectID `bson:"actor" json:"actor"`
+	Role  string             `bson:"role" json:"role"`
+	At    int64              `bson:"at" json:"at"`
+	Note  string             `bson:"note,omitempty" json:"note,omitempty"`
+}
+
+// orderTransitions lists, for each status, the statuses it may move to and
+// the roles allowed to move it there. Customers act only on their own orders.
+var orderTransitions = map[string]map[string][]string{
+	StatusDraft: {
+		StatusPlaced:    {"customer", "vendor", "admin"},
+		StatusCancelled: {"customer", "vendor", "admin"},
+	},
+	StatusPlaced: {
+		StatusConfirmed: {"vendor", "admin"},
+		StatusCancelled: {"customer", "vendor", "admin"},
+	},
+	StatusConfirmed: {
+		StatusPicking:   {"vendor", "admin"},
+		StatusCancelled: {"vendor", "admin"},
+	},
+	StatusPicking: {
+		StatusShipped:   {"vendor", "admin"},
+		StatusCancelled: {"vendor", "admin"},
+	},
+	StatusShipped: {
+		StatusDelivered: {"vendor", "admin"},
+	},
+	StatusDelivered: {
+		StatusReturned: {"vendor", "admin"},
+	},
+}
+
+// NormalizeStatus maps legacy status names onto the lifecycle.
+func NormalizeStatus(status string) string {
+	if status == statusPending {
+		return StatusPlaced
+	}
+	return status
+}
+
+// CanTransition reports whether an order may move from one status to
+// another at all, and whether role may make that move.
+func CanTransition(from, to, role string) (allowed, permitted bool) {
+	roles, allowed := orderTransitions[NormalizeStatus(from)][to]
+	for _, r := range roles {
+		if strings.EqualFold(r, role) {
+			return true, true
+		}
+	}
+	return allowed, false
+}
@@ -0,0 +1,95 @@
+package models
+
+import "go.mongodb.org/mongo-driver/bson/primitive"
+
+// Product is an item in the catalog that orders refer to by ID.
+type Product struct {
+	ID           primitive.ObjectID  `bson:"_id,omitempty" json:"id,omitempty"`
+	SKU          string              `bson:"sku" json:"sku" validate:"required,max=64"`
+	Name         string              `bson:"name" json:"name" validate:"required,max=200"`
+	Description  string              `bson:"description" json:"description" validate:"max=2000"`
+	Unit         string              `bson:"unit" json:"unit" validate:"required,max=32"` // the base unit stock is kept in: "each", "kg"
+	Units        []UnitOfMeasure     `bson:"units" json:"units"`                          // other units it is bought and sold in
+	Price        Money               `bson:"price" json:"price" validate:"min=0"`         // per base unit, before tax
+	TaxCategory  string              `bson:"tax_category" json:"tax_category" validate:"required,max=64"`
+	Active       bool                `bson:"active" json:"active"`
+	Barcodes     []Barcode           `bson:"barcodes" json:"barcodes"`
+	Availability []StoreAvailability `bson:"availability" json:"availability"`
+	CreatedAt    int64               `bson:"created_at,omitempty" json:"created_at"` // omitempty keeps updates from clearing it
+	UpdatedAt    int64               `bson:"updated_at" json:"updated_at"`
+}
+
+// UnitOfMeasure is a pack a product comes in, holding Factor of its base
+// unit: {Name: "case", Factor: 24} for a case of 24. Price, if set, is the
+// pack's own price in place of Factor times the base price.
+type UnitOfMeasure struct {
+	Name   string `bson:"name" json:"name" validate:"required,max=32"`
+	Factor int    `bson:"factor" json:"factor" validate:"min=1"`
+	Price  Money  `bson:"price,omitempty" json:"price,omitempty" validate:"min=0"`
+}
+
+// InUnit returns how many base units one of unit holds and what it costs.
+// The base unit, or no unit at all, is 1 at the base price; ok is false
+// for a unit the product doesn't come in.
+func (p *Product) InUnit(unit string) (factor int, price Money, ok bool) {
+	if unit == "" || unit == p.Unit {
+		return 1, p.Price, true
+	}
+	for _, u := range p.Units {
+		if u.Name == unit {
+			if u.Price == 0 {
+				return u.Factor, p.Price.Times(u.Factor), true
+			}
+			return u.Factor, u.Price, true
+		}
+	}
+	return 0, 0, false
+}
+
+// UnitsChanged reports whether p measures stock differently from was: a
+// different base unit, or a different factor for a unit both have. Adding,
+// removing or repricing a pack doesn't count.
+func (p *Product) UnitsChanged(was *Product) bool {
+	if p.Unit != was.Unit {
+		return true
+	}
+	for _, u := range p.Units {
+		if factor, _, ok := was.InUnit(u.Name); ok && factor != u.Factor {
+			return true
+		}
+	}
+	return false
+}
+
+// UnitNames lists the units the product can be given in, base unit first.
+func (p *Product) UnitNames() []string {
+	names := []string{p.Unit}
+	for _, u := range p.Units {
+		names = append(names, u.Name)
+	}
+	return names
+}
+
+// StoreAvailability says whether one store currently sells a product.
+type StoreAvailability struct {
+	StoreID   primitive.ObjectID `bson:"store_id" json:"store_id" validate:"required"`
+	Available bool               `bson:"available" json:"available"`
+}
+
+// AvailableAt reports whether the product can be ordered from store. An
+// active product with no availability entries is sold everywhere; once any
+// store is listed, only stores listed as available sell it.
+func (p *Product) AvailableAt(store primitive.ObjectID) bool {
+	if !p.Active {
+		return false
+	}
+	if len(p.Availability) == 0 || store.IsZero() {
+		return true
+	}
+	for _, a := range p.Availability {
+		if a.StoreID == store {
+			return a.Available
+		}
+	}
+	return false
+}
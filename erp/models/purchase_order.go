@@ -0,0 +1,106 @@
+package models
+
+import "go.mongodb.org/mongo-driver/bson/primitive"
+
+// Purchase order statuses. A draft can be edited; once approved it can only
+// be received against, which moves it to partially_received and then
+// received, or cancelled.
+const (
+	PODraft             = "draft"
+	POApproved          = "approved"
+	POPartiallyReceived = "partially_received"
+	POReceived          = "received"
+	POCancelled         = "cancelled"
+)
+
+// PurchaseOrder is stock a store has ordered from a supplier.
+type PurchaseOrder struct {
+	ID          primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
+	SupplierID  primitive.ObjectID `bson:"supplier_id" json:"supplier_id"`
+	StoreID     primitive.ObjectID `bson:"store_id" json:"store_id"`
+	Status      string             `bson:"status" json:"status"`
+	Lines       []PurchaseLine     `bson:"lines" json:"lines"`
+	Total       Money              `bson:"total" json:"total"`             // at cost
+	Outstanding int                `bson:"outstanding" json:"outstanding"` // base units approved but not yet received
+	Note        string             `bson:"note,omitempty" json:"note,omitempty"`
+	Receipts    []PurchaseReceipt  `bson:"receipts" json:"receipts"`
+	CreatedBy   primitive.ObjectID `bson:"created_by" json:"created_by"`
+	CreatedAt   int64              `bson:"created_at" json:"created_at"`
+	ApprovedBy  primitive.ObjectID `bson:"approved_by,omitempty" json:"approved_by,omitempty"`
+	ApprovedAt  int64              `bson:"approved_at,omitempty" json:"approved_at,omitempty"`
+	UpdatedAt   int64              `bson:"updated_at" json:"updated_at"`
+}
+
+// PurchaseLine is one product on a purchase order. Name, SKU and the
+// unit's factor are copied from the catalog when the line is added. The
+// quantities and cost are per unit; stock receipts are in base units.
+type PurchaseLine struct {
+	ProductID   primitive.ObjectID `bson:"product_id" json:"product_id"`
+	SKU         string             `bson:"sku" json:"sku"`
+	Name        string             `bson:"name" json:"name"`
+	Unit        string             `bson:"unit" json:"unit"`
+	Factor      int                `bson:"factor" json:"factor"` // base units per unit
+	Quantity    int                `bson:"quantity" json:"quantity"`
+	UnitCost    Money              `bson:"unit_cost" json:"unit_cost"`
+	Total       Money              `bson:"total" json:"total"`
+	Received    int                `bson:"received" json:"received"`
+	Outstanding int                `bson:"outstanding" json:"outstanding"` // in Unit
+}
+
+// PurchaseReceipt is one delivery received against a purchase order.
+type PurchaseReceipt struct {
+	Lines      []ReceiptLine      `bson:"lines" json:"lines"`
+	Note       string             `bson:"note,omitempty" json:"note,omitempty"`
+	ReceivedBy primitive.ObjectID `bson:"received_by" json:"received_by"`
+	ReceivedAt int64              `bson:"received_at" json:"received_at"`
+}
+
+type ReceiptLine struct {
+	ProductID primitive.ObjectID `bson:"product_id" json:"product_id"`
+	Quantity  int                `bson:"quantity" json:"quantity"`
+	Lot       string             `bson:"lot,omitempty" json:"lot,omitempty"`
+	ExpiresAt int64              `bson:"expires_at,omitempty" json:"expires_at,omitempty"`
+}
+
+// Awaits reports whether the order is still to bring in the product: it is
+// a draft with a line for it, or its line for it has some outstanding.
+func (po *PurchaseOrder) Awaits(productID primitive.ObjectID) bool {
+	for _, line := range po.Lines {
+		if line.ProductID == productID && (po.Status == PODraft || line.Outstanding > 0) {
+			return true
+		}
+	}
+	return false
+}
+
+// Receivable reports whether goods can be received against the order.
+func (po *PurchaseOrder) Receivable() bool {
+	return po.Status == POApproved || po.Status == POPartiallyReceived
+}
+
+// Recalculate refreshes the totals and outstanding quantities, and moves
+// an order being received to partially_received or received. Lines are
+// outstanding in their own unit and the order in base units, so an order
+// for cases and single cans adds up. Lines from before purchase orders had
+// units have no factor and are already in the base unit.
+func (po *PurchaseOrder) Recalculate() {
+	po.Total, po.Outstanding = 0, 0
+	anyReceived := false
+	for i := range po.Lines {
+		line := &po.Lines[i]
+		line.Total = line.UnitCost.Times(line.Quantity)
+		line.Outstanding = 0
+		if po.Receivable() && line.Received < line.Quantity {
+			line.Outstanding = line.Quantity - line.Received
+		}
+		po.Total += line.Total
+		po.Outstanding += line.Outstanding * max(line.Factor, 1)
+		anyReceived = anyReceived || line.Received > 0
+	}
+	switch {
+	case po.Receivable() && po.Outstanding == 0:
+		po.Status = POReceived
+	case po.Receivable() && anyReceived:
+		po.Status = POPartiallyReceived
+	}
+}
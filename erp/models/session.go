@@ -0,0 +1,23 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Session is one login and every refresh token rotated out of it (a token family).
+type Session struct {
+	ID            primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
+	UserID        primitive.ObjectID `bson:"user_id" json:"user_id"`
+	RefreshHash   string             `bson:"refresh_hash" json:"-"`
+	UsedHashes    []string           `bson:"used_hashes" json:"-"` // rotated-out refresh tokens, kept to detect reuse
+	UserAgent     string             `bson:"user_agent" json:"user_agent"`
+	IPAddress     string             `bson:"ip_address" json:"ip_address"`
+	CreatedAt     int64              `bson:"created_at" json:"created_at"`
+	LastUsedAt    int64              `bson:"last_used_at" json:"last_used_at"`
+	ExpiresAt     int64              `bson:"expires_at" json:"expires_at"`
+	PurgeAt       time.Time          `bson:"purge_at" json:"-"` // ExpiresAt as a date, which MongoDB's TTL index needs
+	RevokedAt     int64              `bson:"revoked_at,omitempty" json:"revoked_at,omitempty"`
+	RevokedReason string             `bson:"revoked_reason,omitempty" json:"revoked_reason,omitempty"`
+}
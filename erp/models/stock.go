@@ -0,0 +1,218 @@
+package models
+
+import (
+	"sort"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Stock movement kinds. Reserve and release move stock in and out of the
+// reserved bucket; every other kind changes what is on hand. A count
+// adjustment is the correction an approved stocktake makes.
+const (
+	MoveReceipt         = "receipt"
+	MoveAdjustment      = "adjustment"
+	MoveSale            = "sale"
+	MoveReturn          = "return"
+	MoveReserve         = "reserve"
+	MoveRelease         = "release"
+	MoveTransferOut     = "transfer_out"
+	MoveTransferIn      = "transfer_in"
+	MoveCountAdjustment = "count_adjustment"
+)
+
+// StockMovement is one immutable entry in a store's stock ledger. Quantity
+// is signed and in the product's base unit: a sale of 3 is -3, a release of
+// 3 reserved units is -3. A movement entered in a pack unit also records
+// the unit and how many of it, so a sale of 2 cases of 24 is -48 each. A
+// receipt with a lot number or expiry date starts a lot; stock leaving a
+// store is taken from its lots and names the lot it came from. A transfer-in
+// names the sending store's lot as its origin, so the lot carries on at the
+// receiving store.
+type StockMovement struct {
+	ID           primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
+	StoreID      primitive.ObjectID `bson:"store_id" json:"store_id"`
+	ProductID    primitive.ObjectID `bson:"product_id" json:"product_id"`
+	Kind         string             `bson:"kind" json:"kind"`
+	Quantity     int                `bson:"quantity" json:"quantity"`
+	Unit         string             `bson:"unit,omitempty" json:"unit,omitempty"`
+	UnitQuantity int                `bson:"unit_quantity,omitempty" json:"unit_quantity,omitempty"` // Quantity in Unit, signed the same way
+	Source       string             `bson:"source,omitempty" json:"source,omitempty"`               // what caused it, such as "order"
+	SourceID     primitive.ObjectID `bson:"source_id,omitempty" json:"source_id,omitempty"`
+	LotID        primitive.ObjectID `bson:"lot_id,omitempty" json:"lot_id,omitempty"`
+	Lot          string             `bson:"lot,omitempty" json:"lot,omitempty"`
+	ExpiresAt    int64              `bson:"expires_at,omitempty" json:"expires_at,omitempty"`
+	OriginLotID  primitive.ObjectID `bson:"origin_lot_id,omitempty" json:"origin_lot_id,omitempty"` // transfer-ins: the sending store's lot
+	Counted      *int               `bson:"counted,omitempty" json:"counted,omitempty"`             // count adjustments: the on-hand quantity counted
+	Note         string             `bson:"note,omitempty" json:"note,omitempty"`
+	Actor        primitive.ObjectID `bson:"actor,omitempty" json:"actor,omitempty"`
+	CreatedAt    int64              `bson:"created_at" json:"created_at"`
+}
+
+// Effect returns how much the movement changes on-hand and reserved stock.
+func (m *StockMovement) Effect() (onHand, reserved int) {
+	if m.Kind == MoveReserve || m.Kind == MoveRelease {
+		return 0, m.Quantity
+	}
+	return m.Quantity, 0
+}
+
+// Takes returns how much the movement lowers available stock, or zero if
+// it doesn't. Only movements that take stock can fail for lack of it. A
+// count adjustment never does: it records what is physically there, even
+// if that is less than open orders have reserved.
+func (m *StockMovement) Takes() int {
+	if m.Kind == MoveCountAdjustment {
+		return 0
+	}
+	onHand, reserved := m.Effect()
+	if taken := reserved - onHand; taken > 0 {
+		return taken
+	}
+	return 0
+}
+
+// SetCounted makes the movement a count adjustment to counted on hand.
+// Its quantity is only worked out when it is posted, against what is on
+// hand then, so movements posted between the count and its approval are
+// neither lost nor counted twice.
+func (m *StockMovement) SetCounted(counted int) {
+	m.Kind, m.Counted = MoveCountAdjustment, &counted
+}
+
+// ResolveCount sets a count adjustment's quantity to bring onHand to what
+// was counted, reporting false if nothing needs to change. Any other
+// movement is left as it is.
+func (m *StockMovement) ResolveCount(onHand int) bool {
+	if m.Counted == nil {
+		return true
+	}
+	m.Quantity = *m.Counted - onHand
+	return m.Quantity != 0
+}
+
+// SetQuantity sets the movement's quantity from quantity of a unit holding
+// factor base units, recording the unit if it isn't the base unit.
+func (m *StockMovement) SetQuantity(quantity int, unit string, factor int) {
+	m.Quantity = quantity * factor
+	m.Unit, m.UnitQuantity = "", 0
+	if factor != 1 {
+		m.Unit, m.UnitQuantity = unit, quantity
+	}
+}
+
+// StartsLot reports whether the movement brings stock into a lot: a receipt
+// with a lot number or expiry date, or a transfer-in of stock that left the
+// sending store from a lot. A transfer-in adds to the lot at the receiving
+// store that stock from the same origin is already in, if there is one.
+func (m *StockMovement) StartsLot() bool {
+	if !m.LotID.IsZero() {
+		return false
+	}
+	switch m.Kind {
+	case MoveReceipt:
+		return m.Lot != "" || m.ExpiresAt != 0
+	case MoveTransferIn:
+		return !m.OriginLotID.IsZero()
+	}
+	return false
+}
+
+// StockLevel is the running total of a product's movements at one store.
+// Available is what can still be sold: on hand less what open orders have
+// reserved.
+type StockLevel struct {
+	ID           primitive.ObjectID `bson:"_id,omitempty" json:"-"`
+	StoreID      primitive.ObjectID `bson:"store_id" json:"store_id"`
+	ProductID    primitive.ObjectID `bson:"product_id" json:"product_id"`
+	OnHand       int                `bson:"on_hand" json:"on_hand"`
+	Reserved     int                `bson:"reserved" json:"reserved"`
+	Available    int                `bson:"available" json:"available"`
+	ReorderPoint int                `bson:"reorder_point" json:"reorder_point"` // alert when available falls below it; 0 never alerts
+	UpdatedAt    int64              `bson:"updated_at" json:"updated_at"`
+}
+
+// BelowReorderPoint reports whether the level should raise an alert.
+func (l *StockLevel) BelowReorderPoint() bool {
+	return l.Available < l.ReorderPoint
+}
+
+// Apply adds a movement to the level.
+func (l *StockLevel) Apply(m *StockMovement) {
+	onHand, reserved := m.Effect()
+	l.OnHand += onHand
+	l.Reserved += reserved
+	l.Available = l.OnHand - l.Reserved
+	l.UpdatedAt = m.CreatedAt
+}
+
+// StockAlert records that a product's available stock at a store fell below
+// its reorder point. It stays open until stock is back at or above the
+// point; acknowledging it only records that someone is dealing with it.
+type StockAlert struct {
+	ID             primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
+	StoreID        primitive.ObjectID `bson:"store_id" json:"store_id"`
+	ProductID      primitive.ObjectID `bson:"product_id" json:"product_id"`
+	Available      int                `bson:"available" json:"available"` // when raised
+	ReorderPoint   int                `bson:"reorder_point" json:"reorder_point"`
+	Open           bool               `bson:"open" json:"open"`
+	RaisedAt       int64              `bson:"raised_at" json:"raised_at"`
+	NotifiedAt     int64              `bson:"notified_at,omitempty" json:"notified_at,omitempty"`
+	AcknowledgedBy primitive.ObjectID `bson:"acknowledged_by,omitempty" json:"acknowledged_by,omitempty"`
+	AcknowledgedAt int64              `bson:"acknowledged_at,omitempty" json:"acknowledged_at,omitempty"`
+	ResolvedAt     int64              `bson:"resolved_at,omitempty" json:"resolved_at,omitempty"`
+}
+
+// StockLot is one receipt of a product at a store under a lot number, an
+// expiry date or both. Source and SourceID are the receipt's, so a lot
+// received against a purchase order leads back to the supplier. A lot that
+// reached the store by transfer has the sending store's lot as its origin,
+// and following origins leads back to the receipt.
+type StockLot struct {
+	ID          primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
+	StoreID     primitive.ObjectID `bson:"store_id" json:"store_id"`
+	ProductID   primitive.ObjectID `bson:"product_id" json:"product_id"`
+	Lot         string             `bson:"lot,omitempty" json:"lot,omitempty"`
+	ExpiresAt   int64              `bson:"expires_at,omitempty" json:"expires_at,omitempty"`
+	Received    int                `bson:"received" json:"received"`
+	Remaining   int                `bson:"remaining" json:"remaining"`
+	Source      string             `bson:"source,omitempty" json:"source,omitempty"`
+	SourceID    primitive.ObjectID `bson:"source_id,omitempty" json:"source_id,omitempty"`
+	OriginLotID primitive.ObjectID `bson:"origin_lot_id,omitempty" json:"origin_lot_id,omitempty"`
+	MovementID  primitive.ObjectID `bson:"movement_id" json:"movement_id"`
+	ReceivedAt  int64              `bson:"received_at" json:"received_at"`
+}
+
+// NewStockLot starts a lot from the receipt or transfer-in that brings it
+// in.
+func NewStockLot(receipt *StockMovement) *StockLot {
+	return &StockLot{
+		ID:          primitive.NewObjectID(),
+		StoreID:     receipt.StoreID,
+		ProductID:   receipt.ProductID,
+		Lot:         receipt.Lot,
+		ExpiresAt:   receipt.ExpiresAt,
+		Received:    receipt.Quantity,
+		Remaining:   receipt.Quantity,
+		Source:      receipt.Source,
+		SourceID:    receipt.SourceID,
+		OriginLotID: receipt.OriginLotID,
+		MovementID:  receipt.ID,
+		ReceivedAt:  receipt.CreatedAt,
+	}
+}
+
+// SortFEFO orders lots first-expiry-first-out: the soonest expiry first,
+// lots that never expire last, and the oldest receipt first among equals.
+func SortFEFO(lots []*StockLot) {
+	sort.SliceStable(lots, func(i, j int) bool {
+		a, b := lots[i], lots[j]
+		if a.ExpiresAt != b.ExpiresAt {
+			return b.ExpiresAt == 0 || (a.ExpiresAt != 0 && a.ExpiresAt < b.ExpiresAt)
+		}
+		if a.ReceivedAt != b.ReceivedAt {
+			return a.ReceivedAt < b.ReceivedAt
+		}
+		return a.ID.Hex() < b.ID.Hex()
+	})
+}
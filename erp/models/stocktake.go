@@ -0,0 +1,95 @@
+package models
+
+import "go.mongodb.org/mongo-driver/bson/primitive"
+
+// Stocktake statuses.
+const (
+	StocktakeOpen      = "open"
+	StocktakeApproved  = "approved"
+	StocktakeCancelled = "cancelled"
+)
+
+// Stocktake is a count of a store's stock. Opening it snapshots what the
+// ledger expects on hand; staff then submit counts, and approving it posts
+// a count adjustment for every counted line that differs.
+type Stocktake struct {
+	ID            primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
+	StoreID       primitive.ObjectID `bson:"store_id" json:"store_id"`
+	Status        string             `bson:"status" json:"status"`
+	Lines         []StocktakeLine    `bson:"lines" json:"lines"`
+	Uncounted     int                `bson:"uncounted" json:"uncounted"`         // lines nobody has counted yet
+	Discrepancies int                `bson:"discrepancies" json:"discrepancies"` // counted lines whose variance isn't zero
+	Note          string             `bson:"note,omitempty" json:"note,omitempty"`
+	OpenedBy      primitive.ObjectID `bson:"opened_by" json:"opened_by"`
+	OpenedAt      int64              `bson:"opened_at" json:"opened_at"`
+	ApprovedBy    primitive.ObjectID `bson:"approved_by,omitempty" json:"approved_by,omitempty"`
+	ApprovedAt    int64              `bson:"approved_at,omitempty" json:"approved_at,omitempty"`
+	UpdatedAt     int64              `bson:"updated_at" json:"updated_at"`
+}
+
+// StocktakeLine is one product being counted. Counted is the sum of every
+// device's count, so staff can split a store between them; Variance is
+// counted less expected. Approval adjusts to the count from what is on hand
+// at the time, which differs from the variance if stock moved meanwhile.
+type StocktakeLine struct {
+	ProductID primitive.ObjectID `bson:"product_id" json:"product_id"`
+	SKU       string             `bson:"sku" json:"sku"`
+	Name      string             `bson:"name" json:"name"`
+	Expected  int                `bson:"expected" json:"expected"`
+	Counts    []StocktakeCount   `bson:"counts" json:"counts"`
+	Counted   int                `bson:"counted" json:"counted"`
+	Variance  int                `bson:"variance" json:"variance"`
+}
+
+// StocktakeCount is one device's count of a line, in the product's base
+// unit. A count given in a pack unit also records the unit and how many of
+// it. A device submitting again replaces its earlier count.
+type StocktakeCount struct {
+	Device       string             `bson:"device" json:"device"`
+	Quantity     int                `bson:"quantity" json:"quantity"`
+	Unit         string             `bson:"unit,omitempty" json:"unit,omitempty"`
+	UnitQuantity int                `bson:"unit_quantity,omitempty" json:"unit_quantity,omitempty"` // Quantity in Unit
+	CountedBy    primitive.ObjectID `bson:"counted_by" json:"counted_by"`
+	CountedAt    int64              `bson:"counted_at" json:"counted_at"`
+}
+
+// SetQuantity sets the count from quantity of a unit holding factor base
+// units, recording the unit if it isn't the base unit.
+func (c *StocktakeCount) SetQuantity(quantity int, unit string, factor int) {
+	c.Quantity = quantity * factor
+	c.Unit, c.UnitQuantity = "", 0
+	if factor != 1 {
+		c.Unit, c.UnitQuantity = unit, quantity
+	}
+}
+
+// SetCount records device's count of a line.
+func (l *StocktakeLine) SetCount(count StocktakeCount) {
+	for i := range l.Counts {
+		if l.Counts[i].Device == count.Device {
+			l.Counts[i] = count
+			return
+		}
+	}
+	l.Counts = append(l.Counts, count)
+}
+
+// Recalculate refreshes the counted totals, variances and summary counts.
+func (s *Stocktake) Recalculate() {
+	s.Uncounted, s.Discrepancies = 0, 0
+	for i := range s.Lines {
+		line := &s.Lines[i]
+		line.Counted, line.Variance = 0, 0
+		if len(line.Counts) == 0 {
+			s.Uncounted++
+			continue
+		}
+		for _, c := range line.Counts {
+			line.Counted += c.Quantity
+		}
+		line.Variance = line.Counted - line.Expected
+		if line.Variance != 0 {
+			s.Discrepancies++
+		}
+	}
+}
@@ -0,0 +1,13 @@
+package models
+
+import "go.mongodb.org/mongo-driver/bson/primitive"
+
+// Supplier is a business the stores buy stock from.
+type Supplier struct {
+	ID          primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
+	Name        string             `bson:"name" json:"name" validate:"required,max=200"`
+	ContactName string             `bson:"contact_name" json:"contact_name" validate:"max=200"`
+	Email       string             `bson:"email" json:"email" validate:"max=254"`
+	PhoneNumber string             `bson:"phone_number" json:"phone_number,omitempty" validate:"e164"`
+	Address     string             `bson:"address" json:"address" validate:"max=1000"`
+}
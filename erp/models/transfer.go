@@ -0,0 +1,120 @@
+package models
+
+import "go.mongodb.org/mongo-driver/bson/primitive"
+
+// Transfer statuses. A draft can be edited or cancelled; dispatching takes
+// the stock out of the source store and receiving puts what arrived into
+// the destination.
+const (
+	TransferDraft      = "draft"
+	TransferDispatched = "dispatched"
+	TransferReceived   = "received"
+	TransferCancelled  = "cancelled"
+)
+
+// Transfer moves stock from one store to another.
+type Transfer struct {
+	ID             primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
+	FromStoreID    primitive.ObjectID `bson:"from_store_id" json:"from_store_id"`
+	ToStoreID      primitive.ObjectID `bson:"to_store_id" json:"to_store_id"`
+	Status         string             `bson:"status" json:"status"`
+	Lines          []TransferLine     `bson:"lines" json:"lines"`
+	InTransit      int                `bson:"in_transit" json:"in_transit"`           // units dispatched and not yet received
+	HasDiscrepancy bool               `bson:"has_discrepancy" json:"has_discrepancy"` // some line arrived short or over
+	Note           string             `bson:"note,omitempty" json:"note,omitempty"`
+	CreatedBy      primitive.ObjectID `bson:"created_by" json:"created_by"`
+	CreatedAt      int64              `bson:"created_at" json:"created_at"`
+	DispatchedBy   primitive.ObjectID `bson:"dispatched_by,omitempty" json:"dispatched_by,omitempty"`
+	DispatchedAt   int64              `bson:"dispatched_at,omitempty" json:"dispatched_at,omitempty"`
+	ReceivedBy     primitive.ObjectID `bson:"received_by,omitempty" json:"received_by,omitempty"`
+	ReceivedAt     int64              `bson:"received_at,omitempty" json:"received_at,omitempty"`
+	UpdatedAt      int64              `bson:"updated_at" json:"updated_at"`
+}
+
+// TransferLine is one product on a transfer. Quantities are in the
+// product's base unit; a line entered in a pack unit also records the unit
+// and how many of it. Discrepancy is received less dispatched: negative
+// when goods went missing on the way. Lots lists the lots dispatching took
+// the stock from, first-expiry-first-out; stock that wasn't in a lot isn't
+// listed.
+type TransferLine struct {
+	ProductID       primitive.ObjectID `bson:"product_id" json:"product_id"`
+	SKU             string             `bson:"sku" json:"sku"`
+	Name            string             `bson:"name" json:"name"`
+	Quantity        int                `bson:"quantity" json:"quantity"`
+	Unit            string             `bson:"unit,omitempty" json:"unit,omitempty"`
+	UnitQuantity    int                `bson:"unit_quantity,omitempty" json:"unit_quantity,omitempty"` // Quantity in Unit
+	Dispatched      int                `bson:"dispatched" json:"dispatched"`
+	Received        int                `bson:"received" json:"received"`
+	InTransit       int                `bson:"in_transit" json:"in_transit"`
+	Discrepancy     int                `bson:"discrepancy" json:"discrepancy"`
+	DiscrepancyNote string             `bson:"discrepancy_note,omitempty" json:"discrepancy_note,omitempty"`
+	Lots            []TransferLot      `bson:"lots,omitempty" json:"lots,omitempty"`
+}
+
+// SetQuantity sets the line's quantity from quantity of a unit holding
+// factor base units, recording the unit if it isn't the base unit.
+func (l *TransferLine) SetQuantity(quantity int, unit string, factor int) {
+	l.Quantity = quantity * factor
+	l.Unit, l.UnitQuantity = "", 0
+	if factor != 1 {
+		l.Unit, l.UnitQuantity = unit, quantity
+	}
+}
+
+// TransferLot is how much of a line was dispatched from one of the sending
+// store's lots, and how much of that arrived.
+type TransferLot struct {
+	LotID      primitive.ObjectID `bson:"lot_id" json:"lot_id"`
+	Lot        string             `bson:"lot,omitempty" json:"lot,omitempty"`
+	ExpiresAt  int64              `bson:"expires_at,omitempty" json:"expires_at,omitempty"`
+	Dispatched int                `bson:"dispatched" json:"dispatched"`
+	Received   int                `bson:"received" json:"received"`
+}
+
+// RecordPosted notes the lots dispatching took each line's stock from. The
+// repository calls it with the movements as posted, split by lot.
+func (t *Transfer) RecordPosted(posted []StockMovement) {
+	for _, mv := range posted {
+		if mv.Kind != MoveTransferOut || mv.LotID.IsZero() {
+			continue
+		}
+		for i := range t.Lines {
+			if line := &t.Lines[i]; line.ProductID == mv.ProductID {
+				line.Lots = append(line.Lots, TransferLot{LotID: mv.LotID, Lot: mv.Lot, ExpiresAt: mv.ExpiresAt, Dispatched: -mv.Quantity})
+			}
+		}
+	}
+}
+
+// ReceiveLots shares what arrived on the line among the lots it was
+// dispatched from, in the order they were taken, so any shortfall comes off
+// the latest-expiring. It returns what is left: stock that wasn't in a lot,
+// plus anything over what was dispatched.
+func (l *TransferLine) ReceiveLots() int {
+	rest := l.Received
+	for i := range l.Lots {
+		lot := &l.Lots[i]
+		lot.Received = min(rest, lot.Dispatched)
+		rest -= lot.Received
+	}
+	return rest
+}
+
+// Recalculate refreshes in-transit quantities and discrepancies from what
+// was dispatched and received.
+func (t *Transfer) Recalculate() {
+	t.InTransit, t.HasDiscrepancy = 0, false
+	for i := range t.Lines {
+		line := &t.Lines[i]
+		line.InTransit, line.Discrepancy = 0, 0
+		switch t.Status {
+		case TransferDispatched:
+			line.InTransit = line.Dispatched
+		case TransferReceived:
+			line.Discrepancy = line.Received - line.Dispatched
+		}
+		t.InTransit += line.InTransit
+		t.HasDiscrepancy = t.HasDiscrepancy || line.Discrepancy != 0
+	}
+}
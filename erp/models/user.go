@@ -2,12 +2,22 @@ package models
 
 import "go.mongodb.org/mongo-driver/bson/primitive"
 
+// Roles a user can have. Signing up always makes a customer; only an admin
+// can give someone another role.
+const (
+	RoleCustomer = "customer"
+	RoleVendor   = "vendor"
+	RoleAdmin    = "admin"
+)
+
 type User struct {
 	ID           primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
 	Username     string             `bson:"username" json:"username"`
-	Password     string             `bson:"password" json:"password"`
-	Role         string             `bson:"role" json:"role"` // "customer" or "vendor"
+	Password     string             `bson:"password" json:"-"` // bcrypt hash
+	Role         string             `bson:"role" json:"role"`
 	PhoneNumber  string             `bson:"phone_number" json:"phone_number"`
-	OTP          string             `bson:"otp" json:"otp"`
-	OTPExpiresAt int64              `bson:"otp_expires_at" json:"otp_expires_at"`
+	OTPHash      string             `bson:"otp_hash,omitempty" json:"-"`
+	OTPExpiresAt int64              `bson:"otp_expires_at,omitempty" json:"-"`
+	OTPSentAt    int64              `bson:"otp_sent_at,omitempty" json:"-"`
+	TwoFactor    bool               `bson:"two_factor" json:"two_factor"` // vendors only: password login also needs an OTP
 }
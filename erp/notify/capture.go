@@ -0,0 +1,37 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// Capture writes every message to a file or stdout instead of sending it, so
+// OTP flows can be exercised locally without an SMS account.
+type Capture struct {
+	mu  sync.Mutex
+	out io.Writer
+}
+
+// NewCapture appends messages to path, or writes them to stdout when path is
+// empty or "-".
+func NewCapture(path string) (*Capture, error) {
+	if path == "" || path == "-" {
+		return &Capture{out: os.Stdout}, nil
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	return &Capture{out: f}, nil
+}
+
+func (c *Capture) Send(ctx context.Context, to, body string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, err := fmt.Fprintf(c.out, "%s SMS to %s: %s\n", time.Now().Format(time.RFC3339), to, body)
+	return err
+}
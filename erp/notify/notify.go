@@ -0,0 +1,27 @@
+// Package notify delivers short text messages such as OTP codes.
+package notify
+
+import (
+	"adonai-api/config"
+	"context"
+	"fmt"
+)
+
+// Notifier sends a text message to a phone number.
+type Notifier interface {
+	Send(ctx context.Context, to, body string) error
+}
+
+// New builds the notifier selected by the sms configuration.
+func New(cfg config.SMSConfig) (Notifier, error) {
+	switch cfg.Provider {
+	case "twilio":
+		return NewTwilio(cfg.Twilio.AccountSID, cfg.Twilio.AuthToken, cfg.Twilio.From), nil
+	case "capture":
+		return NewCapture(cfg.CaptureFile)
+	case "test":
+		return NewRecorder(), nil
+	default:
+		return nil, fmt.Errorf("notify: unknown SMS provider %q", cfg.Provider)
+	}
+}
@@ -0,0 +1,55 @@
+package notify
+
+import (
+	"context"
+	"sync"
+)
+
+// Message is a text message kept by Recorder.
+type Message struct {
+	To   string
+	Body string
+}
+
+// Recorder keeps sent messages in memory for tests to inspect.
+type Recorder struct {
+	mu       sync.Mutex
+	messages []Message
+}
+
+func NewRecorder() *Recorder {
+	return &Recorder{}
+}
+
+func (r *Recorder) Send(ctx context.Context, to, body string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.messages = append(r.messages, Message{To: to, Body: body})
+	return nil
+}
+
+// Messages returns a copy of everything sent so far.
+func (r *Recorder) Messages() []Message {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]Message(nil), r.messages...)
+}
+
+// Last returns the most recent message sent to the given number.
+func (r *Recorder) Last(to string) (Message, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i := len(r.messages) - 1; i >= 0; i-- {
+		if r.messages[i].To == to {
+			return r.messages[i], true
+		}
+	}
+	return Message{}, false
+}
+
+// Reset forgets all recorded messages.
+func (r *Recorder) Reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.messages = nil
+}
@@ -0,0 +1,32 @@
+package notify
+
+import (
+	"context"
+
+	"github.com/twilio/twilio-go"
+	openapi "github.com/twilio/twilio-go/rest/api/v2010"
+)
+
+// Twilio sends messages through the Twilio REST API.
+type Twilio struct {
+	client *twilio.RestClient
+	from   string
+}
+
+func NewTwilio(accountSID, authToken, from string) *Twilio {
+	client := twilio.NewRestClientWithParams(twilio.ClientParams{
+		Username: accountSID,
+		Password: authToken,
+	})
+	return &Twilio{client: client, from: from}
+}
+
+func (t *Twilio) Send(ctx context.Context, to, body string) error {
+	params := &openapi.CreateMessageParams{}
+	params.SetTo(to)
+	params.SetFrom(t.from)
+	params.SetBody(body)
+
+	_, err := t.client.Api.CreateMessage(params)
+	return err
+}
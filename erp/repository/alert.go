@@ -0,0 +1,134 @@
+package repository
+
+import (
+	"adonai-api/db"
+	"adonai-api/models"
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// AlertRepository stores low-stock alerts. A product has at most one open
+// alert per store: Raise returns ErrDuplicate while another is open.
+type AlertRepository interface {
+	Raise(ctx context.Context, alert *models.StockAlert) error
+	FindByID(ctx context.Context, id primitive.ObjectID) (*models.StockAlert, error)
+	List(ctx context.Context, q Query) (*Page[models.StockAlert], error)
+	Open(ctx context.Context) ([]models.StockAlert, error)
+	Notified(ctx context.Context, id primitive.ObjectID, at int64) error
+	// Acknowledge records who acknowledged the alert, keeping the first
+	// acknowledgement if there already is one, and returns the alert.
+	Acknowledge(ctx context.Context, id, by primitive.ObjectID, at int64) (*models.StockAlert, error)
+	Resolve(ctx context.Context, id primitive.ObjectID, at int64) error
+}
+
+type mongoAlerts struct{}
+
+func (m *mongoAlerts) Raise(ctx context.Context, alert *models.StockAlert) error {
+	if alert.ID.IsZero() {
+		alert.ID = primitive.NewObjectID()
+	}
+	alert.Open = true
+	_, err := db.Collection(db.StockAlerts).InsertOne(ctx, alert)
+	return mongoErr(err)
+}
+
+func (m *mongoAlerts) FindByID(ctx context.Context, id primitive.ObjectID) (*models.StockAlert, error) {
+	return findOne[models.StockAlert](ctx, db.Collection(db.StockAlerts), bson.M{"_id": id})
+}
+
+func (m *mongoAlerts) List(ctx context.Context, q Query) (*Page[models.StockAlert], error) {
+	return findPage[models.StockAlert](ctx, db.Collection(db.StockAlerts), q)
+}
+
+func (m *mongoAlerts) Open(ctx context.Context) ([]models.StockAlert, error) {
+	return findAll[models.StockAlert](ctx, db.Collection(db.StockAlerts), bson.M{"open": true})
+}
+
+func (m *mongoAlerts) Notified(ctx context.Context, id primitive.ObjectID, at int64) error {
+	return m.set(ctx, bson.M{"_id": id}, bson.M{"notified_at": at})
+}
+
+func (m *mongoAlerts) Acknowledge(ctx context.Context, id, by primitive.ObjectID, at int64) (*models.StockAlert, error) {
+	if err := m.set(ctx, bson.M{"_id": id, "acknowledged_at": bson.M{"$exists": false}}, bson.M{"acknowledged_by": by, "acknowledged_at": at}); err != nil && err != ErrNotFound {
+		return nil, err
+	}
+	return m.FindByID(ctx, id)
+}
+
+func (m *mongoAlerts) Resolve(ctx context.Context, id primitive.ObjectID, at int64) error {
+	return m.set(ctx, bson.M{"_id": id, "open": true}, bson.M{"open": false, "resolved_at": at})
+}
+
+func (m *mongoAlerts) set(ctx context.Context, filter, fields bson.M) error {
+	result, err := db.Collection(db.StockAlerts).UpdateOne(ctx, filter, bson.M{"$set": fields})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+type memoryAlerts struct {
+	t *table[models.StockAlert]
+}
+
+func newMemoryAlerts() *memoryAlerts {
+	return &memoryAlerts{t: newTable[models.StockAlert]().withUnique(func(a *models.StockAlert) []string {
+		if !a.Open {
+			return nil
+		}
+		return []string{levelKey(a.StoreID, a.ProductID)}
+	})}
+}
+
+func (m *memoryAlerts) Raise(ctx context.Context, alert *models.StockAlert) error {
+	if alert.ID.IsZero() {
+		alert.ID = primitive.NewObjectID()
+	}
+	alert.Open = true
+	return m.t.insert(alert.ID.Hex(), alert)
+}
+
+func (m *memoryAlerts) FindByID(ctx context.Context, id primitive.ObjectID) (*models.StockAlert, error) {
+	return m.t.get(id.Hex())
+}
+
+func (m *memoryAlerts) List(ctx context.Context, q Query) (*Page[models.StockAlert], error) {
+	return m.t.page(q)
+}
+
+func (m *memoryAlerts) Open(ctx context.Context) ([]models.StockAlert, error) {
+	return m.t.find(func(a *models.StockAlert) bool { return a.Open }), nil
+}
+
+func (m *memoryAlerts) Notified(ctx context.Context, id primitive.ObjectID, at int64) error {
+	_, err := m.t.update(id.Hex(), func(a *models.StockAlert) error {
+		a.NotifiedAt = at
+		return nil
+	})
+	return err
+}
+
+func (m *memoryAlerts) Acknowledge(ctx context.Context, id, by primitive.ObjectID, at int64) (*models.StockAlert, error) {
+	return m.t.update(id.Hex(), func(a *models.StockAlert) error {
+		if a.AcknowledgedAt == 0 {
+			a.AcknowledgedBy, a.AcknowledgedAt = by, at
+		}
+		return nil
+	})
+}
+
+func (m *memoryAlerts) Resolve(ctx context.Context, id primitive.ObjectID, at int64) error {
+	_, err := m.t.update(id.Hex(), func(a *models.StockAlert) error {
+		if !a.Open {
+			return ErrNotFound
+		}
+		a.Open, a.ResolvedAt = false, at
+		return nil
+	})
+	return err
+}
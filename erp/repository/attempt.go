@@ -0,0 +1,118 @@
+package repository
+
+import (
+	"adonai-api/db"
+	"adonai-api/models"
+	"context"
+	"sync"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type AttemptRepository interface {
+	FindMany(ctx context.Context, keys ...string) ([]models.AttemptCounter, error)
+	// Increment counts one failure against key and returns the counter as
+	// it is afterwards. A counter whose window started before windowStart
+	// starts over with a window beginning at now. Concurrent increments are
+	// never lost.
+	Increment(ctx context.Context, key string, now, windowStart int64) (*models.AttemptCounter, error)
+	// Lock locks key until the given time, unless it is already locked for
+	// longer.
+	Lock(ctx context.Context, key string, until int64) error
+	Delete(ctx context.Context, key string) error
+}
+
+type mongoAttempts struct{}
+
+func (m *mongoAttempts) FindMany(ctx context.Context, keys ...string) ([]models.AttemptCounter, error) {
+	return findAll[models.AttemptCounter](ctx, db.Collection(db.AuthAttempts), bson.M{"_id": bson.M{"$in": keys}})
+}
+
+func (m *mongoAttempts) Increment(ctx context.Context, key string, now, windowStart int64) (*models.AttemptCounter, error) {
+	collection := db.Collection(db.AuthAttempts)
+	// Restarting a stale window is conditional on it still being stale, so
+	// of two concurrent failures only one restarts it and both are counted.
+	_, err := collection.UpdateOne(ctx,
+		bson.M{"_id": key, "window_start": bson.M{"$lt": windowStart}},
+		bson.M{"$set": bson.M{"count": 0, "window_start": now}})
+	if err != nil {
+		return nil, err
+	}
+	var counter models.AttemptCounter
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+	err = collection.FindOneAndUpdate(ctx, bson.M{"_id": key}, bson.M{
+		"$inc":         bson.M{"count": 1},
+		"$setOnInsert": bson.M{"window_start": now, "locked_until": int64(0)},
+	}, opts).Decode(&counter)
+	if err != nil {
+		return nil, err
+	}
+	return &counter, nil
+}
+
+func (m *mongoAttempts) Lock(ctx context.Context, key string, until int64) error {
+	_, err := db.Collection(db.AuthAttempts).UpdateOne(ctx, bson.M{"_id": key}, bson.M{
+		"$max": bson.M{"locked_until": until},
+	})
+	return err
+}
+
+func (m *mongoAttempts) Delete(ctx context.Context, key string) error {
+	_, err := db.Collection(db.AuthAttempts).DeleteOne(ctx, bson.M{"_id": key})
+	return err
+}
+
+// memoryAttempts increments under a mutex in place of MongoDB's atomic
+// update.
+type memoryAttempts struct {
+	mu sync.Mutex
+	t  *table[models.AttemptCounter]
+}
+
+func (m *memoryAttempts) FindMany(ctx context.Context, keys ...string) ([]models.AttemptCounter, error) {
+	var out []models.AttemptCounter
+	for _, key := range keys {
+		if counter, err := m.t.get(key); err == nil {
+			out = append(out, *counter)
+		}
+	}
+	return out, nil
+}
+
+func (m *memoryAttempts) Increment(ctx context.Context, key string, now, windowStart int64) (*models.AttemptCounter, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	counter, err := m.t.get(key)
+	if err != nil {
+		counter = &models.AttemptCounter{Key: key, WindowStart: now}
+	}
+	if counter.WindowStart < windowStart {
+		counter.Count, counter.WindowStart = 0, now
+	}
+	counter.Count++
+	m.t.upsert(key, counter)
+	return counter, nil
+}
+
+func (m *memoryAttempts) Lock(ctx context.Context, key string, until int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, err := m.t.update(key, func(c *models.AttemptCounter) error {
+		if until > c.LockedUntil {
+			c.LockedUntil = until
+		}
+		return nil
+	})
+	if err == ErrNotFound {
+		return nil
+	}
+	return err
+}
+
+func (m *memoryAttempts) Delete(ctx context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.t.delete(key)
+	return nil
+}
@@ -0,0 +1,74 @@
+package repository
+
+import (
+	"adonai-api/db"
+	"adonai-api/models"
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type ChatRepository interface {
+	FindByUser(ctx context.Context, userID primitive.ObjectID) (*models.Chat, error)
+	// AppendMessage adds msg to the user's chat, creating the chat (with
+	// adminID, if set) when it doesn't exist yet.
+	AppendMessage(ctx context.Context, userID, adminID primitive.ObjectID, msg models.Message) error
+	CreateBroadcast(ctx context.Context, msg *models.BroadcastMessage) error
+}
+
+type mongoChats struct{}
+
+func (m *mongoChats) FindByUser(ctx context.Context, userID primitive.ObjectID) (*models.Chat, error) {
+	return findOne[models.Chat](ctx, db.Collection(db.Chats), bson.M{"user_id": userID})
+}
+
+func (m *mongoChats) AppendMessage(ctx context.Context, userID, adminID primitive.ObjectID, msg models.Message) error {
+	update := bson.M{"$push": bson.M{"messages": msg}}
+	if !adminID.IsZero() {
+		update["$setOnInsert"] = bson.M{"admin_id": adminID}
+	}
+	_, err := db.Collection(db.Chats).UpdateOne(ctx, bson.M{"user_id": userID}, update, options.Update().SetUpsert(true))
+	return err
+}
+
+func (m *mongoChats) CreateBroadcast(ctx context.Context, msg *models.BroadcastMessage) error {
+	if msg.ID.IsZero() {
+		msg.ID = primitive.NewObjectID()
+	}
+	_, err := db.Collection(db.Broadcasts).InsertOne(ctx, msg)
+	return mongoErr(err)
+}
+
+type memoryChats struct {
+	t          *table[models.Chat]
+	broadcasts *table[models.BroadcastMessage]
+}
+
+func (m *memoryChats) FindByUser(ctx context.Context, userID primitive.ObjectID) (*models.Chat, error) {
+	return m.t.get(userID.Hex())
+}
+
+func (m *memoryChats) AppendMessage(ctx context.Context, userID, adminID primitive.ObjectID, msg models.Message) error {
+	_, err := m.t.update(userID.Hex(), func(c *models.Chat) error {
+		c.Messages = append(c.Messages, msg)
+		return nil
+	})
+	if err != ErrNotFound {
+		return err
+	}
+	chat := &models.Chat{ID: primitive.NewObjectID(), UserID: userID, AdminID: adminID, Messages: []models.Message{msg}}
+	err = m.t.insert(userID.Hex(), chat)
+	if err == ErrDuplicate {
+		return m.AppendMessage(ctx, userID, adminID, msg) // lost a race with another first message
+	}
+	return err
+}
+
+func (m *memoryChats) CreateBroadcast(ctx context.Context, msg *models.BroadcastMessage) error {
+	if msg.ID.IsZero() {
+		msg.ID = primitive.NewObjectID()
+	}
+	return m.broadcasts.insert(msg.ID.Hex(), msg)
+}
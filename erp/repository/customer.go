@@ -0,0 +1,80 @@
+package repository
+
+import (
+	"adonai-api/db"
+	"adonai-api/models"
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type CustomerRepository interface {
+	Create(ctx context.Context, customer *models.Customer) error
+	FindByID(ctx context.Context, id primitive.ObjectID) (*models.Customer, error)
+	List(ctx context.Context, q Query) (*Page[models.Customer], error)
+	// Update sets the customer's fields, leaving zero-valued omitempty
+	// fields untouched, and returns the updated document.
+	Update(ctx context.Context, id primitive.ObjectID, customer *models.Customer) (*models.Customer, error)
+	Delete(ctx context.Context, id primitive.ObjectID) error
+}
+
+type mongoCustomers struct{}
+
+func (m *mongoCustomers) Create(ctx context.Context, customer *models.Customer) error {
+	if customer.ID.IsZero() {
+		customer.ID = primitive.NewObjectID()
+	}
+	_, err := db.Collection(db.Customers).InsertOne(ctx, customer)
+	return mongoErr(err)
+}
+
+func (m *mongoCustomers) FindByID(ctx context.Context, id primitive.ObjectID) (*models.Customer, error) {
+	return findOne[models.Customer](ctx, db.Collection(db.Customers), bson.M{"_id": id})
+}
+
+func (m *mongoCustomers) Update(ctx context.Context, id primitive.ObjectID, customer *models.Customer) (*models.Customer, error) {
+	return findOneAndUpdate[models.Customer](ctx, db.Collection(db.Customers), bson.M{"_id": id}, bson.M{"$set": customer})
+}
+
+func (m *mongoCustomers) Delete(ctx context.Context, id primitive.ObjectID) error {
+	result, err := db.Collection(db.Customers).DeleteOne(ctx, bson.M{"_id": id})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (m *mongoCustomers) List(ctx context.Context, q Query) (*Page[models.Customer], error) {
+	return findPage[models.Customer](ctx, db.Collection(db.Customers), q)
+}
+
+type memoryCustomers struct {
+	t *table[models.Customer]
+}
+
+func (m *memoryCustomers) Create(ctx context.Context, customer *models.Customer) error {
+	if customer.ID.IsZero() {
+		customer.ID = primitive.NewObjectID()
+	}
+	return m.t.insert(customer.ID.Hex(), customer)
+}
+
+func (m *memoryCustomers) FindByID(ctx context.Context, id primitive.ObjectID) (*models.Customer, error) {
+	return m.t.get(id.Hex())
+}
+
+func (m *memoryCustomers) Update(ctx context.Context, id primitive.ObjectID, customer *models.Customer) (*models.Customer, error) {
+	return m.t.set(id.Hex(), customer)
+}
+
+func (m *memoryCustomers) Delete(ctx context.Context, id primitive.ObjectID) error {
+	return m.t.delete(id.Hex())
+}
+
+func (m *memoryCustomers) List(ctx context.Context, q Query) (*Page[models.Customer], error) {
+	return m.t.page(q)
+}
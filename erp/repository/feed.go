@@ -0,0 +1,43 @@
+package repository
+
+import (
+	"adonai-api/db"
+	"adonai-api/models"
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type FeedRepository interface {
+	Create(ctx context.Context, feed *models.Feed) error
+	List(ctx context.Context, q Query) (*Page[models.Feed], error)
+}
+
+type mongoFeeds struct{}
+
+func (m *mongoFeeds) Create(ctx context.Context, feed *models.Feed) error {
+	if feed.ID.IsZero() {
+		feed.ID = primitive.NewObjectID()
+	}
+	_, err := db.Collection(db.Feeds).InsertOne(ctx, feed)
+	return mongoErr(err)
+}
+
+func (m *mongoFeeds) List(ctx context.Context, q Query) (*Page[models.Feed], error) {
+	return findPage[models.Feed](ctx, db.Collection(db.Feeds), q)
+}
+
+type memoryFeeds struct {
+	t *table[models.Feed]
+}
+
+func (m *memoryFeeds) Create(ctx context.Context, feed *models.Feed) error {
+	if feed.ID.IsZero() {
+		feed.ID = primitive.NewObjectID()
+	}
+	return m.t.insert(feed.ID.Hex(), feed)
+}
+
+func (m *memoryFeeds) List(ctx context.Context, q Query) (*Page[models.Feed], error) {
+	return m.t.page(q)
+}
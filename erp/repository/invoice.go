@@ -0,0 +1,115 @@
+package repository
+
+import (
+	"adonai-api/db"
+	"adonai-api/models"
+	"context"
+	"fmt"
+	"sync"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// InvoiceRepository stores issued invoices. There is no way to change or
+// delete one.
+type InvoiceRepository interface {
+	// Issue gives the invoice its store's next number and saves it. The
+	// number is only used up if the invoice is saved, so numbers have no
+	// gaps. It returns ErrDuplicate if the order already has an invoice.
+	Issue(ctx context.Context, invoice *models.Invoice) error
+	FindByID(ctx context.Context, id primitive.ObjectID) (*models.Invoice, error)
+	FindByOrder(ctx context.Context, orderID primitive.ObjectID) (*models.Invoice, error)
+	List(ctx context.Context, q Query) (*Page[models.Invoice], error)
+}
+
+type mongoInvoices struct{}
+
+// invoiceCounter holds the last number a store has issued, keyed by store.
+type invoiceCounter struct {
+	StoreID primitive.ObjectID `bson:"_id"`
+	Last    int64              `bson:"last"`
+}
+
+func (m *mongoInvoices) Issue(ctx context.Context, invoice *models.Invoice) error {
+	if invoice.ID.IsZero() {
+		invoice.ID = primitive.NewObjectID()
+	}
+	// The counter and the invoice commit together: an invoice that fails to
+	// insert rolls its number back, and concurrent issues at one store
+	// conflict on the counter so one retries with the next number.
+	err := inTransaction(ctx, func(ctx context.Context) error {
+		var counter invoiceCounter
+		opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+		err := db.Collection(db.InvoiceCounters).FindOneAndUpdate(ctx,
+			bson.M{"_id": invoice.StoreID},
+			bson.M{"$inc": bson.M{"last": int64(1)}},
+			opts).Decode(&counter)
+		if err != nil {
+			return err
+		}
+		invoice.Number = counter.Last
+		_, err = db.Collection(db.Invoices).InsertOne(ctx, invoice)
+		return mongoErr(err)
+	})
+	if err != nil {
+		invoice.Number = 0
+	}
+	return err
+}
+
+func (m *mongoInvoices) FindByID(ctx context.Context, id primitive.ObjectID) (*models.Invoice, error) {
+	return findOne[models.Invoice](ctx, db.Collection(db.Invoices), bson.M{"_id": id})
+}
+
+func (m *mongoInvoices) FindByOrder(ctx context.Context, orderID primitive.ObjectID) (*models.Invoice, error) {
+	return findOne[models.Invoice](ctx, db.Collection(db.Invoices), bson.M{"order_id": orderID})
+}
+
+func (m *mongoInvoices) List(ctx context.Context, q Query) (*Page[models.Invoice], error) {
+	return findPage[models.Invoice](ctx, db.Collection(db.Invoices), q)
+}
+
+// memoryInvoices numbers invoices under a mutex in place of a transaction.
+type memoryInvoices struct {
+	mu   sync.Mutex
+	t    *table[models.Invoice]
+	last map[primitive.ObjectID]int64
+}
+
+func newMemoryInvoices() *memoryInvoices {
+	return &memoryInvoices{
+		t: newTable[models.Invoice]().withUnique(func(inv *models.Invoice) []string {
+			return []string{"order:" + inv.OrderID.Hex(), fmt.Sprintf("number:%s/%d", inv.StoreID.Hex(), inv.Number)}
+		}),
+		last: map[primitive.ObjectID]int64{},
+	}
+}
+
+func (m *memoryInvoices) Issue(ctx context.Context, invoice *models.Invoice) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if invoice.ID.IsZero() {
+		invoice.ID = primitive.NewObjectID()
+	}
+	invoice.Number = m.last[invoice.StoreID] + 1
+	if err := m.t.insert(invoice.ID.Hex(), invoice); err != nil {
+		invoice.Number = 0
+		return err
+	}
+	m.last[invoice.StoreID] = invoice.Number
+	return nil
+}
+
+func (m *memoryInvoices) FindByID(ctx context.Context, id primitive.ObjectID) (*models.Invoice, error) {
+	return m.t.get(id.Hex())
+}
+
+func (m *memoryInvoices) FindByOrder(ctx context.Context, orderID primitive.ObjectID) (*models.Invoice, error) {
+	return m.t.findOne(func(inv *models.Invoice) bool { return inv.OrderID == orderID })
+}
+
+func (m *memoryInvoices) List(ctx context.Context, q Query) (*Page[models.Invoice], error) {
+	return m.t.page(q)
+}
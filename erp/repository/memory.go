@@ -0,0 +1,229 @@
+package repository
+
+import (
+	"sync"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// table is the storage behind every in-memory repository. Rows are kept
+// BSON-encoded so callers never share memory with stored values and field
+// tags such as omitempty behave as they do against MongoDB.
+type table[T any] struct {
+	mu   sync.RWMutex
+	keys []string
+	rows map[string][]byte
+
+	uniqueBy func(*T) []string // optional secondary keys no two rows may share, like unique indexes
+}
+
+func newTable[T any]() *table[T] {
+	return &table[T]{rows: map[string][]byte{}}
+}
+
+// withUnique makes the table reject inserts and updates that would give two
+// rows a uniqueBy key in common, returning ErrDuplicate. Keys standing for
+// different indexes should be prefixed so they can't collide.
+func (t *table[T]) withUnique(uniqueBy func(*T) []string) *table[T] {
+	t.uniqueBy = uniqueBy
+	return t
+}
+
+// conflicts reports whether another row already holds one of v's unique
+// keys. The caller must hold the lock.
+func (t *table[T]) conflicts(key string, v *T) bool {
+	if t.uniqueBy == nil {
+		return false
+	}
+	want := map[string]bool{}
+	for _, k := range t.uniqueBy(v) {
+		want[k] = true
+	}
+	if len(want) == 0 {
+		return false
+	}
+	for k, raw := range t.rows {
+		if k == key {
+			continue
+		}
+		for _, held := range t.uniqueBy(decode[T](raw)) {
+			if want[held] {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func encode[T any](v *T) []byte {
+	raw, err := bson.Marshal(v)
+	if err != nil {
+		panic(err) // models are always marshalable
+	}
+	return raw
+}
+
+func decode[T any](raw []byte) *T {
+	v := new(T)
+	if err := bson.Unmarshal(raw, v); err != nil {
+		panic(err)
+	}
+	return v
+}
+
+func (t *table[T]) insert(key string, v *T) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if _, ok := t.rows[key]; ok || t.conflicts(key, v) {
+		return ErrDuplicate
+	}
+	t.keys = append(t.keys, key)
+	t.rows[key] = encode(v)
+	return nil
+}
+
+// upsert stores v under key, replacing any existing row.
+func (t *table[T]) upsert(key string, v *T) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if _, ok := t.rows[key]; !ok {
+		t.keys = append(t.keys, key)
+	}
+	t.rows[key] = encode(v)
+}
+
+func (t *table[T]) get(key string) (*T, error) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	raw, ok := t.rows[key]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return decode[T](raw), nil
+}
+
+func (t *table[T]) delete(key string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if _, ok := t.rows[key]; !ok {
+		return ErrNotFound
+	}
+	delete(t.rows, key)
+	for i, k := range t.keys {
+		if k == key {
+			t.keys = append(t.keys[:i], t.keys[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+// find returns every row, in insertion order, for which match is true.
+func (t *table[T]) find(match func(*T) bool) []T {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	var out []T
+	for _, key := range t.keys {
+		v := decode[T](t.rows[key])
+		if match == nil || match(v) {
+			out = append(out, *v)
+		}
+	}
+	return out
+}
+
+// page returns the rows q selects, as a MongoDB query would.
+func (t *table[T]) page(q Query) (*Page[T], error) {
+	t.mu.RLock()
+	docs := make([]bson.Raw, 0, len(t.keys))
+	for _, key := range t.keys {
+		docs = append(docs, t.rows[key]) // rows are replaced, never mutated
+	}
+	t.mu.RUnlock()
+
+	docs, next, total, err := pageRaw(docs, q)
+	if err != nil {
+		return nil, err
+	}
+	page := &Page[T]{Items: make([]T, 0, len(docs)), NextCursor: next}
+	for _, doc := range docs {
+		page.Items = append(page.Items, *decode[T](doc))
+	}
+	if q.CountTotal {
+		page.Total = total
+	}
+	return page, nil
+}
+
+func (t *table[T]) findOne(match func(*T) bool) (*T, error) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	for _, key := range t.keys {
+		v := decode[T](t.rows[key])
+		if match(v) {
+			return v, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+// update applies fn to the row under key while holding the write lock, so
+// read-modify-write sequences are atomic. If fn returns an error nothing is
+// stored.
+func (t *table[T]) update(key string, fn func(*T) error) (*T, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	raw, ok := t.rows[key]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	v := decode[T](raw)
+	if err := fn(v); err != nil {
+		return nil, err
+	}
+	if t.conflicts(key, v) {
+		return nil, ErrDuplicate
+	}
+	t.rows[key] = encode(v)
+	return v, nil
+}
+
+// updateWhere applies fn to every matching row and returns how many it changed.
+// fn reports whether it changed the row.
+func (t *table[T]) updateWhere(match func(*T) bool, fn func(*T) bool) int64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var n int64
+	for _, key := range t.keys {
+		v := decode[T](t.rows[key])
+		if match(v) && fn(v) {
+			t.rows[key] = encode(v)
+			n++
+		}
+	}
+	return n
+}
+
+// set overlays the fields patch would write with a MongoDB $set onto the
+// row under key, mirroring UpdateOne(..., {"$set": patch}).
+func (t *table[T]) set(key string, patch *T) (*T, error) {
+	var fields bson.M
+	if err := bson.Unmarshal(encode(patch), &fields); err != nil {
+		return nil, err
+	}
+	return t.update(key, func(v *T) error {
+		var doc bson.M
+		if err := bson.Unmarshal(encode(v), &doc); err != nil {
+			return err
+		}
+		for k, f := range fields {
+			doc[k] = f
+		}
+		raw, err := bson.Marshal(doc)
+		if err != nil {
+			return err
+		}
+		*v = *decode[T](raw)
+		return nil
+	})
+}
@@ -0,0 +1,156 @@
+package repository
+
+import (
+	"adonai-api/config"
+	"adonai-api/models"
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func findAll[T any](ctx context.Context, collection *mongo.Collection, filter interface{}, opts ...*options.FindOptions) ([]T, error) {
+	cursor, err := collection.Find(ctx, filter, opts...)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var out []T
+	for cursor.Next(ctx) {
+		var v T
+		if err := cursor.Decode(&v); err != nil {
+			return nil, err
+		}
+		out = append(out, v)
+	}
+	return out, cursor.Err()
+}
+
+func findOne[T any](ctx context.Context, collection *mongo.Collection, filter interface{}) (*T, error) {
+	var v T
+	if err := collection.FindOne(ctx, filter).Decode(&v); err != nil {
+		return nil, mongoErr(err)
+	}
+	return &v, nil
+}
+
+// findOneAndUpdate applies update to the first document matching filter and
+// returns the document as it is after the update.
+func findOneAndUpdate[T any](ctx context.Context, collection *mongo.Collection, filter, update interface{}) (*T, error) {
+	var v T
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	if err := collection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&v); err != nil {
+		return nil, mongoErr(err)
+	}
+	return &v, nil
+}
+
+// findPage runs q against collection. It fetches one document past the
+// limit to learn whether another page follows.
+func findPage[T any](ctx context.Context, collection *mongo.Collection, q Query) (*Page[T], error) {
+	c, err := decodeCursor(q)
+	if err != nil {
+		return nil, err
+	}
+	opts := options.Find().SetSort(mongoSort(q))
+	if q.Limit > 0 {
+		opts.SetLimit(int64(q.Limit) + 1)
+	}
+	cursor, err := collection.Find(ctx, mongoFilter(q, c), opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var docs []bson.Raw
+	for cursor.Next(ctx) {
+		docs = append(docs, append(bson.Raw(nil), cursor.Current...))
+	}
+	if err := cursor.Err(); err != nil {
+		return nil, err
+	}
+
+	page := &Page[T]{Items: make([]T, 0, len(docs))}
+	if q.Limit > 0 && len(docs) > q.Limit {
+		docs = docs[:q.Limit]
+		if page.NextCursor, err = nextCursor(q, docs[len(docs)-1]); err != nil {
+			return nil, err
+		}
+	}
+	for _, doc := range docs {
+		var v T
+		if err := bson.Unmarshal(doc, &v); err != nil {
+			return nil, err
+		}
+		page.Items = append(page.Items, v)
+	}
+
+	if q.CountTotal {
+		page.Total, err = collection.CountDocuments(ctx, mongoFilter(q, nil))
+		if err != nil {
+			return nil, err
+		}
+	}
+	return page, nil
+}
+
+// inTransaction runs fn in a MongoDB transaction, retrying it on transient
+// errors such as a write conflict with a concurrent transaction. The ctx
+// fn receives carries the session, so every operation that uses it takes
+// part in the transaction.
+func inTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	session, err := config.Client.StartSession()
+	if err != nil {
+		return err
+	}
+	defer session.EndSession(ctx)
+	_, err = session.WithTransaction(ctx, func(ctx mongo.SessionContext) (interface{}, error) {
+		return nil, fn(ctx)
+	})
+	return err
+}
+
+// stockRecorder is a document that keeps a record of how its movements were
+// posted, such as the lots a transfer's stock was taken from.
+type stockRecorder interface {
+	RecordPosted(posted []models.StockMovement)
+}
+
+// updateWithStock loads the document with id, lets fn change it and return
+// the stock movements the change posts, and saves the document and the
+// movements in one transaction. Nothing is saved if fn fails. A concurrent
+// change to the same document makes the transaction retry from the load. A
+// document that is a stockRecorder sees the movements as posted before it
+// is saved.
+func updateWithStock[T any](ctx context.Context, collection *mongo.Collection, id primitive.ObjectID, fn func(*T) ([]models.StockMovement, error)) (*T, error) {
+	var saved *T
+	err := inTransaction(ctx, func(ctx context.Context) error {
+		v, err := findOne[T](ctx, collection, bson.M{"_id": id})
+		if err != nil {
+			return err
+		}
+		movements, err := fn(v)
+		if err != nil {
+			return err
+		}
+		posted, err := postMovements(ctx, movements)
+		if err != nil {
+			return err
+		}
+		if recorder, ok := any(v).(stockRecorder); ok {
+			recorder.RecordPosted(posted)
+		}
+		if _, err := collection.ReplaceOne(ctx, bson.M{"_id": id}, v); err != nil {
+			return err
+		}
+		saved = v
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return saved, nil
+}
@@ -0,0 +1,101 @@
+package repository
+
+import (
+	"adonai-api/db"
+	"adonai-api/models"
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type OrderRepository interface {
+	Create(ctx context.Context, order *models.Order) error
+	FindByID(ctx context.Context, id primitive.ObjectID) (*models.Order, error)
+	List(ctx context.Context, q Query) (*Page[models.Order], error)
+	// Transition moves the order to change.To and appends change to its
+	// history, provided its status is still from, and posts movements to
+	// the stock ledger in the same transaction. It returns ErrConflict when
+	// the status has moved on in the meantime and ErrInsufficientStock when
+	// the movements can't be posted; either way nothing changes.
+	Transition(ctx context.Context, id primitive.ObjectID, from string, change models.StatusChange, movements []models.StockMovement) (*models.Order, error)
+}
+
+type mongoOrders struct{}
+
+func (m *mongoOrders) Create(ctx context.Context, order *models.Order) error {
+	if order.ID.IsZero() {
+		order.ID = primitive.NewObjectID()
+	}
+	_, err := db.Collection(db.Orders).InsertOne(ctx, order)
+	return mongoErr(err)
+}
+
+func (m *mongoOrders) FindByID(ctx context.Context, id primitive.ObjectID) (*models.Order, error) {
+	return findOne[models.Order](ctx, db.Collection(db.Orders), bson.M{"_id": id})
+}
+
+func (m *mongoOrders) Transition(ctx context.Context, id primitive.ObjectID, from string, change models.StatusChange, movements []models.StockMovement) (*models.Order, error) {
+	var order *models.Order
+	err := inTransaction(ctx, func(ctx context.Context) error {
+		var err error
+		order, err = findOneAndUpdate[models.Order](ctx, db.Collection(db.Orders), bson.M{"_id": id, "order_status": from}, bson.M{
+			"$set":  bson.M{"order_status": change.To},
+			"$push": bson.M{"status_history": change},
+		})
+		if err == ErrNotFound {
+			if _, findErr := m.FindByID(ctx, id); findErr == nil {
+				return ErrConflict
+			}
+		}
+		if err != nil {
+			return err
+		}
+		_, err = postMovements(ctx, movements)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return order, nil
+}
+
+func (m *mongoOrders) List(ctx context.Context, q Query) (*Page[models.Order], error) {
+	return findPage[models.Order](ctx, db.Collection(db.Orders), q)
+}
+
+type memoryOrders struct {
+	t     *table[models.Order]
+	stock *memoryStock // transitions post to it under its lock
+}
+
+func (m *memoryOrders) Create(ctx context.Context, order *models.Order) error {
+	if order.ID.IsZero() {
+		order.ID = primitive.NewObjectID()
+	}
+	return m.t.insert(order.ID.Hex(), order)
+}
+
+func (m *memoryOrders) FindByID(ctx context.Context, id primitive.ObjectID) (*models.Order, error) {
+	return m.t.get(id.Hex())
+}
+
+func (m *memoryOrders) Transition(ctx context.Context, id primitive.ObjectID, from string, change models.StatusChange, movements []models.StockMovement) (*models.Order, error) {
+	m.stock.mu.Lock()
+	defer m.stock.mu.Unlock()
+	return m.t.update(id.Hex(), func(o *models.Order) error {
+		if o.OrderStatus != from {
+			return ErrConflict
+		}
+		if _, err := m.stock.postLocked(movements); err != nil {
+			return err
+		}
+		o.OrderStatus = change.To
+		o.StatusHistory = append(o.StatusHistory, change)
+		return nil
+	})
+}
+
+func (m *memoryOrders) List(ctx context.Context, q Query) (*Page[models.Order], error) {
+	return m.t.page(q)
+}
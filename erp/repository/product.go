@@ -0,0 +1,117 @@
+package repository
+
+import (
+	"adonai-api/db"
+	"adonai-api/models"
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ProductRepository stores the catalog. SKUs and barcodes are unique:
+// Create and Update return ErrDuplicate when another product already has
+// the SKU or one of the barcodes.
+type ProductRepository interface {
+	Create(ctx context.Context, product *models.Product) error
+	FindByID(ctx context.Context, id primitive.ObjectID) (*models.Product, error)
+	FindBySKU(ctx context.Context, sku string) (*models.Product, error)
+	FindByBarcode(ctx context.Context, code string) (*models.Product, error)
+	List(ctx context.Context, q Query) (*Page[models.Product], error)
+	// Update replaces the product's fields and returns the updated document.
+	Update(ctx context.Context, id primitive.ObjectID, product *models.Product) (*models.Product, error)
+	Delete(ctx context.Context, id primitive.ObjectID) error
+}
+
+type mongoProducts struct{}
+
+func (m *mongoProducts) Create(ctx context.Context, product *models.Product) error {
+	if product.ID.IsZero() {
+		product.ID = primitive.NewObjectID()
+	}
+	_, err := db.Collection(db.Products).InsertOne(ctx, product)
+	return mongoErr(err)
+}
+
+func (m *mongoProducts) FindByID(ctx context.Context, id primitive.ObjectID) (*models.Product, error) {
+	return findOne[models.Product](ctx, db.Collection(db.Products), bson.M{"_id": id})
+}
+
+func (m *mongoProducts) FindBySKU(ctx context.Context, sku string) (*models.Product, error) {
+	return findOne[models.Product](ctx, db.Collection(db.Products), bson.M{"sku": sku})
+}
+
+func (m *mongoProducts) FindByBarcode(ctx context.Context, code string) (*models.Product, error) {
+	return findOne[models.Product](ctx, db.Collection(db.Products), bson.M{"barcodes.code": code})
+}
+
+func (m *mongoProducts) List(ctx context.Context, q Query) (*Page[models.Product], error) {
+	return findPage[models.Product](ctx, db.Collection(db.Products), q)
+}
+
+func (m *mongoProducts) Update(ctx context.Context, id primitive.ObjectID, product *models.Product) (*models.Product, error) {
+	return findOneAndUpdate[models.Product](ctx, db.Collection(db.Products), bson.M{"_id": id}, bson.M{"$set": product})
+}
+
+func (m *mongoProducts) Delete(ctx context.Context, id primitive.ObjectID) error {
+	result, err := db.Collection(db.Products).DeleteOne(ctx, bson.M{"_id": id})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+type memoryProducts struct {
+	t *table[models.Product]
+}
+
+func newMemoryProducts() *memoryProducts {
+	return &memoryProducts{t: newTable[models.Product]().withUnique(func(p *models.Product) []string {
+		keys := []string{"sku:" + p.SKU}
+		for _, b := range p.Barcodes {
+			keys = append(keys, "barcode:"+b.Code)
+		}
+		return keys
+	})}
+}
+
+func (m *memoryProducts) Create(ctx context.Context, product *models.Product) error {
+	if product.ID.IsZero() {
+		product.ID = primitive.NewObjectID()
+	}
+	return m.t.insert(product.ID.Hex(), product)
+}
+
+func (m *memoryProducts) FindByID(ctx context.Context, id primitive.ObjectID) (*models.Product, error) {
+	return m.t.get(id.Hex())
+}
+
+func (m *memoryProducts) FindBySKU(ctx context.Context, sku string) (*models.Product, error) {
+	return m.t.findOne(func(p *models.Product) bool { return p.SKU == sku })
+}
+
+func (m *memoryProducts) FindByBarcode(ctx context.Context, code string) (*models.Product, error) {
+	return m.t.findOne(func(p *models.Product) bool {
+		for _, b := range p.Barcodes {
+			if b.Code == code {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+func (m *memoryProducts) List(ctx context.Context, q Query) (*Page[models.Product], error) {
+	return m.t.page(q)
+}
+
+func (m *memoryProducts) Update(ctx context.Context, id primitive.ObjectID, product *models.Product) (*models.Product, error) {
+	return m.t.set(id.Hex(), product)
+}
+
+func (m *memoryProducts) Delete(ctx context.Context, id primitive.ObjectID) error {
+	return m.t.delete(id.Hex())
+}
@@ -0,0 +1,82 @@
+package repository
+
+import (
+	"adonai-api/db"
+	"adonai-api/models"
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type PurchaseOrderRepository interface {
+	Create(ctx context.Context, po *models.PurchaseOrder) error
+	FindByID(ctx context.Context, id primitive.ObjectID) (*models.PurchaseOrder, error)
+	List(ctx context.Context, q Query) (*Page[models.PurchaseOrder], error)
+	// Update loads the purchase order, lets fn change it and return the
+	// stock movements the change posts, and saves both in one transaction.
+	// If fn returns an error nothing is saved and Update returns it.
+	Update(ctx context.Context, id primitive.ObjectID, fn func(*models.PurchaseOrder) ([]models.StockMovement, error)) (*models.PurchaseOrder, error)
+	// Awaiting reports whether any purchase order is still to bring in the
+	// product, as PurchaseOrder.Awaits.
+	Awaiting(ctx context.Context, productID primitive.ObjectID) (bool, error)
+}
+
+type mongoPurchaseOrders struct{}
+
+func (m *mongoPurchaseOrders) Create(ctx context.Context, po *models.PurchaseOrder) error {
+	if po.ID.IsZero() {
+		po.ID = primitive.NewObjectID()
+	}
+	_, err := db.Collection(db.PurchaseOrders).InsertOne(ctx, po)
+	return mongoErr(err)
+}
+
+func (m *mongoPurchaseOrders) FindByID(ctx context.Context, id primitive.ObjectID) (*models.PurchaseOrder, error) {
+	return findOne[models.PurchaseOrder](ctx, db.Collection(db.PurchaseOrders), bson.M{"_id": id})
+}
+
+func (m *mongoPurchaseOrders) List(ctx context.Context, q Query) (*Page[models.PurchaseOrder], error) {
+	return findPage[models.PurchaseOrder](ctx, db.Collection(db.PurchaseOrders), q)
+}
+
+func (m *mongoPurchaseOrders) Update(ctx context.Context, id primitive.ObjectID, fn func(*models.PurchaseOrder) ([]models.StockMovement, error)) (*models.PurchaseOrder, error) {
+	return updateWithStock(ctx, db.Collection(db.PurchaseOrders), id, fn)
+}
+
+func (m *mongoPurchaseOrders) Awaiting(ctx context.Context, productID primitive.ObjectID) (bool, error) {
+	n, err := db.Collection(db.PurchaseOrders).CountDocuments(ctx, bson.M{"$or": bson.A{
+		bson.M{"status": models.PODraft, "lines.product_id": productID},
+		bson.M{"lines": bson.M{"$elemMatch": bson.M{"product_id": productID, "outstanding": bson.M{"$gt": 0}}}},
+	}}, options.Count().SetLimit(1))
+	return n > 0, err
+}
+
+type memoryPurchaseOrders struct {
+	t     *table[models.PurchaseOrder]
+	stock *memoryStock
+}
+
+func (m *memoryPurchaseOrders) Create(ctx context.Context, po *models.PurchaseOrder) error {
+	if po.ID.IsZero() {
+		po.ID = primitive.NewObjectID()
+	}
+	return m.t.insert(po.ID.Hex(), po)
+}
+
+func (m *memoryPurchaseOrders) FindByID(ctx context.Context, id primitive.ObjectID) (*models.PurchaseOrder, error) {
+	return m.t.get(id.Hex())
+}
+
+func (m *memoryPurchaseOrders) List(ctx context.Context, q Query) (*Page[models.PurchaseOrder], error) {
+	return m.t.page(q)
+}
+
+func (m *memoryPurchaseOrders) Update(ctx context.Context, id primitive.ObjectID, fn func(*models.PurchaseOrder) ([]models.StockMovement, error)) (*models.PurchaseOrder, error) {
+	return updateWithMemoryStock(m.t, m.stock, id.Hex(), fn)
+}
+
+func (m *memoryPurchaseOrders) Awaiting(ctx context.Context, productID primitive.ObjectID) (bool, error) {
+	return len(m.t.find(func(po *models.PurchaseOrder) bool { return po.Awaits(productID) })) > 0, nil
+}
@@ -0,0 +1,352 @@
+package repository
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"math"
+	"sort"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ErrInvalidCursor is returned when a cursor is malformed or was issued for
+// a different sort order.
+var ErrInvalidCursor = errors.New("repository: invalid cursor")
+
+// Filter operators.
+const (
+	OpEq  = "eq"
+	OpGte = "gte"
+	OpLte = "lte"
+)
+
+// Filter restricts a list to documents whose Field (a BSON field name)
+// compares to Value with Op.
+type Filter struct {
+	Field string
+	Op    string
+	Value interface{}
+}
+
+// Query describes one page of a list. Results are ordered by Sort and then
+// by _id so every document has a stable position, which is what the cursor
+// records.
+type Query struct {
+	Filters    []Filter
+	Sort       string // BSON field name, prefixed with "-" for descending; empty sorts by _id
+	Limit      int    // zero means no limit
+	Cursor     string // NextCursor of the previous page
+	CountTotal bool   // also count every document matching Filters
+}
+
+// Page is one page of results.
+type Page[T any] struct {
+	Items      []T
+	NextCursor string // empty on the last page
+	Total      int64  // only set when Query.CountTotal is true
+}
+
+// Where appends a filter and returns q for chaining.
+func (q Query) Where(field, op string, value interface{}) Query {
+	q.Filters = append(append([]Filter(nil), q.Filters...), Filter{Field: field, Op: op, Value: value})
+	return q
+}
+
+func (q Query) sortField() (field string, dir int) {
+	field, dir = q.Sort, 1
+	if strings.HasPrefix(field, "-") {
+		field, dir = field[1:], -1
+	}
+	if field == "" {
+		field = "_id"
+	}
+	return field, dir
+}
+
+// cursor is the position after the last document of a page.
+type cursor struct {
+	Field string             `bson:"f"`
+	Dir   int                `bson:"d"`
+	Value bson.RawValue      `bson:"v"`
+	ID    primitive.ObjectID `bson:"id"`
+}
+
+func encodeCursor(c cursor) (string, error) {
+	raw, err := bson.Marshal(c)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(raw), nil
+}
+
+// decodeCursor parses q.Cursor, returning nil when the query starts at the
+// first page.
+func decodeCursor(q Query) (*cursor, error) {
+	if q.Cursor == "" {
+		return nil, nil
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(q.Cursor)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	var c cursor
+	if err := bson.Unmarshal(raw, &c); err != nil {
+		return nil, ErrInvalidCursor
+	}
+	field, dir := q.sortField()
+	if c.Field != field || c.Dir != dir || c.ID.IsZero() {
+		return nil, ErrInvalidCursor
+	}
+	return &c, nil
+}
+
+// nextCursor records the position of the last document in a page.
+func nextCursor(q Query, last bson.Raw) (string, error) {
+	field, dir := q.sortField()
+	id, _ := last.Lookup("_id").ObjectIDOK()
+	return encodeCursor(cursor{Field: field, Dir: dir, Value: sortValue(last, field), ID: id})
+}
+
+// sortValue returns doc's value for the sort field, or null if it has none.
+// MongoDB sorts a missing field as null, before every other value.
+func sortValue(doc bson.Raw, field string) bson.RawValue {
+	v, err := doc.LookupErr(field)
+	if err != nil {
+		return bson.RawValue{Type: bsontype.Null}
+	}
+	return v
+}
+
+func isNull(v bson.RawValue) bool {
+	return v.Type == bsontype.Null || v.Type == bsontype.Undefined
+}
+
+// mongoFilter translates q's filters, and the cursor position if any, into a
+// MongoDB query document.
+func mongoFilter(q Query, c *cursor) bson.D {
+	and := bson.A{}
+	for _, f := range q.Filters {
+		switch f.Op {
+		case OpGte:
+			and = append(and, bson.M{f.Field: bson.M{"$gte": f.Value}})
+		case OpLte:
+			and = append(and, bson.M{f.Field: bson.M{"$lte": f.Value}})
+		default:
+			and = append(and, bson.M{f.Field: f.Value})
+		}
+	}
+	if c != nil {
+		op := "$gt"
+		if c.Dir < 0 {
+			op = "$lt"
+		}
+		// Nulls and missing fields sort first, and comparison operators
+		// never match them, so they are handled apart from other values.
+		switch {
+		case c.Field == "_id":
+			and = append(and, bson.M{"_id": bson.M{op: c.ID}})
+		case isNull(c.Value) && c.Dir > 0:
+			and = append(and, bson.M{"$or": bson.A{
+				bson.M{c.Field: bson.M{"$ne": nil}},
+				bson.M{c.Field: nil, "_id": bson.M{op: c.ID}},
+			}})
+		case isNull(c.Value):
+			and = append(and, bson.M{c.Field: nil, "_id": bson.M{op: c.ID}})
+		case c.Dir > 0:
+			and = append(and, bson.M{"$or": bson.A{
+				bson.M{c.Field: bson.M{op: c.Value}},
+				bson.M{c.Field: c.Value, "_id": bson.M{op: c.ID}},
+			}})
+		default:
+			and = append(and, bson.M{"$or": bson.A{
+				bson.M{c.Field: bson.M{op: c.Value}},
+				bson.M{c.Field: c.Value, "_id": bson.M{op: c.ID}},
+				bson.M{c.Field: nil},
+			}})
+		}
+	}
+	if len(and) == 0 {
+		return bson.D{}
+	}
+	return bson.D{{Key: "$and", Value: and}}
+}
+
+func mongoSort(q Query) bson.D {
+	field, dir := q.sortField()
+	if field == "_id" {
+		return bson.D{{Key: "_id", Value: dir}}
+	}
+	return bson.D{{Key: field, Value: dir}, {Key: "_id", Value: dir}}
+}
+
+// matchRaw reports whether doc passes every filter, with the same
+// semantics mongoFilter gives MongoDB.
+func matchRaw(doc bson.Raw, filters []Filter) bool {
+	for _, f := range filters {
+		got, err := doc.LookupErr(f.Field)
+		if err != nil {
+			got = bson.RawValue{Type: bsontype.Null}
+		}
+		want := rawValue(f.Value)
+		cmp, ok := compareRaw(got, want)
+		if !ok {
+			return false
+		}
+		switch f.Op {
+		case OpGte:
+			if cmp < 0 {
+				return false
+			}
+		case OpLte:
+			if cmp > 0 {
+				return false
+			}
+		default:
+			if cmp != 0 {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// pageRaw filters, sorts and slices docs the way a MongoDB query for q
+// would, for the in-memory repositories.
+func pageRaw(docs []bson.Raw, q Query) ([]bson.Raw, string, int64, error) {
+	c, err := decodeCursor(q)
+	if err != nil {
+		return nil, "", 0, err
+	}
+
+	var matched []bson.Raw
+	for _, doc := range docs {
+		if matchRaw(doc, q.Filters) {
+			matched = append(matched, doc)
+		}
+	}
+	total := int64(len(matched))
+
+	field, dir := q.sortField()
+	position := func(doc bson.Raw) (bson.RawValue, bson.RawValue) {
+		return sortValue(doc, field), doc.Lookup("_id")
+	}
+	sort.SliceStable(matched, func(i, j int) bool {
+		return comparePosition(matched[i], matched[j], position)*dir < 0
+	})
+
+	start := 0
+	if c != nil {
+		after := bson.Raw(mustMarshal(bson.D{{Key: field, Value: c.Value}, {Key: "_id", Value: c.ID}}))
+		start = sort.Search(len(matched), func(i int) bool {
+			return comparePosition(matched[i], after, position)*dir > 0
+		})
+	}
+	page := matched[start:]
+
+	next := ""
+	if q.Limit > 0 && len(page) > q.Limit {
+		page = page[:q.Limit]
+		if next, err = nextCursor(q, page[len(page)-1]); err != nil {
+			return nil, "", 0, err
+		}
+	}
+	return page, next, total, nil
+}
+
+// comparePosition orders two documents by sort value and then _id, with
+// nulls first as MongoDB sorts them.
+func comparePosition(a, b bson.Raw, position func(bson.Raw) (bson.RawValue, bson.RawValue)) int {
+	av, aid := position(a)
+	bv, bid := position(b)
+	switch {
+	case isNull(av) && !isNull(bv):
+		return -1
+	case !isNull(av) && isNull(bv):
+		return 1
+	}
+	if cmp, _ := compareRaw(av, bv); cmp != 0 {
+		return cmp
+	}
+	cmp, _ := compareRaw(aid, bid)
+	return cmp
+}
+
+func rawValue(v interface{}) bson.RawValue {
+	if rv, ok := v.(bson.RawValue); ok {
+		return rv
+	}
+	t, data, err := bson.MarshalValue(v)
+	if err != nil {
+		panic(err)
+	}
+	return bson.RawValue{Type: t, Value: data}
+}
+
+func mustMarshal(v interface{}) []byte {
+	raw, err := bson.Marshal(v)
+	if err != nil {
+		panic(err)
+	}
+	return raw
+}
+
+// compareRaw orders two BSON values. Numbers compare across int32, int64
+// and double; values of unrelated types are not comparable.
+func compareRaw(a, b bson.RawValue) (int, bool) {
+	if an, ok := number(a); ok {
+		if bn, ok := number(b); ok {
+			return sign(an - bn), true
+		}
+		return 0, false
+	}
+	if a.Type != b.Type {
+		return 0, false
+	}
+	switch a.Type {
+	case bsontype.String:
+		return strings.Compare(a.StringValue(), b.StringValue()), true
+	case bsontype.ObjectID:
+		ao, bo := a.ObjectID(), b.ObjectID()
+		return bytes.Compare(ao[:], bo[:]), true
+	case bsontype.DateTime:
+		return sign(float64(a.DateTime() - b.DateTime())), true
+	case bsontype.Boolean:
+		ab, bb := a.Boolean(), b.Boolean()
+		switch {
+		case ab == bb:
+			return 0, true
+		case !ab:
+			return -1, true
+		}
+		return 1, true
+	case bsontype.Null, bsontype.Undefined:
+		return 0, true
+	}
+	return bytes.Compare(a.Value, b.Value), true
+}
+
+func number(v bson.RawValue) (float64, bool) {
+	switch v.Type {
+	case bsontype.Int32:
+		return float64(v.Int32()), true
+	case bsontype.Int64:
+		return float64(v.Int64()), true
+	case bsontype.Double:
+		return v.Double(), true
+	}
+	return math.NaN(), false
+}
+
+func sign(f float64) int {
+	switch {
+	case f < 0:
+		return -1
+	case f > 0:
+		return 1
+	}
+	return 0
+}
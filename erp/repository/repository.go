@@ -0,0 +1,108 @@
+// Package repository hides storage behind one interface per aggregate.
+// Each interface has a MongoDB implementation and a thread-safe in-memory
+// one used by tests and local experiments.
+package repository
+
+import (
+	"adonai-api/config"
+	"adonai-api/models"
+	"context"
+	"errors"
+
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+)
+
+var (
+	ErrNotFound  = errors.New("repository: not found")
+	ErrDuplicate = errors.New("repository: duplicate key")
+	ErrConflict  = errors.New("repository: changed concurrently")
+)
+
+// Repositories bundles every repository the handlers need.
+type Repositories struct {
+	Users      UserRepository
+	Sessions   SessionRepository
+	Attempts   AttemptRepository
+	Customers  CustomerRepository
+	Stores     StoreRepository
+	Orders     OrderRepository
+	Chats      ChatRepository
+	Feeds      FeedRepository
+	Products   ProductRepository
+	Stock      StockRepository
+	Alerts     AlertRepository
+	Suppliers  SupplierRepository
+	Purchases  PurchaseOrderRepository
+	Transfers  TransferRepository
+	Stocktakes StocktakeRepository
+	Invoices   InvoiceRepository
+
+	ping func(ctx context.Context) error
+}
+
+// Ping checks that the underlying storage is reachable.
+func (r *Repositories) Ping(ctx context.Context) error {
+	if r.ping == nil {
+		return nil
+	}
+	return r.ping(ctx)
+}
+
+// NewMongo returns repositories backed by the collections registered in db.
+func NewMongo() *Repositories {
+	return &Repositories{
+		Users:      &mongoUsers{},
+		Sessions:   &mongoSessions{},
+		Attempts:   &mongoAttempts{},
+		Customers:  &mongoCustomers{},
+		Stores:     &mongoStores{},
+		Orders:     &mongoOrders{},
+		Chats:      &mongoChats{},
+		Feeds:      &mongoFeeds{},
+		Products:   &mongoProducts{},
+		Stock:      &mongoStock{},
+		Alerts:     &mongoAlerts{},
+		Suppliers:  &mongoSuppliers{},
+		Purchases:  &mongoPurchaseOrders{},
+		Transfers:  &mongoTransfers{},
+		Stocktakes: &mongoStocktakes{},
+		Invoices:   &mongoInvoices{},
+		ping: func(ctx context.Context) error {
+			return config.Client.Ping(ctx, readpref.Primary())
+		},
+	}
+}
+
+// NewMemory returns empty in-memory repositories.
+func NewMemory() *Repositories {
+	stock := newMemoryStock()
+	return &Repositories{
+		Users:      newMemoryUsers(),
+		Sessions:   &memorySessions{t: newTable[models.Session]()},
+		Attempts:   &memoryAttempts{t: newTable[models.AttemptCounter]()},
+		Customers:  &memoryCustomers{t: newTable[models.Customer]()},
+		Stores:     &memoryStores{t: newTable[models.Store]()},
+		Orders:     &memoryOrders{t: newTable[models.Order](), stock: stock},
+		Chats:      &memoryChats{t: newTable[models.Chat](), broadcasts: newTable[models.BroadcastMessage]()},
+		Feeds:      &memoryFeeds{t: newTable[models.Feed]()},
+		Products:   newMemoryProducts(),
+		Stock:      stock,
+		Alerts:     newMemoryAlerts(),
+		Suppliers:  &memorySuppliers{t: newTable[models.Supplier]()},
+		Purchases:  &memoryPurchaseOrders{t: newTable[models.PurchaseOrder](), stock: stock},
+		Transfers:  &memoryTransfers{t: newTable[models.Transfer](), stock: stock},
+		Stocktakes: &memoryStocktakes{t: newTable[models.Stocktake](), stock: stock},
+		Invoices:   newMemoryInvoices(),
+	}
+}
+
+func mongoErr(err error) error {
+	switch {
+	case err == mongo.ErrNoDocuments:
+		return ErrNotFound
+	case mongo.IsDuplicateKeyError(err):
+		return ErrDuplicate
+	}
+	return err
+}
@@ -0,0 +1,150 @@
+package repository
+
+import (
+	"adonai-api/db"
+	"adonai-api/models"
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type SessionRepository interface {
+	Create(ctx context.Context, session *models.Session) error
+	FindByID(ctx context.Context, id primitive.ObjectID) (*models.Session, error)
+	FindByRefreshHash(ctx context.Context, hash string) (*models.Session, error)
+	// FindByUsedHash finds the session a rotated-out refresh token belonged to.
+	FindByUsedHash(ctx context.Context, hash string) (*models.Session, error)
+	ListByUser(ctx context.Context, userID primitive.ObjectID) ([]models.Session, error)
+	// Rotate replaces oldHash with newHash, reporting false if oldHash is no
+	// longer current (another request rotated it first).
+	Rotate(ctx context.Context, id primitive.ObjectID, oldHash, newHash string, usedAt int64) (bool, error)
+	// Revoke revokes the session if it is still active.
+	Revoke(ctx context.Context, id primitive.ObjectID, reason string, at int64) (int64, error)
+	// RevokeByUser revokes every active session of the user.
+	RevokeByUser(ctx context.Context, userID primitive.ObjectID, reason string, at int64) (int64, error)
+}
+
+type mongoSessions struct{}
+
+func (m *mongoSessions) Create(ctx context.Context, session *models.Session) error {
+	if session.ID.IsZero() {
+		session.ID = primitive.NewObjectID()
+	}
+	_, err := db.Collection(db.Sessions).InsertOne(ctx, session)
+	return mongoErr(err)
+}
+
+func (m *mongoSessions) FindByID(ctx context.Context, id primitive.ObjectID) (*models.Session, error) {
+	return findOne[models.Session](ctx, db.Collection(db.Sessions), bson.M{"_id": id})
+}
+
+func (m *mongoSessions) FindByRefreshHash(ctx context.Context, hash string) (*models.Session, error) {
+	return findOne[models.Session](ctx, db.Collection(db.Sessions), bson.M{"refresh_hash": hash})
+}
+
+func (m *mongoSessions) FindByUsedHash(ctx context.Context, hash string) (*models.Session, error) {
+	return findOne[models.Session](ctx, db.Collection(db.Sessions), bson.M{"used_hashes": hash})
+}
+
+func (m *mongoSessions) ListByUser(ctx context.Context, userID primitive.ObjectID) ([]models.Session, error) {
+	return findAll[models.Session](ctx, db.Collection(db.Sessions), bson.M{"user_id": userID})
+}
+
+func (m *mongoSessions) Rotate(ctx context.Context, id primitive.ObjectID, oldHash, newHash string, usedAt int64) (bool, error) {
+	result, err := db.Collection(db.Sessions).UpdateOne(ctx, bson.M{"_id": id, "refresh_hash": oldHash}, bson.M{
+		"$set":  bson.M{"refresh_hash": newHash, "last_used_at": usedAt},
+		"$push": bson.M{"used_hashes": oldHash},
+	})
+	if err != nil {
+		return false, err
+	}
+	return result.ModifiedCount == 1, nil
+}
+
+func (m *mongoSessions) Revoke(ctx context.Context, id primitive.ObjectID, reason string, at int64) (int64, error) {
+	return m.revoke(ctx, bson.M{"_id": id}, reason, at)
+}
+
+func (m *mongoSessions) RevokeByUser(ctx context.Context, userID primitive.ObjectID, reason string, at int64) (int64, error) {
+	return m.revoke(ctx, bson.M{"user_id": userID}, reason, at)
+}
+
+func (m *mongoSessions) revoke(ctx context.Context, filter bson.M, reason string, at int64) (int64, error) {
+	filter["revoked_at"] = bson.M{"$exists": false}
+	result, err := db.Collection(db.Sessions).UpdateMany(ctx, filter, bson.M{
+		"$set": bson.M{"revoked_at": at, "revoked_reason": reason},
+	})
+	if err != nil {
+		return 0, err
+	}
+	return result.ModifiedCount, nil
+}
+
+type memorySessions struct {
+	t *table[models.Session]
+}
+
+func (m *memorySessions) Create(ctx context.Context, session *models.Session) error {
+	if session.ID.IsZero() {
+		session.ID = primitive.NewObjectID()
+	}
+	return m.t.insert(session.ID.Hex(), session)
+}
+
+func (m *memorySessions) FindByID(ctx context.Context, id primitive.ObjectID) (*models.Session, error) {
+	return m.t.get(id.Hex())
+}
+
+func (m *memorySessions) FindByRefreshHash(ctx context.Context, hash string) (*models.Session, error) {
+	return m.t.findOne(func(s *models.Session) bool { return s.RefreshHash == hash })
+}
+
+func (m *memorySessions) FindByUsedHash(ctx context.Context, hash string) (*models.Session, error) {
+	return m.t.findOne(func(s *models.Session) bool {
+		for _, used := range s.UsedHashes {
+			if used == hash {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+func (m *memorySessions) ListByUser(ctx context.Context, userID primitive.ObjectID) ([]models.Session, error) {
+	return m.t.find(func(s *models.Session) bool { return s.UserID == userID }), nil
+}
+
+func (m *memorySessions) Rotate(ctx context.Context, id primitive.ObjectID, oldHash, newHash string, usedAt int64) (bool, error) {
+	rotated := false
+	_, err := m.t.update(id.Hex(), func(s *models.Session) error {
+		if s.RefreshHash == oldHash {
+			s.RefreshHash, s.LastUsedAt = newHash, usedAt
+			s.UsedHashes = append(s.UsedHashes, oldHash)
+			rotated = true
+		}
+		return nil
+	})
+	if err == ErrNotFound {
+		return false, nil
+	}
+	return rotated, err
+}
+
+func (m *memorySessions) Revoke(ctx context.Context, id primitive.ObjectID, reason string, at int64) (int64, error) {
+	return m.revoke(func(s *models.Session) bool { return s.ID == id }, reason, at), nil
+}
+
+func (m *memorySessions) RevokeByUser(ctx context.Context, userID primitive.ObjectID, reason string, at int64) (int64, error) {
+	return m.revoke(func(s *models.Session) bool { return s.UserID == userID }, reason, at), nil
+}
+
+func (m *memorySessions) revoke(match func(*models.Session) bool, reason string, at int64) int64 {
+	return m.t.updateWhere(match, func(s *models.Session) bool {
+		if s.RevokedAt != 0 {
+			return false
+		}
+		s.RevokedAt, s.RevokedReason = at, reason
+		return true
+	})
+}
@@ -0,0 +1,463 @@
+package repository
+
+import (
+	"adonai-api/db"
+	"adonai-api/models"
+	"context"
+	"errors"
+	"sync"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var ErrInsufficientStock = errors.New("repository: insufficient stock")
+
+// StockRepository is the stock ledger. Movements are never changed once
+// posted; each product's level at each store is their running total, kept
+// in the same transaction as the movements so the two always agree. Lots
+// are kept the same way.
+type StockRepository interface {
+	// Post records movements all together or not at all. It returns
+	// ErrInsufficientStock, posting nothing, when a movement would take
+	// more than is available. A receipt with a lot number or expiry date
+	// starts a lot. A movement taking stock off hand is taken from the
+	// product's lots first-expiry-first-out and recorded as one movement
+	// per lot, plus one for any stock not in a lot; the first keeps the
+	// movement's ID. A transfer-in from a lot carries the lot on at the
+	// receiving store. A count adjustment made with SetCounted gets its
+	// quantity from the on-hand level it is posted against, and is dropped
+	// if that already matches the count.
+	Post(ctx context.Context, movements []models.StockMovement) error
+	// Level returns a product's level at a store, which is all zeros if
+	// nothing has been posted for it.
+	Level(ctx context.Context, storeID, productID primitive.ObjectID) (*models.StockLevel, error)
+	Levels(ctx context.Context, q Query) (*Page[models.StockLevel], error)
+	// SetReorderPoint sets a product's reorder point at a store and returns
+	// the level.
+	SetReorderPoint(ctx context.Context, storeID, productID primitive.ObjectID, point int) (*models.StockLevel, error)
+	// BelowReorderPoint returns every level whose available stock is under
+	// its reorder point.
+	BelowReorderPoint(ctx context.Context) ([]models.StockLevel, error)
+	// Stocked reports whether any store has the product on hand.
+	Stocked(ctx context.Context, productID primitive.ObjectID) (bool, error)
+	Movements(ctx context.Context, q Query) (*Page[models.StockMovement], error)
+	Lot(ctx context.Context, id primitive.ObjectID) (*models.StockLot, error)
+	Lots(ctx context.Context, q Query) (*Page[models.StockLot], error)
+}
+
+type mongoStock struct{}
+
+func (m *mongoStock) Post(ctx context.Context, movements []models.StockMovement) error {
+	return inTransaction(ctx, func(ctx context.Context) error {
+		_, err := postMovements(ctx, movements)
+		return err
+	})
+}
+
+// postMovements writes movements and their effect on stock levels; callers
+// run it inside a transaction. A movement that takes stock only matches a
+// level with enough available, and the write to that level makes
+// concurrent transactions taking the same stock conflict, so only one wins.
+// It returns the movements as recorded, split by lot.
+func postMovements(ctx context.Context, movements []models.StockMovement) ([]models.StockMovement, error) {
+	var recorded []models.StockMovement
+	for i := range movements {
+		mv := &movements[i]
+		if mv.ID.IsZero() {
+			mv.ID = primitive.NewObjectID()
+		}
+		filter := bson.M{"store_id": mv.StoreID, "product_id": mv.ProductID}
+		if mv.Counted != nil {
+			// Reading the level inside the transaction and then writing it
+			// makes a concurrent movement of the same stock conflict.
+			level, err := findOne[models.StockLevel](ctx, db.Collection(db.StockLevels), filter)
+			if errors.Is(err, ErrNotFound) {
+				level, err = &models.StockLevel{}, nil
+			}
+			if err != nil {
+				return nil, err
+			}
+			if !mv.ResolveCount(level.OnHand) {
+				continue
+			}
+		}
+		onHand, reserved := mv.Effect()
+		taken := mv.Takes()
+		if taken > 0 {
+			filter["available"] = bson.M{"$gte": taken}
+		}
+		update := bson.M{
+			"$inc": bson.M{"on_hand": onHand, "reserved": reserved, "available": onHand - reserved},
+			"$set": bson.M{"updated_at": mv.CreatedAt},
+		}
+		result, err := db.Collection(db.StockLevels).UpdateOne(ctx, filter, update, options.Update().SetUpsert(taken == 0))
+		if err != nil {
+			return nil, err
+		}
+		if result.MatchedCount == 0 && result.UpsertedCount == 0 {
+			return nil, ErrInsufficientStock
+		}
+		posted, err := postLots(ctx, mv)
+		if err != nil {
+			return nil, err
+		}
+		for j := range posted {
+			if _, err := db.Collection(db.StockMovements).InsertOne(ctx, &posted[j]); err != nil {
+				return nil, err
+			}
+		}
+		recorded = append(recorded, posted...)
+	}
+	return recorded, nil
+}
+
+// postLots starts a lot for mv or takes it from the product's lots, and
+// returns the movements to record for it.
+func postLots(ctx context.Context, mv *models.StockMovement) ([]models.StockMovement, error) {
+	onHand, _ := mv.Effect()
+	switch {
+	case mv.StartsLot():
+		if !mv.OriginLotID.IsZero() {
+			lot, err := findOneAndUpdate[models.StockLot](ctx, db.Collection(db.StockLots), transferredLot(mv),
+				bson.M{"$inc": bson.M{"received": mv.Quantity, "remaining": mv.Quantity}})
+			if err == nil {
+				mv.LotID = lot.ID
+				break
+			}
+			if !errors.Is(err, ErrNotFound) {
+				return nil, err
+			}
+		}
+		lot := models.NewStockLot(mv)
+		if _, err := db.Collection(db.StockLots).InsertOne(ctx, lot); err != nil {
+			return nil, err
+		}
+		mv.LotID = lot.ID
+	case onHand < 0 && mv.LotID.IsZero():
+		lots, err := findAll[models.StockLot](ctx, db.Collection(db.StockLots), bson.M{
+			"store_id": mv.StoreID, "product_id": mv.ProductID, "remaining": bson.M{"$gt": 0},
+		})
+		if err != nil {
+			return nil, err
+		}
+		open := make([]*models.StockLot, len(lots))
+		for i := range lots {
+			open[i] = &lots[i]
+		}
+		posted := allocateLots(*mv, open)
+		for _, p := range posted {
+			if p.LotID.IsZero() {
+				continue
+			}
+			result, err := db.Collection(db.StockLots).UpdateOne(ctx,
+				bson.M{"_id": p.LotID, "remaining": bson.M{"$gte": -p.Quantity}},
+				bson.M{"$inc": bson.M{"remaining": p.Quantity}})
+			if err != nil {
+				return nil, err
+			}
+			if result.MatchedCount == 0 {
+				return nil, ErrInsufficientStock
+			}
+		}
+		return posted, nil
+	}
+	return []models.StockMovement{*mv}, nil
+}
+
+// transferredLot matches the lot a transfer-in from a lot adds to: the
+// origin itself if the stock is coming back to its store, or the lot an
+// earlier transfer from the same origin started there.
+func transferredLot(mv *models.StockMovement) bson.M {
+	return bson.M{
+		"store_id":   mv.StoreID,
+		"product_id": mv.ProductID,
+		"$or":        bson.A{bson.M{"_id": mv.OriginLotID}, bson.M{"origin_lot_id": mv.OriginLotID}},
+	}
+}
+
+// allocateLots splits mv, which takes stock off hand, across lots in FEFO
+// order and lowers their remaining quantities to match. Whatever the lots
+// can't cover stays as a movement without a lot.
+func allocateLots(mv models.StockMovement, lots []*models.StockLot) []models.StockMovement {
+	models.SortFEFO(lots)
+	onHand, _ := mv.Effect()
+	want := -onHand
+	var posted []models.StockMovement
+	for _, lot := range lots {
+		if want == 0 {
+			break
+		}
+		taken := min(want, lot.Remaining)
+		if taken <= 0 {
+			continue
+		}
+		lot.Remaining -= taken
+		want -= taken
+		piece := mv
+		piece.Quantity = -taken
+		piece.LotID, piece.Lot, piece.ExpiresAt = lot.ID, lot.Lot, lot.ExpiresAt
+		posted = append(posted, piece)
+	}
+	if want > 0 {
+		piece := mv
+		piece.Quantity = -want
+		posted = append(posted, piece)
+	}
+	for i := 1; i < len(posted); i++ {
+		posted[i].ID = primitive.NewObjectID()
+	}
+	if len(posted) > 1 {
+		// A pack split between lots is no longer a whole number of packs
+		// in each, so the pieces are in base units only.
+		for i := range posted {
+			posted[i].Unit, posted[i].UnitQuantity = "", 0
+		}
+	}
+	return posted
+}
+
+func (m *mongoStock) Level(ctx context.Context, storeID, productID primitive.ObjectID) (*models.StockLevel, error) {
+	level, err := findOne[models.StockLevel](ctx, db.Collection(db.StockLevels), bson.M{"store_id": storeID, "product_id": productID})
+	if errors.Is(err, ErrNotFound) {
+		return &models.StockLevel{StoreID: storeID, ProductID: productID}, nil
+	}
+	return level, err
+}
+
+func (m *mongoStock) Levels(ctx context.Context, q Query) (*Page[models.StockLevel], error) {
+	return findPage[models.StockLevel](ctx, db.Collection(db.StockLevels), q)
+}
+
+func (m *mongoStock) SetReorderPoint(ctx context.Context, storeID, productID primitive.ObjectID, point int) (*models.StockLevel, error) {
+	var level models.StockLevel
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+	err := db.Collection(db.StockLevels).FindOneAndUpdate(ctx,
+		bson.M{"store_id": storeID, "product_id": productID},
+		bson.M{"$set": bson.M{"reorder_point": point}},
+		opts).Decode(&level)
+	if err != nil {
+		return nil, mongoErr(err)
+	}
+	return &level, nil
+}
+
+func (m *mongoStock) BelowReorderPoint(ctx context.Context) ([]models.StockLevel, error) {
+	return findAll[models.StockLevel](ctx, db.Collection(db.StockLevels), bson.M{
+		"$expr": bson.M{"$lt": bson.A{"$available", "$reorder_point"}},
+	})
+}
+
+func (m *mongoStock) Stocked(ctx context.Context, productID primitive.ObjectID) (bool, error) {
+	n, err := db.Collection(db.StockLevels).CountDocuments(ctx, bson.M{
+		"product_id": productID,
+		"on_hand":    bson.M{"$ne": 0},
+	}, options.Count().SetLimit(1))
+	return n > 0, err
+}
+
+func (m *mongoStock) Movements(ctx context.Context, q Query) (*Page[models.StockMovement], error) {
+	return findPage[models.StockMovement](ctx, db.Collection(db.StockMovements), q)
+}
+
+func (m *mongoStock) Lot(ctx context.Context, id primitive.ObjectID) (*models.StockLot, error) {
+	return findOne[models.StockLot](ctx, db.Collection(db.StockLots), bson.M{"_id": id})
+}
+
+func (m *mongoStock) Lots(ctx context.Context, q Query) (*Page[models.StockLot], error) {
+	return findPage[models.StockLot](ctx, db.Collection(db.StockLots), q)
+}
+
+// memoryStock serializes posting with a mutex in place of a transaction.
+type memoryStock struct {
+	mu        sync.Mutex
+	levels    *table[models.StockLevel]
+	movements *table[models.StockMovement]
+	lots      *table[models.StockLot]
+}
+
+func newMemoryStock() *memoryStock {
+	return &memoryStock{levels: newTable[models.StockLevel](), movements: newTable[models.StockMovement](), lots: newTable[models.StockLot]()}
+}
+
+func levelKey(storeID, productID primitive.ObjectID) string {
+	return storeID.Hex() + "/" + productID.Hex()
+}
+
+func (m *memoryStock) Post(ctx context.Context, movements []models.StockMovement) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, err := m.postLocked(movements)
+	return err
+}
+
+// postLocked checks every movement before storing any of them, and returns
+// them as recorded. The caller must hold m.mu.
+func (m *memoryStock) postLocked(movements []models.StockMovement) ([]models.StockMovement, error) {
+	levels := map[string]*models.StockLevel{}
+	lots := map[primitive.ObjectID]*models.StockLot{}
+	var posted []models.StockMovement
+	for i := range movements {
+		mv := &movements[i]
+		if mv.ID.IsZero() {
+			mv.ID = primitive.NewObjectID()
+		}
+		key := levelKey(mv.StoreID, mv.ProductID)
+		level, ok := levels[key]
+		if !ok {
+			var err error
+			level, err = m.levels.get(key)
+			if errors.Is(err, ErrNotFound) {
+				level, err = &models.StockLevel{ID: primitive.NewObjectID(), StoreID: mv.StoreID, ProductID: mv.ProductID}, nil
+			}
+			if err != nil {
+				return nil, err
+			}
+			levels[key] = level
+		}
+		if !mv.ResolveCount(level.OnHand) {
+			continue
+		}
+		if level.Available < mv.Takes() {
+			return nil, ErrInsufficientStock
+		}
+		level.Apply(mv)
+		posted = append(posted, m.postLotsLocked(mv, lots)...)
+	}
+
+	for key, level := range levels {
+		m.levels.upsert(key, level)
+	}
+	for id, lot := range lots {
+		m.lots.upsert(id.Hex(), lot)
+	}
+	for i := range posted {
+		if err := m.movements.insert(posted[i].ID.Hex(), &posted[i]); err != nil {
+			return nil, err
+		}
+	}
+	return posted, nil
+}
+
+// postLotsLocked is postLots against the lots staged in lots, which it
+// fills from the table as it needs them.
+func (m *memoryStock) postLotsLocked(mv *models.StockMovement, lots map[primitive.ObjectID]*models.StockLot) []models.StockMovement {
+	onHand, _ := mv.Effect()
+	switch {
+	case mv.StartsLot():
+		lot := m.transferredLotLocked(mv, lots)
+		if lot != nil {
+			lot.Received += mv.Quantity
+			lot.Remaining += mv.Quantity
+		} else {
+			lot = models.NewStockLot(mv)
+		}
+		lots[lot.ID] = lot
+		mv.LotID = lot.ID
+	case onHand < 0 && mv.LotID.IsZero():
+		sameItem := func(l *models.StockLot) bool { return l.StoreID == mv.StoreID && l.ProductID == mv.ProductID }
+		for _, lot := range m.lots.find(sameItem) {
+			if _, ok := lots[lot.ID]; !ok {
+				lots[lot.ID] = &lot
+			}
+		}
+		var open []*models.StockLot
+		for _, lot := range lots {
+			if sameItem(lot) && lot.Remaining > 0 {
+				open = append(open, lot)
+			}
+		}
+		return allocateLots(*mv, open)
+	}
+	return []models.StockMovement{*mv}
+}
+
+// transferredLotLocked finds the lot transferredLot matches among the
+// staged lots and then the table, or returns nil.
+func (m *memoryStock) transferredLotLocked(mv *models.StockMovement, lots map[primitive.ObjectID]*models.StockLot) *models.StockLot {
+	if mv.OriginLotID.IsZero() {
+		return nil
+	}
+	match := func(l *models.StockLot) bool {
+		return l.StoreID == mv.StoreID && l.ProductID == mv.ProductID && (l.ID == mv.OriginLotID || l.OriginLotID == mv.OriginLotID)
+	}
+	for _, lot := range lots {
+		if match(lot) {
+			return lot
+		}
+	}
+	if found := m.lots.find(match); len(found) > 0 {
+		return &found[0]
+	}
+	return nil
+}
+
+// updateWithMemoryStock is updateWithStock for in-memory tables: the row
+// and the stock ledger change together under the stock lock.
+func updateWithMemoryStock[T any](t *table[T], stock *memoryStock, key string, fn func(*T) ([]models.StockMovement, error)) (*T, error) {
+	stock.mu.Lock()
+	defer stock.mu.Unlock()
+	return t.update(key, func(v *T) error {
+		movements, err := fn(v)
+		if err != nil {
+			return err
+		}
+		posted, err := stock.postLocked(movements)
+		if err != nil {
+			return err
+		}
+		if recorder, ok := any(v).(stockRecorder); ok {
+			recorder.RecordPosted(posted)
+		}
+		return nil
+	})
+}
+
+func (m *memoryStock) Level(ctx context.Context, storeID, productID primitive.ObjectID) (*models.StockLevel, error) {
+	level, err := m.levels.get(levelKey(storeID, productID))
+	if errors.Is(err, ErrNotFound) {
+		return &models.StockLevel{StoreID: storeID, ProductID: productID}, nil
+	}
+	return level, err
+}
+
+func (m *memoryStock) Levels(ctx context.Context, q Query) (*Page[models.StockLevel], error) {
+	return m.levels.page(q)
+}
+
+func (m *memoryStock) SetReorderPoint(ctx context.Context, storeID, productID primitive.ObjectID, point int) (*models.StockLevel, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key := levelKey(storeID, productID)
+	level, err := m.levels.get(key)
+	if errors.Is(err, ErrNotFound) {
+		level, err = &models.StockLevel{ID: primitive.NewObjectID(), StoreID: storeID, ProductID: productID}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	level.ReorderPoint = point
+	m.levels.upsert(key, level)
+	return level, nil
+}
+
+func (m *memoryStock) BelowReorderPoint(ctx context.Context) ([]models.StockLevel, error) {
+	return m.levels.find(func(l *models.StockLevel) bool { return l.BelowReorderPoint() }), nil
+}
+
+func (m *memoryStock) Stocked(ctx context.Context, productID primitive.ObjectID) (bool, error) {
+	return len(m.levels.find(func(l *models.StockLevel) bool { return l.ProductID == productID && l.OnHand != 0 })) > 0, nil
+}
+
+func (m *memoryStock) Movements(ctx context.Context, q Query) (*Page[models.StockMovement], error) {
+	return m.movements.page(q)
+}
+
+func (m *memoryStock) Lot(ctx context.Context, id primitive.ObjectID) (*models.StockLot, error) {
+	return m.lots.get(id.Hex())
+}
+
+func (m *memoryStock) Lots(ctx context.Context, q Query) (*Page[models.StockLot], error) {
+	return m.lots.page(q)
+}
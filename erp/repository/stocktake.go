@@ -0,0 +1,64 @@
+package repository
+
+import (
+	"adonai-api/db"
+	"adonai-api/models"
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type StocktakeRepository interface {
+	Create(ctx context.Context, stocktake *models.Stocktake) error
+	FindByID(ctx context.Context, id primitive.ObjectID) (*models.Stocktake, error)
+	List(ctx context.Context, q Query) (*Page[models.Stocktake], error)
+	// Update works as PurchaseOrderRepository.Update does.
+	Update(ctx context.Context, id primitive.ObjectID, fn func(*models.Stocktake) ([]models.StockMovement, error)) (*models.Stocktake, error)
+}
+
+type mongoStocktakes struct{}
+
+func (m *mongoStocktakes) Create(ctx context.Context, stocktake *models.Stocktake) error {
+	if stocktake.ID.IsZero() {
+		stocktake.ID = primitive.NewObjectID()
+	}
+	_, err := db.Collection(db.Stocktakes).InsertOne(ctx, stocktake)
+	return mongoErr(err)
+}
+
+func (m *mongoStocktakes) FindByID(ctx context.Context, id primitive.ObjectID) (*models.Stocktake, error) {
+	return findOne[models.Stocktake](ctx, db.Collection(db.Stocktakes), bson.M{"_id": id})
+}
+
+func (m *mongoStocktakes) List(ctx context.Context, q Query) (*Page[models.Stocktake], error) {
+	return findPage[models.Stocktake](ctx, db.Collection(db.Stocktakes), q)
+}
+
+func (m *mongoStocktakes) Update(ctx context.Context, id primitive.ObjectID, fn func(*models.Stocktake) ([]models.StockMovement, error)) (*models.Stocktake, error) {
+	return updateWithStock(ctx, db.Collection(db.Stocktakes), id, fn)
+}
+
+type memoryStocktakes struct {
+	t     *table[models.Stocktake]
+	stock *memoryStock
+}
+
+func (m *memoryStocktakes) Create(ctx context.Context, stocktake *models.Stocktake) error {
+	if stocktake.ID.IsZero() {
+		stocktake.ID = primitive.NewObjectID()
+	}
+	return m.t.insert(stocktake.ID.Hex(), stocktake)
+}
+
+func (m *memoryStocktakes) FindByID(ctx context.Context, id primitive.ObjectID) (*models.Stocktake, error) {
+	return m.t.get(id.Hex())
+}
+
+func (m *memoryStocktakes) List(ctx context.Context, q Query) (*Page[models.Stocktake], error) {
+	return m.t.page(q)
+}
+
+func (m *memoryStocktakes) Update(ctx context.Context, id primitive.ObjectID, fn func(*models.Stocktake) ([]models.StockMovement, error)) (*models.Stocktake, error) {
+	return updateWithMemoryStock(m.t, m.stock, id.Hex(), fn)
+}
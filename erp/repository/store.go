@@ -0,0 +1,80 @@
+package repository
+
+import (
+	"adonai-api/db"
+	"adonai-api/models"
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type StoreRepository interface {
+	Create(ctx context.Context, store *models.Store) error
+	FindByID(ctx context.Context, id primitive.ObjectID) (*models.Store, error)
+	List(ctx context.Context, q Query) (*Page[models.Store], error)
+	// Update sets the store's fields, leaving zero-valued omitempty
+	// fields untouched, and returns the updated document.
+	Update(ctx context.Context, id primitive.ObjectID, store *models.Store) (*models.Store, error)
+	Delete(ctx context.Context, id primitive.ObjectID) error
+}
+
+type mongoStores struct{}
+
+func (m *mongoStores) Create(ctx context.Context, store *models.Store) error {
+	if store.ID.IsZero() {
+		store.ID = primitive.NewObjectID()
+	}
+	_, err := db.Collection(db.Stores).InsertOne(ctx, store)
+	return mongoErr(err)
+}
+
+func (m *mongoStores) FindByID(ctx context.Context, id primitive.ObjectID) (*models.Store, error) {
+	return findOne[models.Store](ctx, db.Collection(db.Stores), bson.M{"_id": id})
+}
+
+func (m *mongoStores) Update(ctx context.Context, id primitive.ObjectID, store *models.Store) (*models.Store, error) {
+	return findOneAndUpdate[models.Store](ctx, db.Collection(db.Stores), bson.M{"_id": id}, bson.M{"$set": store})
+}
+
+func (m *mongoStores) Delete(ctx context.Context, id primitive.ObjectID) error {
+	result, err := db.Collection(db.Stores).DeleteOne(ctx, bson.M{"_id": id})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (m *mongoStores) List(ctx context.Context, q Query) (*Page[models.Store], error) {
+	return findPage[models.Store](ctx, db.Collection(db.Stores), q)
+}
+
+type memoryStores struct {
+	t *table[models.Store]
+}
+
+func (m *memoryStores) Create(ctx context.Context, store *models.Store) error {
+	if store.ID.IsZero() {
+		store.ID = primitive.NewObjectID()
+	}
+	return m.t.insert(store.ID.Hex(), store)
+}
+
+func (m *memoryStores) FindByID(ctx context.Context, id primitive.ObjectID) (*models.Store, error) {
+	return m.t.get(id.Hex())
+}
+
+func (m *memoryStores) Update(ctx context.Context, id primitive.ObjectID, store *models.Store) (*models.Store, error) {
+	return m.t.set(id.Hex(), store)
+}
+
+func (m *memoryStores) Delete(ctx context.Context, id primitive.ObjectID) error {
+	return m.t.delete(id.Hex())
+}
+
+func (m *memoryStores) List(ctx context.Context, q Query) (*Page[models.Store], error) {
+	return m.t.page(q)
+}
@@ -0,0 +1,80 @@
+package repository
+
+import (
+	"adonai-api/db"
+	"adonai-api/models"
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type SupplierRepository interface {
+	Create(ctx context.Context, supplier *models.Supplier) error
+	FindByID(ctx context.Context, id primitive.ObjectID) (*models.Supplier, error)
+	List(ctx context.Context, q Query) (*Page[models.Supplier], error)
+	// Update sets the supplier's fields, leaving zero-valued omitempty
+	// fields untouched, and returns the updated document.
+	Update(ctx context.Context, id primitive.ObjectID, supplier *models.Supplier) (*models.Supplier, error)
+	Delete(ctx context.Context, id primitive.ObjectID) error
+}
+
+type mongoSuppliers struct{}
+
+func (m *mongoSuppliers) Create(ctx context.Context, supplier *models.Supplier) error {
+	if supplier.ID.IsZero() {
+		supplier.ID = primitive.NewObjectID()
+	}
+	_, err := db.Collection(db.Suppliers).InsertOne(ctx, supplier)
+	return mongoErr(err)
+}
+
+func (m *mongoSuppliers) FindByID(ctx context.Context, id primitive.ObjectID) (*models.Supplier, error) {
+	return findOne[models.Supplier](ctx, db.Collection(db.Suppliers), bson.M{"_id": id})
+}
+
+func (m *mongoSuppliers) Update(ctx context.Context, id primitive.ObjectID, supplier *models.Supplier) (*models.Supplier, error) {
+	return findOneAndUpdate[models.Supplier](ctx, db.Collection(db.Suppliers), bson.M{"_id": id}, bson.M{"$set": supplier})
+}
+
+func (m *mongoSuppliers) Delete(ctx context.Context, id primitive.ObjectID) error {
+	result, err := db.Collection(db.Suppliers).DeleteOne(ctx, bson.M{"_id": id})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (m *mongoSuppliers) List(ctx context.Context, q Query) (*Page[models.Supplier], error) {
+	return findPage[models.Supplier](ctx, db.Collection(db.Suppliers), q)
+}
+
+type memorySuppliers struct {
+	t *table[models.Supplier]
+}
+
+func (m *memorySuppliers) Create(ctx context.Context, supplier *models.Supplier) error {
+	if supplier.ID.IsZero() {
+		supplier.ID = primitive.NewObjectID()
+	}
+	return m.t.insert(supplier.ID.Hex(), supplier)
+}
+
+func (m *memorySuppliers) FindByID(ctx context.Context, id primitive.ObjectID) (*models.Supplier, error) {
+	return m.t.get(id.Hex())
+}
+
+func (m *memorySuppliers) Update(ctx context.Context, id primitive.ObjectID, supplier *models.Supplier) (*models.Supplier, error) {
+	return m.t.set(id.Hex(), supplier)
+}
+
+func (m *memorySuppliers) Delete(ctx context.Context, id primitive.ObjectID) error {
+	return m.t.delete(id.Hex())
+}
+
+func (m *memorySuppliers) List(ctx context.Context, q Query) (*Page[models.Supplier], error) {
+	return m.t.page(q)
+}
@@ -0,0 +1,64 @@
+package repository
+
+import (
+	"adonai-api/db"
+	"adonai-api/models"
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type TransferRepository interface {
+	Create(ctx context.Context, transfer *models.Transfer) error
+	FindByID(ctx context.Context, id primitive.ObjectID) (*models.Transfer, error)
+	List(ctx context.Context, q Query) (*Page[models.Transfer], error)
+	// Update works as PurchaseOrderRepository.Update does.
+	Update(ctx context.Context, id primitive.ObjectID, fn func(*models.Transfer) ([]models.StockMovement, error)) (*models.Transfer, error)
+}
+
+type mongoTransfers struct{}
+
+func (m *mongoTransfers) Create(ctx context.Context, transfer *models.Transfer) error {
+	if transfer.ID.IsZero() {
+		transfer.ID = primitive.NewObjectID()
+	}
+	_, err := db.Collection(db.Transfers).InsertOne(ctx, transfer)
+	return mongoErr(err)
+}
+
+func (m *mongoTransfers) FindByID(ctx context.Context, id primitive.ObjectID) (*models.Transfer, error) {
+	return findOne[models.Transfer](ctx, db.Collection(db.Transfers), bson.M{"_id": id})
+}
+
+func (m *mongoTransfers) List(ctx context.Context, q Query) (*Page[models.Transfer], error) {
+	return findPage[models.Transfer](ctx, db.Collection(db.Transfers), q)
+}
+
+func (m *mongoTransfers) Update(ctx context.Context, id primitive.ObjectID, fn func(*models.Transfer) ([]models.StockMovement, error)) (*models.Transfer, error) {
+	return updateWithStock(ctx, db.Collection(db.Transfers), id, fn)
+}
+
+type memoryTransfers struct {
+	t     *table[models.Transfer]
+	stock *memoryStock
+}
+
+func (m *memoryTransfers) Create(ctx context.Context, transfer *models.Transfer) error {
+	if transfer.ID.IsZero() {
+		transfer.ID = primitive.NewObjectID()
+	}
+	return m.t.insert(transfer.ID.Hex(), transfer)
+}
+
+func (m *memoryTransfers) FindByID(ctx context.Context, id primitive.ObjectID) (*models.Transfer, error) {
+	return m.t.get(id.Hex())
+}
+
+func (m *memoryTransfers) List(ctx context.Context, q Query) (*Page[models.Transfer], error) {
+	return m.t.page(q)
+}
+
+func (m *memoryTransfers) Update(ctx context.Context, id primitive.ObjectID, fn func(*models.Transfer) ([]models.StockMovement, error)) (*models.Transfer, error) {
+	return updateWithMemoryStock(m.t, m.stock, id.Hex(), fn)
+}
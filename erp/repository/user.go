@@ -0,0 +1,151 @@
+package repository
+
+import (
+	"adonai-api/db"
+	"adonai-api/models"
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type UserRepository interface {
+	// Create returns ErrDuplicate if another user has the username or phone
+	// number.
+	Create(ctx context.Context, user *models.User) error
+	FindByID(ctx context.Context, id primitive.ObjectID) (*models.User, error)
+	FindByUsername(ctx context.Context, username string) (*models.User, error)
+	FindByPhone(ctx context.Context, phone string) (*models.User, error)
+	// SetOTP stores a freshly issued OTP hash on the user, unless the last
+	// one was sent after sentBefore, reporting whether it did. The check
+	// and the write are one update, so concurrent resends can't both pass.
+	SetOTP(ctx context.Context, id primitive.ObjectID, hash string, expiresAt, sentAt, sentBefore int64) (bool, error)
+	// ConsumeOTP clears the OTP if it still has the given hash, reporting
+	// whether it did. Only one caller can consume a given code.
+	ConsumeOTP(ctx context.Context, id primitive.ObjectID, hash string) (bool, error)
+	// SetRole changes the user's role and whether password login also needs
+	// an OTP, returning the updated user.
+	SetRole(ctx context.Context, id primitive.ObjectID, role string, twoFactor bool) (*models.User, error)
+}
+
+type mongoUsers struct{}
+
+func (m *mongoUsers) Create(ctx context.Context, user *models.User) error {
+	if user.ID.IsZero() {
+		user.ID = primitive.NewObjectID()
+	}
+	_, err := db.Collection(db.Users).InsertOne(ctx, user)
+	return mongoErr(err)
+}
+
+func (m *mongoUsers) FindByID(ctx context.Context, id primitive.ObjectID) (*models.User, error) {
+	return findOne[models.User](ctx, db.Collection(db.Users), bson.M{"_id": id})
+}
+
+func (m *mongoUsers) FindByUsername(ctx context.Context, username string) (*models.User, error) {
+	return findOne[models.User](ctx, db.Collection(db.Users), bson.M{"username": username})
+}
+
+func (m *mongoUsers) FindByPhone(ctx context.Context, phone string) (*models.User, error) {
+	return findOne[models.User](ctx, db.Collection(db.Users), bson.M{"phone_number": phone})
+}
+
+func (m *mongoUsers) SetOTP(ctx context.Context, id primitive.ObjectID, hash string, expiresAt, sentAt, sentBefore int64) (bool, error) {
+	result, err := db.Collection(db.Users).UpdateOne(ctx, bson.M{
+		"_id": id,
+		"$or": bson.A{
+			bson.M{"otp_sent_at": bson.M{"$exists": false}},
+			bson.M{"otp_sent_at": bson.M{"$lte": sentBefore}},
+		},
+	}, bson.M{
+		"$set": bson.M{
+			"otp_hash":       hash,
+			"otp_expires_at": expiresAt,
+			"otp_sent_at":    sentAt,
+		},
+		"$unset": bson.M{"otp": ""},
+	})
+	if err != nil {
+		return false, err
+	}
+	return result.MatchedCount == 1, nil
+}
+
+func (m *mongoUsers) ConsumeOTP(ctx context.Context, id primitive.ObjectID, hash string) (bool, error) {
+	result, err := db.Collection(db.Users).UpdateOne(ctx, bson.M{"_id": id, "otp_hash": hash}, bson.M{
+		"$unset": bson.M{"otp_hash": "", "otp_expires_at": ""},
+	})
+	if err != nil {
+		return false, err
+	}
+	return result.ModifiedCount == 1, nil
+}
+
+func (m *mongoUsers) SetRole(ctx context.Context, id primitive.ObjectID, role string, twoFactor bool) (*models.User, error) {
+	return findOneAndUpdate[models.User](ctx, db.Collection(db.Users), bson.M{"_id": id}, bson.M{
+		"$set": bson.M{"role": role, "two_factor": twoFactor},
+	})
+}
+
+type memoryUsers struct {
+	t *table[models.User]
+}
+
+func newMemoryUsers() *memoryUsers {
+	return &memoryUsers{t: newTable[models.User]().withUnique(func(u *models.User) []string {
+		return []string{"username:" + u.Username, "phone:" + u.PhoneNumber}
+	})}
+}
+
+func (m *memoryUsers) Create(ctx context.Context, user *models.User) error {
+	if user.ID.IsZero() {
+		user.ID = primitive.NewObjectID()
+	}
+	return m.t.insert(user.ID.Hex(), user)
+}
+
+func (m *memoryUsers) FindByID(ctx context.Context, id primitive.ObjectID) (*models.User, error) {
+	return m.t.get(id.Hex())
+}
+
+func (m *memoryUsers) FindByUsername(ctx context.Context, username string) (*models.User, error) {
+	return m.t.findOne(func(u *models.User) bool { return u.Username == username })
+}
+
+func (m *memoryUsers) FindByPhone(ctx context.Context, phone string) (*models.User, error) {
+	return m.t.findOne(func(u *models.User) bool { return u.PhoneNumber == phone })
+}
+
+func (m *memoryUsers) SetOTP(ctx context.Context, id primitive.ObjectID, hash string, expiresAt, sentAt, sentBefore int64) (bool, error) {
+	stored := false
+	_, err := m.t.update(id.Hex(), func(u *models.User) error {
+		if u.OTPSentAt <= sentBefore {
+			u.OTPHash, u.OTPExpiresAt, u.OTPSentAt = hash, expiresAt, sentAt
+			stored = true
+		}
+		return nil
+	})
+	return stored, err
+}
+
+func (m *memoryUsers) ConsumeOTP(ctx context.Context, id primitive.ObjectID, hash string) (bool, error) {
+	consumed := false
+	_, err := m.t.update(id.Hex(), func(u *models.User) error {
+		if u.OTPHash == hash {
+			u.OTPHash, u.OTPExpiresAt = "", 0
+			consumed = true
+		}
+		return nil
+	})
+	if err == ErrNotFound {
+		return false, nil
+	}
+	return consumed, err
+}
+
+func (m *memoryUsers) SetRole(ctx context.Context, id primitive.ObjectID, role string, twoFactor bool) (*models.User, error) {
+	return m.t.update(id.Hex(), func(u *models.User) error {
+		u.Role, u.TwoFactor = role, twoFactor
+		return nil
+	})
+}
@@ -0,0 +1,98 @@
+// Package response writes JSON bodies, including the error envelope every
+// endpoint uses:
+//
+//	{"error": {"code": "not_found", "message": "Customer not found", "request_id": "..."}}
+package response
+
+import (
+	"adonai-api/validate"
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+)
+
+type contextKey string
+
+const requestIDKey contextKey = "request_id"
+
+// WithRequestID returns a copy of ctx carrying the request's ID.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey, id)
+}
+
+// RequestID returns the ID middleware.RequestID assigned, if any.
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey).(string)
+	return id
+}
+
+// ErrorBody is the payload under the "error" key.
+type ErrorBody struct {
+	Code      string                `json:"code"`
+	Message   string                `json:"message"`
+	Fields    []validate.FieldError `json:"fields,omitempty"`
+	RequestID string                `json:"request_id,omitempty"`
+}
+
+type envelope struct {
+	Error ErrorBody `json:"error"`
+}
+
+// JSON writes v with the given status.
+func JSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// Message writes {"message": msg}.
+func Message(w http.ResponseWriter, status int, msg string) {
+	JSON(w, status, map[string]string{"message": msg})
+}
+
+// Error writes the error envelope.
+func Error(w http.ResponseWriter, r *http.Request, status int, code, message string) {
+	JSON(w, status, envelope{Error: ErrorBody{
+		Code:      code,
+		Message:   message,
+		RequestID: RequestID(r.Context()),
+	}})
+}
+
+func BadRequest(w http.ResponseWriter, r *http.Request, message string) {
+	Error(w, r, http.StatusBadRequest, "bad_request", message)
+}
+
+func Unauthorized(w http.ResponseWriter, r *http.Request, message string) {
+	Error(w, r, http.StatusUnauthorized, "unauthorized", message)
+}
+
+func Forbidden(w http.ResponseWriter, r *http.Request) {
+	Error(w, r, http.StatusForbidden, "forbidden", "Forbidden")
+}
+
+func NotFound(w http.ResponseWriter, r *http.Request, message string) {
+	Error(w, r, http.StatusNotFound, "not_found", message)
+}
+
+func Conflict(w http.ResponseWriter, r *http.Request, message string) {
+	Error(w, r, http.StatusConflict, "conflict", message)
+}
+
+// Internal logs err with the request ID and writes a generic 500, so
+// storage details never reach the client.
+func Internal(w http.ResponseWriter, r *http.Request, err error) {
+	log.Printf("request %s: %s %s: %v", RequestID(r.Context()), r.Method, r.URL.Path, err)
+	Error(w, r, http.StatusInternalServerError, "internal", "Internal server error")
+}
+
+// Invalid writes a 422 listing every field that failed validation.
+func Invalid(w http.ResponseWriter, r *http.Request, errs validate.Errors) {
+	JSON(w, http.StatusUnprocessableEntity, envelope{Error: ErrorBody{
+		Code:      "validation_failed",
+		Message:   "Request validation failed",
+		Fields:    errs,
+		RequestID: RequestID(r.Context()),
+	}})
+}
@@ -0,0 +1,185 @@
+// Package validate checks structs against their `validate` tags.
+//
+// Supported rules, comma separated:
+//
+//	required     the field must not be its zero value (blank strings count as zero)
+//	min=N        numbers must be >= N; strings and slices need at least N elements
+//	max=N        numbers must be <= N; strings and slices hold at most N elements
+//	e164         a phone number like +14155550123 (empty is allowed unless required)
+//	oneof=a b c  the value must be one of the listed words (empty is allowed unless required)
+//
+// Nested structs and slices of structs are validated recursively; errors
+// name fields by their JSON path, e.g. "lines[2].quantity".
+package validate
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// FieldError describes one rule a field broke.
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// Errors is every FieldError found in one value.
+type Errors []FieldError
+
+func (e Errors) Error() string {
+	parts := make([]string, len(e))
+	for i, fe := range e {
+		parts[i] = fe.Field + ": " + fe.Message
+	}
+	return "validation failed: " + strings.Join(parts, "; ")
+}
+
+var e164 = regexp.MustCompile(`^\+[1-9][0-9]{1,14}$`)
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// Struct validates v, which must be a struct or a pointer to one. It returns
+// nil when every rule holds, or an Errors value otherwise.
+func Struct(v interface{}) error {
+	var errs Errors
+	walk(reflect.ValueOf(v), "", &errs)
+	if len(errs) == 0 {
+		return nil
+	}
+	return errs
+}
+
+func walk(v reflect.Value, prefix string, errs *Errors) {
+	for v.Kind() == reflect.Pointer {
+		if v.IsNil() {
+			return
+		}
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		return
+	}
+
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if !sf.IsExported() {
+			continue
+		}
+		field := v.Field(i)
+		path := joinPath(prefix, jsonName(sf))
+
+		if tag := sf.Tag.Get("validate"); tag != "" && tag != "-" {
+			for _, rule := range strings.Split(tag, ",") {
+				if msg := check(field, rule); msg != "" {
+					*errs = append(*errs, FieldError{Field: path, Message: msg})
+					break
+				}
+			}
+		}
+
+		switch field.Kind() {
+		case reflect.Struct, reflect.Pointer:
+			if field.Type() != timeType {
+				walk(field, path, errs)
+			}
+		case reflect.Slice, reflect.Array:
+			for j := 0; j < field.Len(); j++ {
+				walk(field.Index(j), fmt.Sprintf("%s[%d]", path, j), errs)
+			}
+		}
+	}
+}
+
+func check(field reflect.Value, rule string) string {
+	name, arg, _ := strings.Cut(strings.TrimSpace(rule), "=")
+	switch name {
+	case "required":
+		if isZero(field) {
+			return "is required"
+		}
+	case "min", "max":
+		limit, err := strconv.ParseFloat(arg, 64)
+		if err != nil {
+			panic(fmt.Sprintf("validate: bad %s argument %q", name, arg))
+		}
+		n, isLen := measure(field)
+		if name == "min" && n < limit {
+			if isLen {
+				return fmt.Sprintf("must have at least %s elements", arg)
+			}
+			return fmt.Sprintf("must be at least %s", arg)
+		}
+		if name == "max" && n > limit {
+			if isLen {
+				return fmt.Sprintf("must have at most %s elements", arg)
+			}
+			return fmt.Sprintf("must be at most %s", arg)
+		}
+	case "e164":
+		if s := field.String(); s != "" && !e164.MatchString(s) {
+			return "must be an E.164 phone number such as +14155550123"
+		}
+	case "oneof":
+		s := fmt.Sprint(field.Interface())
+		if s == "" {
+			return ""
+		}
+		allowed := strings.Fields(arg)
+		for _, a := range allowed {
+			if s == a {
+				return ""
+			}
+		}
+		return "must be one of: " + strings.Join(allowed, ", ")
+	default:
+		panic(fmt.Sprintf("validate: unknown rule %q", name))
+	}
+	return ""
+}
+
+func isZero(field reflect.Value) bool {
+	if field.Kind() == reflect.String {
+		return strings.TrimSpace(field.String()) == ""
+	}
+	if field.Kind() == reflect.Slice {
+		return field.Len() == 0
+	}
+	return field.IsZero()
+}
+
+// measure returns a number's value, or a string's or slice's length.
+func measure(field reflect.Value) (float64, bool) {
+	switch field.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(field.Int()), false
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(field.Uint()), false
+	case reflect.Float32, reflect.Float64:
+		return field.Float(), false
+	case reflect.String:
+		return float64(len([]rune(field.String()))), true
+	case reflect.Slice, reflect.Array, reflect.Map:
+		return float64(field.Len()), true
+	}
+	panic(fmt.Sprintf("validate: min/max on %s", field.Kind()))
+}
+
+func jsonName(sf reflect.StructField) string {
+	name, _, _ := strings.Cut(sf.Tag.Get("json"), ",")
+	if name == "" || name == "-" {
+		return sf.Name
+	}
+	return name
+}
+
+func joinPath(prefix, name string) string {
+	if prefix == "" {
+		return name
+	}
+	return prefix + "." + name
+}